@@ -241,6 +241,17 @@ func (e *gerritError) Error() string {
 // anti-xss line (]})' or some such) followed by JSON.
 // If requestBody != nil, gerritAPI sets the Content-Type to application/json.
 func gerritAPI(path string, requestBody []byte, target interface{}) (err error) {
+	method := "GET"
+	if requestBody != nil {
+		method = "POST"
+	}
+	return gerritAPIMethod(method, path, requestBody, target)
+}
+
+// gerritAPIMethod is like gerritAPI but uses the given HTTP method,
+// for the few endpoints that need something other than GET or POST.
+// It accepts a 204 No Content response, which carries no JSON.
+func gerritAPIMethod(method, path string, requestBody []byte, target interface{}) (err error) {
 	var respBodyBytes []byte
 	defer func() {
 		if err != nil {
@@ -265,10 +276,8 @@ func gerritAPI(path string, requestBody []byte, target interface{}) (err error)
 	}
 
 	url := auth.url + path
-	method := "GET"
 	var reader io.Reader
 	if requestBody != nil {
-		method = "POST"
 		reader = bytes.NewReader(requestBody)
 	}
 	req, err := http.NewRequest(method, url, reader)
@@ -299,6 +308,9 @@ func gerritAPI(path string, requestBody []byte, target interface{}) (err error)
 	if err != nil {
 		return fmt.Errorf("reading response body: %v", err)
 	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
 	if resp.StatusCode != http.StatusOK {
 		return &gerritError{url, resp.StatusCode, resp.Status, string(body)}
 	}
@@ -401,6 +413,7 @@ type GerritChange struct {
 	ID                     string
 	Project                string
 	Branch                 string
+	Topic                  string
 	ChangeId               string `json:"change_id"`
 	Subject                string
 	Status                 string
@@ -414,8 +427,12 @@ type GerritChange struct {
 	CurrentRevision        string `json:"current_revision"`
 	Revisions              map[string]*GerritRevision
 	Messages               []*GerritMessage
-	TotalCommentCount      int `json:"total_comment_count"`
-	UnresolvedCommentCount int `json:"unresolved_comment_count"`
+	TotalCommentCount      int                         `json:"total_comment_count"`
+	UnresolvedCommentCount int                         `json:"unresolved_comment_count"`
+	Mergeable              *bool                       // nil if not reported by the server
+	Submittable            *bool                       // nil unless requested with "SUBMITTABLE"
+	SubmitRequirements     []*GerritSubmitRequirement  `json:"submit_requirements"`
+	Reviewers              map[string][]*GerritAccount // by state: "REVIEWER", "CC", or "REMOVED"; set with detailed labels
 }
 
 // LabelNames returns the label names for the change, in lexicographic order.
@@ -433,7 +450,9 @@ type GerritMessage struct {
 	Author struct {
 		Name string
 	}
-	Message string
+	Message        string
+	Date           string
+	RevisionNumber int `json:"_revision_number"`
 }
 
 // GerritLabel is the JSON struct for a Gerrit LabelInfo.
@@ -445,6 +464,12 @@ type GerritLabel struct {
 	All      []*GerritApproval
 }
 
+// GerritSubmitRequirement is the JSON struct for a Gerrit SubmitRequirementResultInfo.
+type GerritSubmitRequirement struct {
+	Name   string
+	Status string // "SATISFIED", "UNSATISFIED", "OVERRIDDEN", "NOT_APPLICABLE", "ERROR", or "FORCED"
+}
+
 // GerritAccount is the JSON struct for a Gerrit AccountInfo.
 type GerritAccount struct {
 	ID       int `json:"_account_id"`
@@ -475,14 +500,14 @@ type GerritFetch struct {
 
 // GerritComment is the JSON struct for a Gerrit CommentInfo.
 type GerritComment struct {
-	PatchSet        string `json:"patch_set"`
+	PatchSet        int `json:"patch_set"`
 	ID              string
 	Path            string
 	Side            string
-	Parent          string
-	Line            string
+	Parent          int
+	Line            int
 	Range           *GerritCommentRange
-	InReplyTo       string
+	InReplyTo       string `json:"in_reply_to"`
 	Message         string
 	Updated         string
 	Author          *GerritAccount
@@ -517,3 +542,10 @@ type GerritCommentInput struct {
 	Message    string              `json:"message,omitempty"`
 	Unresolved *bool               `json:"unresolved,omitempty"` // defaults to parent setting or else false
 }
+
+// GerritReviewInput is the JSON struct for a Gerrit ReviewInput.
+type GerritReviewInput struct {
+	Message  string                           `json:"message,omitempty"`
+	Labels   map[string]int                   `json:"labels,omitempty"`
+	Comments map[string][]*GerritCommentInput `json:"comments,omitempty"`
+}
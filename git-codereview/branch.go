@@ -25,6 +25,7 @@ type Branch struct {
 	originBranch  string    // upstream origin branch
 	commitsAhead  int       // number of commits ahead of origin branch
 	branchpoint   string    // latest commit hash shared with origin branch
+	rootPending   bool      // oldest pending commit is a root commit, so there is no branchpoint
 	pending       []*Commit // pending commits, newest first (children before parents)
 
 	config map[string]string // cached config; use Config instead
@@ -46,9 +47,11 @@ type Commit struct {
 	AuthorDate  string // author date as Unix timestamp string (from %at)
 
 	// For use by pending command.
-	g         *GerritChange // associated Gerrit change data
-	gerr      error         // error loading Gerrit data
-	committed []string      // list of files in this commit
+	g             *GerritChange // associated Gerrit change data
+	gerr          error         // error loading Gerrit data
+	committed     []string      // list of files in this commit
+	newComments   int           // comments added since last 'pending -new'
+	unmailedEdits bool          // change was mailed, but not at this hash
 }
 
 // HasParent reports whether hash appears in c.Parents.
@@ -77,8 +80,12 @@ func (b *Branch) Config() map[string]string {
 		}
 	}
 	cfg, err := parseConfig(cfgText)
+	if err != nil && b.Current {
+		// The config in the work tree is the one the user is editing.
+		dief("%s:%v", filepath.Join(repoRoot(), "codereview.cfg"), err)
+	}
 	if err != nil {
-		verbosef("failed to load config for branch %v: %v", b.Name, err)
+		fmt.Fprintf(stderr(), "warning: ignoring codereview.cfg on branch %s: line %v\n", b.Name, err)
 		cfg = make(map[string]string)
 	}
 	b.config = cfg
@@ -137,16 +144,7 @@ func (b *Branch) OriginBranch() string {
 		}
 		if upstream == "" {
 			// Assume branch was created before we set upstream correctly.
-			// See if origin/main exists; if so, use it.
-			// Otherwise, fall back to origin/master.
-			argv := []string{"git", "rev-parse", "--abbrev-ref", "origin/main"}
-			cmd := exec.Command(argv[0], argv[1:]...)
-			setEnglishLocale(cmd)
-			if err := cmd.Run(); err == nil {
-				upstream = "origin/main"
-			} else {
-				upstream = "origin/master"
-			}
+			upstream = defaultOriginBranch()
 		}
 		if gitUpstream != upstream && b.Current {
 			// Best effort attempt to correct setting for next time,
@@ -159,6 +157,18 @@ func (b *Branch) OriginBranch() string {
 	return b.originBranch
 }
 
+// defaultOriginBranch returns origin/main if that branch exists,
+// or else origin/master.
+func defaultOriginBranch() string {
+	argv := []string{"git", "rev-parse", "--abbrev-ref", "origin/main"}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	setEnglishLocale(cmd)
+	if err := cmd.Run(); err == nil {
+		return "origin/main"
+	}
+	return "origin/master"
+}
+
 func (b *Branch) gitOriginBranch() string {
 	argv := []string{"git", "rev-parse", "--abbrev-ref", b.Name + "@{u}"}
 	cmd := exec.Command(argv[0], argv[1:]...)
@@ -208,6 +218,8 @@ func (b *Branch) Pending() []*Commit {
 
 // Branchpoint returns an identifier for the latest revision
 // common to both this branch and its upstream branch.
+// It returns "" if there is none, because the branch's pending commits
+// start from a root commit, as on a branch created by change -orphan.
 func (b *Branch) Branchpoint() string {
 	b.NeedOriginBranch("<internal branchpoint>")
 	b.loadPending()
@@ -233,13 +245,15 @@ func (b *Branch) loadPending() {
 		return
 	}
 	b.loadedPending = true
+	b.pending = nil
+	b.rootPending = false
 
 	// In case of early return.
 	// But avoid the git exec unless really needed.
 	b.branchpoint = ""
 	defer func() {
-		if b.branchpoint == "" {
-			b.branchpoint = gitHash("HEAD")
+		if b.branchpoint == "" && !b.rootPending {
+			b.branchpoint = gitHash(b.FullName())
 		}
 	}()
 
@@ -314,11 +328,18 @@ Log:
 		b.branchpoint = c.Parent
 	}
 	b.commitsAhead = len(b.pending)
+	// A branch created by change -orphan shares no history with its origin branch.
+	b.rootPending = len(b.pending) > 0 && len(b.pending[len(b.pending)-1].Parents) == 0
 }
 
 // CommitsBehind reports the number of commits present upstream
 // that are not present in the current branch.
+// A branch that shares no history with upstream is not behind it.
 func (b *Branch) CommitsBehind() int {
+	b.loadPending()
+	if b.rootPending {
+		return 0
+	}
 	return len(lines(cmdOutput("git", "log", "--format=format:x", b.FullName()+".."+b.OriginBranch(), "--")))
 }
 
@@ -425,6 +446,32 @@ func OriginBranches() []string {
 	return branches
 }
 
+// FetchedOriginBranches returns the names of the origin branches
+// found on the server by the most recent "git fetch", as recorded in
+// FETCH_HEAD. Unlike OriginBranches, the result omits branches that
+// have since been deleted on the server, because git fetch does not
+// remove their remote-tracking branches unless asked to prune them.
+// It returns nil if FETCH_HEAD cannot be read.
+func FetchedOriginBranches() []string {
+	data, err := os.ReadFile(gitPath("FETCH_HEAD"))
+	if err != nil {
+		return nil
+	}
+	var branches []string
+	for _, line := range nonBlankLines(string(data)) {
+		// Lines are like "hash<tab>not-for-merge<tab>branch 'main' of url".
+		f := strings.Split(line, "\t")
+		if len(f) < 3 || !strings.HasPrefix(f[2], "branch '") {
+			continue
+		}
+		name := strings.TrimPrefix(f[2], "branch '")
+		if i := strings.Index(name, "' of "); i >= 0 {
+			branches = append(branches, "origin/"+name[:i])
+		}
+	}
+	return branches
+}
+
 // GerritChange returns the change metadata from the Gerrit server
 // for the branch's pending change.
 // The extra strings are passed to the Gerrit API request as o= parameters,
@@ -527,16 +574,69 @@ func (b *Branch) DefaultCommit(action, extra string) *Commit {
 // ListFiles returns the list of files in a given commit.
 func ListFiles(c *Commit) []string {
 	if c.Parent == "" {
+		if len(c.Parents) == 0 && c.Hash != "" {
+			// A root commit adds all its files.
+			return nonBlankLines(cmdOutput("git", "diff", "--name-only", emptyTree(), c.Hash, "--"))
+		}
 		return nil
 	}
 	return nonBlankLines(cmdOutput("git", "diff", "--name-only", c.Parent, c.Hash, "--"))
 }
 
+// emptyTree returns the hash of the empty tree,
+// for comparing a root commit against.
+func emptyTree() string {
+	// With no input, git mktree writes the empty tree.
+	// (Its hash depends on the repository's hash algorithm.)
+	return trim(cmdOutput("git", "mktree"))
+}
+
 func cmdBranchpoint(args []string) {
-	expectZeroArgs(args, "branchpoint")
-	b := CurrentBranch()
-	b.NeedOriginBranch("branchpoint")
-	fmt.Fprintf(stdout(), "%s\n", b.Branchpoint())
+	// NOTE: New flags should be added to the usage message below as well as doc.go.
+	var all bool
+	var verify string
+	flags.BoolVar(&all, "all", false, "print branchpoints for all local branches")
+	flags.StringVar(&verify, "verify", "", "print nothing; exit 0 if the branchpoint is `rev`, 1 otherwise")
+	flags.Parse(args)
+	if len(flags.Args()) > 0 || all && verify != "" {
+		fmt.Fprintf(stderr(), "Usage: %s branchpoint %s [-all | -verify rev]\n", progName, globalFlags)
+		exit(2)
+	}
+
+	if verify != "" {
+		hash, err := cmdOutputErr("git", "rev-parse", "--verify", "--quiet", verify+"^{commit}")
+		if err != nil {
+			dief("cannot branchpoint -verify: unknown revision %s", verify)
+		}
+		b := CurrentBranch()
+		b.NeedOriginBranch("branchpoint")
+		if trim(hash) != b.Branchpoint() {
+			exit(1)
+		}
+		return
+	}
+
+	if !all {
+		b := CurrentBranch()
+		b.NeedOriginBranch("branchpoint")
+		if b.Branchpoint() == "" {
+			dief("cannot branchpoint: %s shares no history with %s", b.Name, b.OriginBranch())
+		}
+		fmt.Fprintf(stdout(), "%s\n", b.Branchpoint())
+		return
+	}
+
+	// List only the refs/heads branches: unlike LocalBranches,
+	// this omits the current commit in detached HEAD mode,
+	// which has no origin branch to compare against.
+	for _, ref := range nonBlankLines(cmdOutput("git", "for-each-ref", "--format=%(refname)", "refs/heads/")) {
+		b := &Branch{Name: strings.TrimPrefix(ref, "refs/heads/")}
+		b.NeedOriginBranch("branchpoint")
+		if b.Branchpoint() == "" {
+			continue // no history shared with origin
+		}
+		fmt.Fprintf(stdout(), "%s %s\n", b.Name, b.Branchpoint())
+	}
 }
 
 func cmdRebaseWork(args []string) {
@@ -548,5 +648,48 @@ func cmdRebaseWork(args []string) {
 	if len(b.Pending()) == 0 {
 		dief("no pending work")
 	}
-	run("git", "rebase", "-i", b.Branchpoint())
+	run("git", "rebase", "-i", rebaseBase(b))
+}
+
+// rebaseBase returns the git rebase argument naming
+// the commit that b's pending commits are based on.
+func rebaseBase(b *Branch) string {
+	if b.Branchpoint() == "" {
+		return "--root"
+	}
+	return b.Branchpoint()
+}
+
+func cmdAmendFixup(args []string) {
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s amend-fixup %s <commit>\n", progName, globalFlags)
+		exit(2)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) != 1 {
+		flags.Usage()
+		exit(2)
+	}
+
+	b := CurrentBranch()
+	if b.DetachedHead() {
+		dief("cannot amend-fixup: on detached head")
+	}
+	if _, err := cmdOutputErr("git", "rev-parse", "--abbrev-ref", "MERGE_HEAD"); err == nil {
+		diePendingMerge("amend-fixup")
+	}
+	if _, err := os.Stat(filepath.Join(gitPathDir(), "rebase-merge")); err == nil {
+		dief("cannot amend-fixup: found pending rebase or sync")
+	}
+	c := b.CommitByRev("amend-fixup", flags.Arg(0))
+	if !HasStagedChanges() {
+		dief("cannot amend-fixup: no staged changes\n" +
+			"\trun 'git add' to stage the changes to fold into the commit")
+	}
+	// The rebase needs a clean working directory.
+	checkUnstaged("amend-fixup")
+
+	run("git", "commit", "-q", "--fixup="+c.Hash)
+	run("git", "-c", "sequence.editor=:", "rebase", "-q", "-i", "--autosquash", rebaseBase(b))
+	printf("amended %s %s.", c.ShortHash, c.Subject)
 }
@@ -135,6 +135,26 @@ func TestBranchpoint(t *testing.T) {
 	}
 }
 
+func TestBranchpointVerify(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	testMain(t, "branchpoint", "-verify", "origin/main")
+	testNoStdout(t)
+	testNoStderr(t)
+
+	testMainDied(t, "branchpoint", "-verify", "HEAD")
+	testNoStdout(t)
+	testNoStderr(t)
+
+	testMainDied(t, "branchpoint", "-verify", "nosuchrev")
+	testPrintedStderr(t, "unknown revision nosuchrev")
+
+	testMainDied(t, "branchpoint", "-all", "-verify", "HEAD")
+	testPrintedStderr(t, "Usage:")
+}
+
 func TestRebaseWork(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
@@ -190,3 +210,68 @@ func TestBranchpointMerge(t *testing.T) {
 		t.Fatalf("branchpoint=%q, want %q", bp, hash)
 	}
 }
+
+func TestBranchpointAll(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	hash := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+	gt.work(t)
+	gt.work(t)
+
+	// Branch other from main with a commit of its own.
+	trun(t, gt.client, "git", "checkout", "-b", "other", "origin/main")
+	trun(t, gt.client, "git", "branch", "--set-upstream-to", "origin/main")
+	write(t, gt.client+"/otherfile", "other", 0644)
+	trun(t, gt.client, "git", "add", "otherfile")
+	trun(t, gt.client, "git", "commit", "-m", "other")
+
+	testMain(t, "branchpoint", "-all")
+	testPrintedStdout(t, "main "+hash+"\n", "other "+hash+"\n", "work "+hash+"\n")
+	testNoStderr(t)
+
+	// Detached HEAD is skipped.
+	trun(t, gt.client, "git", "checkout", "-q", "HEAD^0")
+	testMain(t, "branchpoint", "-all")
+	testPrintedStdout(t, "main "+hash+"\n", "other "+hash+"\n", "work "+hash+"\n", "!HEAD")
+}
+
+func TestAmendFixup(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	gt.workFile(t, "file2")
+	pending := CurrentBranch().Pending()
+	lower := pending[1]
+
+	testMainDied(t, "amend-fixup", "HEAD^")
+	testPrintedStderr(t, "cannot amend-fixup: no staged changes")
+
+	testMainDied(t, "amend-fixup", "origin/main")
+	testPrintedStderr(t, "not found in the current branch")
+
+	write(t, gt.client+"/fixfile", "fix", 0644)
+	trun(t, gt.client, "git", "add", "fixfile")
+	write(t, gt.client+"/file2", "unstaged", 0644)
+	testMainDied(t, "amend-fixup", "HEAD^")
+	testPrintedStderr(t, "cannot amend-fixup: unstaged changes exist")
+	trun(t, gt.client, "git", "checkout", "file2")
+
+	testMain(t, "amend-fixup", "HEAD^")
+	testPrintedStderr(t, "amended "+lower.ShortHash)
+
+	pending = CurrentBranch().Pending()
+	if len(pending) != 2 {
+		t.Fatalf("have %d pending commits, want 2", len(pending))
+	}
+	if pending[1].Subject != lower.Subject {
+		t.Fatalf("lower commit subject = %q, want %q", pending[1].Subject, lower.Subject)
+	}
+	if files := ListFiles(pending[1]); strings.Join(files, ",") != "file,fixfile" {
+		t.Fatalf("lower commit files = %v, want [file fixfile]", files)
+	}
+	if files := ListFiles(pending[0]); strings.Join(files, ",") != "file2" {
+		t.Fatalf("top commit files = %v, want [file2]", files)
+	}
+}
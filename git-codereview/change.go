@@ -7,8 +7,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -17,19 +19,94 @@ var commitMsg string
 var changeAuto bool
 var changeQuick bool
 var changeSignoff bool
+var changeNoSignoff bool
+var changeNew bool
+var changeStrictMessage bool
+var changeEditMessage bool
+var changeTrack string
+var changeNoGPGSign bool
+var changeList bool
+var changeTouch bool
+var changeFixUpstream bool
+var changeDetach bool
+var changeRecover bool
+var changeManifest string
+var changeForce bool
+var changeOrphan bool
+var changePathspecs []string // paths to commit, from arguments after --
 
 func cmdChange(args []string) {
 	// NOTE: New flags should be added to the usage message below as well as doc.go.
 	flags.StringVar(&commitMsg, "m", "", "specify a commit message")
 	flags.BoolVar(&changeAuto, "a", false, "add changes to any tracked files")
+	flags.BoolVar(&changeForce, "f", false, "amend the pending commit even if it has been submitted")
 	flags.BoolVar(&changeQuick, "q", false, "do not edit pending commit msg")
 	flags.BoolVar(&changeSignoff, "s", false, "add a Signed-off-by trailer at the end of the commit message")
+	flags.BoolVar(&changeNoSignoff, "no-signoff", false, "do not add a Signed-off-by trailer, overriding always-signoff")
+	flags.BoolVar(&changeNew, "new", false, "create a new branch from the detached HEAD")
+	flags.BoolVar(&changeStrictMessage, "strict-message", false, "fail on a nonstandard commit message when not run interactively")
+	flags.BoolVar(&changeEditMessage, "edit-message", false, "edit the message of the named pending commit, like reword")
+	flags.StringVar(&changeTrack, "track", "", "create a new branch tracking the named `origin-branch`")
+	flags.StringVar(&changeManifest, "from-manifest", "", "create the tracking branches listed in the manifest `file`")
+	flags.BoolVar(&changeNoGPGSign, "no-gpg-sign", false, "do not GPG-sign the commit, overriding commit.gpgsign")
+	flags.BoolVar(&changeList, "list", false, "list the local branches with pending commits")
+	flags.BoolVar(&changeTouch, "touch", false, "amend the pending commit, setting its committer date to now")
+	flags.BoolVar(&changeDetach, "detach", false, "check out the named CL in detached HEAD mode instead of on a new branch")
+	flags.BoolVar(&changeRecover, "recover", false, "recreate the named deleted branch at its last tip in the HEAD reflog")
+	flags.BoolVar(&changeOrphan, "orphan", false, "create a new branch with no history, starting from an empty commit")
+	flags.BoolVar(&changeFixUpstream, "fix-upstream", false, "correct a missing or wrong upstream setting for the current branch")
 	flags.Parse(args)
-	if len(flags.Args()) > 1 {
-		fmt.Fprintf(stderr(), "Usage: %s change %s [-a] [-m msg] [-q] [branch]\n", progName, globalFlags)
+	rest := flags.Args()
+	changePathspecs = nil
+	if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+		// flags.Parse consumed the -- separating flags from paths.
+		changePathspecs, rest = rest, nil
+	} else {
+		for i, arg := range rest {
+			if arg == "--" {
+				changePathspecs, rest = rest[i+1:], rest[:i]
+				break
+			}
+		}
+	}
+	special := 0
+	for _, set := range []bool{changeNew, changeEditMessage, changeTrack != "", changeRecover, changeOrphan} {
+		if set {
+			special++
+		}
+	}
+	if len(rest) > 1 || special > 1 || special == 1 && len(rest) != 1 ||
+		len(changePathspecs) > 0 && (special > 0 || len(rest) > 0) ||
+		changeList && (special > 0 || len(rest) > 0 || len(changePathspecs) > 0) ||
+		changeFixUpstream && (changeList || special > 0 || len(rest) > 0 || len(changePathspecs) > 0) ||
+		changeTouch && (special > 0 || len(rest) > 0) ||
+		changeManifest != "" && (special > 0 || len(rest) > 0 || len(changePathspecs) > 0 || changeList || changeFixUpstream || changeTouch) ||
+		changeSignoff && changeNoSignoff ||
+		changeDetach && (special > 0 || len(rest) != 1 || changeList || changeTouch) {
+		fmt.Fprintf(stderr(), "Usage: %s change %s [-a] [-f] [-m msg] [-no-gpg-sign] [-q] [-s | -no-signoff] [-touch] [branch | -]\n", progName, globalFlags)
+		fmt.Fprintf(stderr(), "       %s change %s [-f] [-m msg] [-no-gpg-sign] [-q] [-s | -no-signoff] -- path...\n", progName, globalFlags)
+		fmt.Fprintf(stderr(), "       %s change %s -detach cl\n", progName, globalFlags)
+		fmt.Fprintf(stderr(), "       %s change %s -new branch\n", progName, globalFlags)
+		fmt.Fprintf(stderr(), "       %s change %s -recover branch\n", progName, globalFlags)
+		fmt.Fprintf(stderr(), "       %s change %s [-m msg] -orphan branch\n", progName, globalFlags)
+		fmt.Fprintf(stderr(), "       %s change %s -edit-message commit\n", progName, globalFlags)
+		fmt.Fprintf(stderr(), "       %s change %s -track origin-branch branch\n", progName, globalFlags)
+		fmt.Fprintf(stderr(), "       %s change %s -from-manifest file\n", progName, globalFlags)
+		fmt.Fprintf(stderr(), "       %s change %s -list\n", progName, globalFlags)
+		fmt.Fprintf(stderr(), "       %s change %s -fix-upstream\n", progName, globalFlags)
 		exit(2)
 	}
 
+	if changeList {
+		listPendingBranches()
+		return
+	}
+
+	if changeFixUpstream {
+		fixUpstream(CurrentBranch())
+		return
+	}
+
 	if _, err := cmdOutputErr("git", "rev-parse", "--abbrev-ref", "MERGE_HEAD"); err == nil {
 		diePendingMerge("change")
 	}
@@ -39,8 +116,46 @@ func cmdChange(args []string) {
 		dief("cannot change: found pending rebase or sync")
 	}
 
+	if changeNew {
+		createFromDetached(rest[0])
+		return
+	}
+
+	if changeRecover {
+		recoverBranch(rest[0])
+		return
+	}
+
+	if changeOrphan {
+		createOrphan(rest[0])
+		return
+	}
+
+	if changeEditMessage {
+		// Same as 'git codereview reword commit'.
+		reword(rest, false, nil)
+		return
+	}
+
+	if changeTrack != "" {
+		createTracking(changeTrack, rest[0])
+		return
+	}
+
+	if changeManifest != "" {
+		createFromManifest(changeManifest)
+		return
+	}
+
 	// Checkout or create branch, if specified.
-	target := flags.Arg(0)
+	target := ""
+	if len(rest) == 1 {
+		target = rest[0]
+	}
+	if target == "-" {
+		// Like git checkout -, switch to the previous branch.
+		target = previousBranch()
+	}
 	if target != "" {
 		checkoutOrCreate(target)
 		b := CurrentBranch()
@@ -56,13 +171,91 @@ func cmdChange(args []string) {
 	amend := b.HasPendingCommit()
 	if amend {
 		// Dies if there is not exactly one commit.
-		b.DefaultCommit("amend change", "")
+		c := b.DefaultCommit("amend change", "")
+		if b.Submitted(c.ChangeID) && !changeForce {
+			dief("cannot amend change: %s %s has already been submitted\n"+
+				"\trun 'git codereview sync' to sync with the submitted change first,\n"+
+				"\tor 'git codereview change -f' to amend it anyway", c.ShortHash, c.Subject)
+		}
+	} else if changeTouch {
+		dief("cannot change -touch: no pending commit")
 	}
 	commitChanges(amend)
 	b.loadedPending = false // force reload after commitChanges
 	b.check()
 }
 
+// previousBranch returns the name of the branch checked out
+// before the current one, as git checkout - does:
+// it is the branch that the most recent checkout moved away from.
+// (Asking git rev-parse about @{-1} would fail when a tag
+// has the same name as the branch.)
+func previousBranch() string {
+	out, _ := cmdOutputErr("git", "reflog", "show", "--format=%gs", "HEAD", "--")
+	for _, line := range nonBlankLines(out) {
+		const prefix = "checkout: moving from "
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		from := line[len(prefix):]
+		if i := strings.LastIndex(from, " to "); i >= 0 {
+			name := from[:i]
+			if _, err := cmdOutputErr("git", "rev-parse", "-q", "--verify", "refs/heads/"+name); err == nil {
+				return name
+			}
+		}
+		break
+	}
+	dief("cannot change -: no previous branch")
+	panic("unreachable")
+}
+
+// listPendingBranches prints the names of the local branches
+// that have pending commits, one per line.
+func listPendingBranches() {
+	w := stdout()
+	for _, b := range LocalBranches() {
+		if !b.DetachedHead() && b.HasPendingCommit() {
+			fmt.Fprintf(w, "%s\n", b.Name)
+		}
+	}
+}
+
+// fixUpstream sets the upstream branch of b to the origin branch
+// that OriginBranch would choose, if it is missing or different,
+// and reports the change.
+func fixUpstream(b *Branch) {
+	if b.DetachedHead() {
+		dief("cannot change -fix-upstream: on detached head")
+	}
+
+	// The upstream may be unset, or set to a branch that no longer exists,
+	// in which case git rev-parse fails.
+	current := ""
+	if out, err := cmdOutputErr("git", "rev-parse", "--abbrev-ref", b.Name+"@{u}"); err == nil {
+		current = strings.TrimSpace(out)
+	}
+	want := current
+	if cfg := b.Config()["branch"]; cfg != "" {
+		want = "origin/" + cfg
+	} else if want == "" {
+		want = defaultOriginBranch()
+	}
+	if current == want {
+		printf("%s already tracks %s.", b.Name, want)
+		return
+	}
+	if _, err := cmdOutputErr("git", "rev-parse", "-q", "--verify", "refs/remotes/"+want); err != nil {
+		dief("cannot change -fix-upstream: %s does not exist; run 'git fetch' and try again", want)
+	}
+	run("git", "branch", "-q", "-u", want, b.Name)
+	if current == "" {
+		printf("%s now tracks %s (was not tracking a branch).", b.Name, want)
+	} else {
+		printf("%s now tracks %s (was %s).", b.Name, want, current)
+	}
+}
+
 func (b *Branch) check() {
 	staged, unstaged, _ := LocalChanges()
 	if len(staged) == 0 && len(unstaged) == 0 {
@@ -76,21 +269,41 @@ func (b *Branch) check() {
 	}
 }
 
+// checkNotUpstreamBranch dies if b has the same name as its upstream branch,
+// as when on main tracking origin/main. Changes belong on work branches,
+// so that the local copy of the upstream branch stays in sync with the server.
+func checkNotUpstreamBranch(b *Branch) {
+	if b.DetachedHead() || b.OriginBranch() != "origin/"+b.Name {
+		return
+	}
+	dief("cannot change: %s is the name of the upstream branch\n"+
+		"\trun 'git codereview change <name>' to create a work branch for the change\n"+
+		"\tor 'git stash' to set aside the changes first", b.Name)
+}
+
 var testCommitMsg string
 
 func commitChanges(amend bool) {
+	checkNotUpstreamBranch(CurrentBranch())
+
 	// git commit will run the gofmt hook.
 	// Run it now to give a better error (won't show a git commit command failing).
 	hookGofmt()
 
-	if HasUnstagedChanges() && !HasStagedChanges() && !changeAuto {
+	if HasUnstagedChanges() && !HasStagedChanges() && !changeAuto && len(changePathspecs) == 0 {
 		printf("warning: unstaged changes and no staged changes; use 'git add' or 'git change -a'")
 	}
+	if config()["module-guard"] == "true" {
+		checkModules()
+	}
+	if config()["generated-files"] != "" && config()["generated-sources"] != "" {
+		checkGenerated()
+	}
 	commit := func(amend bool) {
 		args := []string{"commit", "-q", "--allow-empty"}
 		if amend {
 			args = append(args, "--amend")
-			if changeQuick {
+			if changeQuick || changeTouch {
 				args = append(args, "--no-edit")
 			}
 		}
@@ -99,16 +312,56 @@ func commitChanges(amend bool) {
 		} else if testCommitMsg != "" {
 			args = append(args, "-m", testCommitMsg)
 		}
-		if changeAuto {
+		if changeAuto && len(changePathspecs) == 0 {
+			// With paths, git commit takes the changes to exactly those paths.
 			args = append(args, "-a")
 		}
-		if changeSignoff {
+		if changeSignoff || config()["always-signoff"] == "true" && !changeNoSignoff {
 			args = append(args, "-s")
 		}
-		run("git", args...)
+		if changeNoGPGSign {
+			args = append(args, "--no-gpg-sign")
+		}
+		if len(changePathspecs) > 0 {
+			args = append(args, "--")
+			args = append(args, changePathspecs...)
+		}
+		if commitMsg == "" && testCommitMsg == "" && !(amend && (changeQuick || changeTouch)) {
+			checkEditor("change")
+		}
+		var env []string
+		if amend && changeTouch {
+			// An amended commit keeps its author and author date
+			// and gets the current time as its committer date,
+			// unless GIT_COMMITTER_DATE says otherwise. Make sure it doesn't.
+			env = environWithout("GIT_COMMITTER_DATE")
+		}
+		if err := runEnvErr(env, "git", args...); err != nil {
+			if *verbose == 0 {
+				fmt.Fprintf(stderr(), "(running: %s)\n", commandString("git", args))
+			}
+			// A broken GPG setup makes every commit fail,
+			// and git's own message does not say how to get past it.
+			gpgsign, _ := cmdOutputErr("git", "config", "--bool", "commit.gpgsign")
+			if !changeNoGPGSign && trim(gpgsign) == "true" {
+				dief("%v\n"+
+					"\tcommit.gpgsign is set; if signing the commit failed,\n"+
+					"\tuse '%s change -no-gpg-sign' to commit without signing", err, progName)
+			}
+			dief("%v", err)
+		}
 	}
 	commit(amend)
 	for !commitMessageOK() {
+		if !stdinIsTerminal() {
+			// Nobody to answer the prompt.
+			if changeStrictMessage {
+				dief("commit message does not use the standard form\n" +
+					"\trun 'git codereview reword' to fix it")
+			}
+			printf("warning: keeping nonstandard commit message; standard input is not a terminal")
+			break
+		}
 		fmt.Print("re-edit commit message (y/n)? ")
 		if !scanYes() {
 			break
@@ -118,10 +371,91 @@ func commitChanges(amend bool) {
 	printf("change updated.")
 }
 
+// checkModules prints a warning if the files about to be committed
+// belong to more than one Go module, as identified by the nearest
+// go.mod file in the directories above each file.
+// Files outside any module are ignored.
+func checkModules() {
+	staged, unstaged, _ := LocalChanges()
+	files := staged
+	if changeAuto {
+		files = append(files, unstaged...)
+	}
+	root := repoRoot()
+	modOf := map[string]string{} // directory -> module root ("" for none)
+	var findMod func(dir string) string
+	findMod = func(dir string) string {
+		if mod, ok := modOf[dir]; ok {
+			return mod
+		}
+		mod := ""
+		if _, err := os.Stat(filepath.Join(root, dir, "go.mod")); err == nil {
+			mod = dir
+		} else if dir != "." {
+			mod = findMod(path.Dir(dir))
+		}
+		modOf[dir] = mod
+		return mod
+	}
+	mods := map[string]bool{}
+	for _, file := range files {
+		if i := strings.Index(file, " -> "); i >= 0 {
+			file = file[i+len(" -> "):]
+		}
+		if mod := findMod(path.Dir(file)); mod != "" {
+			mods[mod] = true
+		}
+	}
+	if len(mods) <= 1 {
+		return
+	}
+	var list []string
+	for mod := range mods {
+		list = append(list, mod)
+	}
+	sort.Strings(list)
+	printf("warning: committing files from %d modules: %s", len(list), strings.Join(list, ", "))
+}
+
+// checkGenerated prints a warning if the files being committed include
+// generated files, as listed by the "generated-files" configuration key,
+// but none of the sources they are generated from, as listed by the
+// "generated-sources" key, or the other way around.
+// Either can mean that the generated files were not regenerated.
+func checkGenerated() {
+	staged, unstaged, _ := LocalChanges()
+	files := staged
+	if changeAuto {
+		files = append(files, unstaged...)
+	}
+	var generated, sources []string
+	for _, file := range files {
+		if i := strings.Index(file, " -> "); i >= 0 {
+			file = file[i+len(" -> "):]
+		}
+		switch {
+		case configMatch("generated-files", file):
+			generated = append(generated, file)
+		case configMatch("generated-sources", file):
+			sources = append(sources, file)
+		}
+	}
+	switch {
+	case len(generated) > 0 && len(sources) == 0:
+		printf("warning: committing generated files without changes to their sources: %s", strings.Join(generated, ", "))
+	case len(sources) > 0 && len(generated) == 0:
+		printf("warning: committing changes to generator sources without regenerated files: %s\n"+
+			"\tdid you forget to regenerate?", strings.Join(sources, ", "))
+	}
+}
+
 func checkoutOrCreate(target string) {
 	// If it's a valid Gerrit number CL or CL/PS or GitHub pull request number PR,
 	// checkout the CL or PR.
 	cl, ps, isCL := parseCL(target)
+	if !isCL && changeDetach {
+		dief("cannot change -detach: %s is not a CL number", target)
+	}
 	if isCL {
 		what := "CL"
 		if !haveGerrit() && haveGitHub() {
@@ -179,6 +513,10 @@ func checkoutOrCreate(target string) {
 	if b.HasPendingCommit() {
 		fmt.Fprintf(stderr(), "warning: pending changes on %s are not copied to new branch %s\n", b.Name, target)
 		branchpoint = b.Branchpoint()
+		if branchpoint == "" {
+			// Nothing is shared with the origin branch; start from it instead.
+			branchpoint = b.OriginBranch()
+		}
 	}
 
 	origin := b.OriginBranch()
@@ -199,18 +537,303 @@ func checkoutOrCreate(target string) {
 	printf("created branch %v tracking %s.", target, origin)
 }
 
+// createFromDetached creates a new work branch named target
+// at the current detached HEAD, so that any commits made
+// in detached HEAD mode become pending commits on the new branch.
+func createFromDetached(target string) {
+	b := CurrentBranch()
+	if !b.DetachedHead() {
+		dief("cannot change -new: not in detached HEAD mode\n"+
+			"\trun 'git codereview change %s' to create a new branch", target)
+	}
+	checkNewBranch("-new", target)
+
+	origin := pickUpstream("HEAD")
+	run("git", "checkout", "-q", "-b", target)
+	run("git", "branch", "-q", "--set-upstream-to", origin)
+	printf("created branch %v tracking %s.", target, origin)
+}
+
+// createOrphan creates and checks out a new branch named target
+// with no history, for work unrelated to the rest of the repository,
+// such as documentation. It removes the tracked files, leaving
+// untracked and ignored files alone, and starts the branch with an empty
+// root commit, so that later change commands amend it as the pending change.
+// The branch tracks the usual origin branch.
+func createOrphan(target string) {
+	checkStaged("change -orphan")
+	checkUnstaged("change -orphan")
+	checkNewBranch("-orphan", target)
+
+	origin := defaultOriginBranch()
+	if cfg := config()["branch"]; cfg != "" {
+		origin = "origin/" + cfg
+	}
+	msg := commitMsg
+	if msg == "" {
+		msg = target + ": start branch"
+	}
+	run("git", "checkout", "-q", "--orphan", target)
+	run("git", "rm", "-r", "-q", "-f", "--ignore-unmatch", "--", ":/")
+	// Skip the hooks: there is nothing to format yet, and the commit-msg hook
+	// adds a Change-Id when the first real change amends this commit.
+	run("git", "commit", "-q", "--allow-empty", "--no-verify", "-m", msg)
+	run("git", "branch", "-q", "--set-upstream-to", origin)
+	printf("created branch %v with no history, tracking %s.", target, origin)
+}
+
+// pickUpstream returns the origin branch that a new work branch
+// starting at rev should track: the configured branch if any,
+// otherwise the origin branch sharing the most history with rev,
+// preferring the default branch in case of a tie.
+func pickUpstream(rev string) string {
+	if cfg := config()["branch"]; cfg != "" {
+		return "origin/" + cfg
+	}
+	origin := defaultOriginBranch()
+	best := len(nonBlankLines(cmdOutput("git", "log", "--format=format:x", origin+".."+rev, "--")))
+	for _, name := range OriginBranches() {
+		if name == origin || name == "origin/HEAD" {
+			continue
+		}
+		if n := len(nonBlankLines(cmdOutput("git", "log", "--format=format:x", name+".."+rev, "--"))); n < best {
+			origin, best = name, n
+		}
+	}
+	return origin
+}
+
+// recoverBranch recreates the deleted work branch named target
+// at the commit it last pointed to when HEAD moved off of it,
+// as recorded in the HEAD reflog, and sets its upstream.
+// It does not switch to the recovered branch.
+func recoverBranch(target string) {
+	checkNewBranch("-recover", target)
+	tip := reflogBranchTip(target)
+	if tip == "" {
+		dief("cannot change -recover: no record of branch %s in the HEAD reflog", target)
+	}
+	origin := pickUpstream(tip)
+	run("git", "branch", "-q", target, tip)
+	run("git", "branch", "-q", "--set-upstream-to", origin, target)
+	printf("recovered branch %s at %s tracking %s.\n"+
+		"\trun 'git codereview change %s' to switch to it", target, tip[:7], origin, target)
+}
+
+// reflogBranchTip returns the hash of the commit that the branch
+// named name pointed to the last time HEAD moved off of it,
+// according to the HEAD reflog, or "" if the reflog has no record.
+func reflogBranchTip(name string) string {
+	out, err := cmdOutputErr("git", "log", "-g", "--format=format:%H %gs", "HEAD", "--")
+	if err != nil {
+		return ""
+	}
+	// The reflog is newest first, so the entry after a checkout
+	// records where HEAD was just before it.
+	entries := nonBlankLines(out)
+	for i, line := range entries {
+		_, subject, _ := strings.Cut(line, " ")
+		if strings.HasPrefix(subject, "checkout: moving from "+name+" to ") && i+1 < len(entries) {
+			hash, _, _ := strings.Cut(entries[i+1], " ")
+			return hash
+		}
+	}
+	return ""
+}
+
+// checkNewBranch checks that target is a valid name for a new work branch
+// that does not yet exist locally or on origin. The flag is the change
+// command flag being processed, for use in error messages.
+func checkNewBranch(flag, target string) {
+	if _, _, isCL := parseCL(target); isCL || strings.ToUpper(target) == "HEAD" || strings.Contains(target, ".") {
+		dief("invalid branch name %q", target)
+	}
+	for _, b := range LocalBranches() {
+		if b.Name == target {
+			dief("cannot change %s: branch %s already exists", flag, target)
+		}
+	}
+	for _, name := range OriginBranches() {
+		if name == "origin/"+target {
+			dief("cannot change %s: branch %s already exists on origin", flag, target)
+		}
+	}
+}
+
+// createTracking creates a new work branch named target starting at
+// and tracking the origin branch named by upstream.
+// If upstream is a development branch (dev.*) and the new branch has
+// no codereview.cfg saying so, createTracking writes one with the
+// "branch" and "parent-branch" keys that sync-branch needs, using
+// the previous branch's upstream as the parent.
+func createTracking(upstream, target string) {
+	upstream = strings.TrimPrefix(upstream, "origin/")
+	found := false
+	for _, name := range OriginBranches() {
+		if name == "origin/"+upstream {
+			found = true
+		}
+	}
+	if !found {
+		dief("cannot change -track: branch %s does not exist on origin", upstream)
+	}
+	checkNewBranch("-track", target)
+
+	parent := strings.TrimPrefix(CurrentBranch().OriginBranch(), "origin/")
+	if parent == upstream || parent == "" {
+		parent = strings.TrimPrefix(defaultOriginBranch(), "origin/")
+	}
+
+	run("git", "checkout", "-q", "-t", "-b", target, "origin/"+upstream)
+	printf("created branch %v tracking origin/%s.", target, upstream)
+
+	if !strings.HasPrefix(upstream, "dev.") || *noRun {
+		return
+	}
+	if add := addBranchConfig(upstream, parent); add != "" {
+		printf("added development branch settings to codereview.cfg:\n\t%s\n"+
+			"commit the file to the %s branch for sync-branch to use it.",
+			strings.ReplaceAll(strings.TrimSpace(add), "\n", "\n\t"), upstream)
+	}
+}
+
+// addBranchConfig adds the "branch" and "parent-branch" keys that
+// sync-branch needs to the codereview.cfg in the working tree,
+// setting them to branch and parent, unless the file already has them.
+// It returns the lines it added, if any.
+func addBranchConfig(branch, parent string) string {
+	cfgPath := filepath.Join(repoRoot(), "codereview.cfg")
+	data, err := os.ReadFile(cfgPath)
+	if err != nil && !os.IsNotExist(err) {
+		dief("%v", err)
+	}
+	cfg, err := parseConfig(string(data))
+	if err != nil {
+		dief("%s:%v", cfgPath, err)
+	}
+	var add string
+	if cfg["branch"] == "" {
+		add += "branch: " + branch + "\n"
+	}
+	if cfg["parent-branch"] == "" {
+		add += "parent-branch: " + parent + "\n"
+	}
+	if add == "" {
+		return ""
+	}
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		add = "\n" + add
+	}
+	if err := os.WriteFile(cfgPath, append(data, add...), 0666); err != nil {
+		dief("%v", err)
+	}
+	return add
+}
+
+// createFromManifest creates a local work branch tracking each origin
+// branch listed in the manifest file, as change -track does.
+// Each non-blank line of the manifest names the new work branch,
+// the origin branch it tracks, and that branch's parent branch,
+// separated by spaces, as in
+//
+//	feature dev.feature main
+//	part dev.feature.part dev.feature
+//
+// and # starts a comment. The work branch names are subject to the
+// same rules as for change -track: in particular, they cannot contain
+// dots or name an origin branch. For each listed origin branch whose
+// codereview.cfg lacks the "branch" and "parent-branch" keys that
+// sync-branch needs, createFromManifest commits a codereview.cfg with
+// those keys added, leaving it as a pending change on the new work branch,
+// ready to be mailed. It checks the whole manifest before creating any
+// branches, and it returns to the current branch when done.
+func createFromManifest(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		dief("%v", err)
+	}
+	origin := stringMap(OriginBranches())
+	listed := make(map[string]bool)
+	type entry struct{ branch, upstream, parent string }
+	var entries []entry
+	for i, line := range lines(string(data)) {
+		if j := strings.Index(line, "#"); j >= 0 {
+			line = line[:j]
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 3 {
+			dief("%s:%d: expected work branch, origin branch, and parent branch", file, i+1)
+		}
+		for _, name := range f[1:] {
+			if !origin["origin/"+name] {
+				dief("%s:%d: branch %s does not exist on origin", file, i+1, name)
+			}
+		}
+		if listed[f[0]] {
+			dief("%s:%d: branch %s is listed twice", file, i+1, f[0])
+		}
+		listed[f[0]] = true
+		checkNewBranch("-from-manifest", f[0])
+		entries = append(entries, entry{f[0], f[1], f[2]})
+	}
+	if len(entries) == 0 {
+		dief("%s: no branches listed", file)
+	}
+
+	checkStaged("change")
+	checkUnstaged("change")
+	back := CurrentBranch().Name
+	if CurrentBranch().DetachedHead() {
+		back = gitHash("HEAD")
+	}
+	for _, e := range entries {
+		run("git", "checkout", "-q", "-t", "-b", e.branch, "origin/"+e.upstream)
+		if *noRun || addBranchConfig(e.upstream, e.parent) == "" {
+			printf("created branch %s tracking origin/%s.", e.branch, e.upstream)
+			continue
+		}
+		checkNotUpstreamBranch(CurrentBranch())
+		run("git", "add", filepath.Join(repoRoot(), "codereview.cfg"))
+		run("git", "commit", "-q", "-m",
+			prefixFor(e.upstream)+"codereview.cfg: configure "+e.upstream+" for sync-branch")
+		printf("created branch %s tracking origin/%s, with a pending commit setting its parent branch to %s.",
+			e.branch, e.upstream, e.parent)
+	}
+	run("git", "checkout", "-q", back)
+}
+
 // Checkout the patch set of the given CL. When patch set is empty, use the latest.
 func checkoutCL(what, cl, ps string) {
-	if what == "CL" && ps == "" {
+	// Unless -detach was given, check out the CL on a new branch.
+	branch := ""
+	if !changeDetach {
+		branch = strings.ToLower(what) + cl
+		if _, err := cmdOutputErr("git", "rev-parse", "-q", "--verify", "refs/heads/"+branch); err == nil {
+			dief("cannot change to %s %s: branch %s already exists\n"+
+				"\trun 'git codereview change %s' to switch to it, or\n"+
+				"\trun 'git codereview change -detach %s' to check out the %s without a branch", what, cl, branch, branch, cl, what)
+		}
+	}
+
+	upstream := ""
+	if what == "CL" && (ps == "" || branch != "") {
 		change, err := readGerritChange(cl + "?o=CURRENT_REVISION")
 		if err != nil {
 			dief("cannot change to CL %s: %v", cl, err)
 		}
-		rev, ok := change.Revisions[change.CurrentRevision]
-		if !ok {
-			dief("cannot change to CL %s: invalid current revision from gerrit", cl)
+		if ps == "" {
+			rev, ok := change.Revisions[change.CurrentRevision]
+			if !ok {
+				dief("cannot change to CL %s: invalid current revision from gerrit", cl)
+			}
+			ps = strconv.Itoa(rev.Number)
+		}
+		if change.Branch != "" {
+			upstream = "origin/" + change.Branch
 		}
-		ps = strconv.Itoa(rev.Number)
 	}
 
 	var ref string
@@ -230,19 +853,38 @@ func checkoutCL(what, cl, ps string) {
 	if err != nil {
 		dief("cannot change to %v %s: %v", what, cl, err)
 	}
-	err = runErr("git", "checkout", "-q", "FETCH_HEAD")
+	if branch == "" {
+		err = runErr("git", "checkout", "-q", "FETCH_HEAD")
+	} else {
+		err = runErr("git", "checkout", "-q", "-b", branch, "FETCH_HEAD")
+	}
 	if err != nil {
 		dief("cannot change to %s %s: %v", what, cl, err)
 	}
+	on := ""
+	if branch != "" {
+		// Track the CL's target branch if we know it, or else the default.
+		known := false
+		for _, name := range OriginBranches() {
+			if name == upstream {
+				known = true
+			}
+		}
+		if !known {
+			upstream = defaultOriginBranch()
+		}
+		run("git", "branch", "-q", "-u", upstream, branch)
+		on = " on new branch " + branch
+	}
 	if *noRun {
 		return
 	}
 	subject, err := trimErr(cmdOutputErr("git", "log", "--format=%s", "-1"))
 	if err != nil {
-		printf("changed to %s %s.", what, cl)
+		printf("changed to %s %s%s.", what, cl, on)
 		dief("cannot read change subject from git: %v", err)
 	}
-	printf("changed to %s %s.\n\t%s", what, cl, subject)
+	printf("changed to %s %s%s.\n\t%s", what, cl, on, subject)
 }
 
 var parseCLRE = regexp.MustCompile(`^([0-9]+)(?:/([0-9]+))?$`)
@@ -287,6 +929,14 @@ Examples:
 
 `
 
+// stdinIsTerminal reports whether standard input is a terminal,
+// meaning that it is reasonable to prompt the user for input.
+// It is a variable so that tests can override it.
+var stdinIsTerminal = func() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
 func scanYes() bool {
 	var s string
 	fmt.Scan(&s)
@@ -6,6 +6,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"testing"
 )
@@ -68,6 +69,27 @@ func TestChangeHEAD(t *testing.T) {
 	testPrintedStderr(t, "invalid branch name \"HeAd\": ref name HEAD is reserved for git")
 }
 
+func TestChangePrevious(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMainDied(t, "change", "-")
+	testPrintedStderr(t, "cannot change -: no previous branch")
+
+	gt.work(t)
+	testMain(t, "change", "main")
+	testMain(t, "change", "-")
+	testRan(t, "git checkout -q work")
+	testMain(t, "change", "-")
+	testRan(t, "git checkout -q main")
+
+	gt.serverWorkUnrelated(t, "")
+	trun(t, gt.client, "git", "fetch", "-q")
+	testMain(t, "change", "-")
+	testRan(t, "git checkout -q work")
+	testPrintedStderr(t, "warning: 1 commit behind origin/main")
+}
+
 func TestMessageRE(t *testing.T) {
 	for _, c := range []struct {
 		in   string
@@ -101,6 +123,26 @@ func TestChangeAmendCommit(t *testing.T) {
 	testMain(t, "change")
 }
 
+func TestChangeAmendSubmitted(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	// Submit the pending change on the server, as Gerrit would,
+	// as a new commit with the same Change-Id.
+	doWork(t, 1, gt.server, "otherfile", "23456789", "")
+	trun(t, gt.client, "git", "fetch", "-q")
+
+	write(t, gt.client+"/file", "more changes", 0644)
+	trun(t, gt.client, "git", "add", "file")
+	testMainDied(t, "change", "-q")
+	testPrintedStderr(t, "cannot amend change:", "msg has already been submitted",
+		"git codereview sync", "git codereview change -f")
+
+	testMain(t, "change", "-q", "-f")
+	testPrintedStderr(t, "change updated.")
+}
+
 func TestChangeFailAmendWithMultiplePending(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
@@ -149,7 +191,7 @@ func TestChangeCL(t *testing.T) {
 
 	checkChangeCL := func(arg, ref, hash string) {
 		testMain(t, "change", "main")
-		testMain(t, "change", arg)
+		testMain(t, "change", "-detach", arg)
 		testRan(t,
 			fmt.Sprintf("git fetch -q origin %s", ref),
 			"git checkout -q FETCH_HEAD")
@@ -162,6 +204,32 @@ func TestChangeCL(t *testing.T) {
 	checkChangeCL("100/2", "refs/changes/00/100/2", hash2)
 	checkChangeCL("100", "refs/changes/00/100/3", hash1)
 
+	// Without -detach, the CL is checked out on a new branch
+	// tracking the CL's target branch.
+	testMain(t, "change", "main")
+	testMain(t, "change", "100/2")
+	testPrintedStderr(t, "changed to CL 100/2 on new branch cl100.")
+	testRan(t,
+		"git fetch -q origin refs/changes/00/100/2",
+		"git checkout -q -b cl100 FETCH_HEAD",
+		"git branch -q -u origin/main cl100")
+	if hash2 != trim(trun(t, gt.client, "git", "rev-parse", "cl100")) {
+		t.Fatalf("cl100 is not at CL 100/2")
+	}
+	testMain(t, "change", "main")
+	testMainDied(t, "change", "100")
+	testPrintedStderr(t, "branch cl100 already exists", "git codereview change -detach 100")
+	testMainDied(t, "change", "-detach", "work")
+	testPrintedStderr(t, "work is not a CL number")
+
+	srv.setReply("/a/changes/200", gerritReply{body: ")]}'\n" + `{"branch": "dev.branch"}`})
+	trun(t, gt.server, "git", "update-ref", "refs/changes/00/200/1", hash1)
+	testMain(t, "change", "main")
+	testMain(t, "change", "200/1")
+	if up := trim(trun(t, gt.client, "git", "rev-parse", "--abbrev-ref", "cl200@{u}")); up != "origin/dev.branch" {
+		t.Fatalf("cl200 tracks %s, want origin/dev.branch", up)
+	}
+
 	// turn off gerrit, make it look like we are on GitHub
 	write(t, gt.server+"/codereview.cfg", "nothing: here", 0644)
 	trun(t, gt.server, "git", "add", "codereview.cfg")
@@ -170,12 +238,18 @@ func TestChangeCL(t *testing.T) {
 	trun(t, gt.client, "git", "pull", "-r")
 	trun(t, gt.client, "git", "remote", "set-url", "origin", "https://github.com/google/not-a-project")
 
-	testMain(t, "change", "-n", "123")
+	testMain(t, "change", "-n", "-detach", "123")
 	testNoStdout(t)
 	testPrintedStderr(t,
 		"git fetch -q origin pull/123/head",
 		"git checkout -q FETCH_HEAD",
 	)
+	testMain(t, "change", "-n", "123")
+	testNoStdout(t)
+	testPrintedStderr(t,
+		"git fetch -q origin pull/123/head",
+		"git checkout -q -b pr123 FETCH_HEAD",
+	)
 }
 
 func TestChangeWithMessage(t *testing.T) {
@@ -197,3 +271,525 @@ func TestChangeWithSignoff(t *testing.T) {
 	testMain(t, "change", "-s", "-m", "foo: bar")
 	testRan(t, "git commit -q --allow-empty -m foo: bar -s")
 }
+
+func TestChangeAlwaysSignoff(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	write(t, gt.client+"/codereview.cfg", "always-signoff: true\n", 0644)
+	testMain(t, "change", "new_branch")
+	testMain(t, "change", "-m", "foo: bar")
+	testRan(t, "git commit -q --allow-empty -m foo: bar -s")
+
+	testMain(t, "change", "-m", "foo: baz", "-no-signoff")
+	testRan(t, "git commit -q --allow-empty --amend -m foo: baz")
+
+	testMainDied(t, "change", "-s", "-no-signoff")
+	testPrintedStderr(t, "Usage")
+}
+
+func TestChangeOnUpstreamBranch(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	write(t, gt.client+"/file", "new content", 0644)
+	trun(t, gt.client, "git", "add", "file")
+	testMainDied(t, "change")
+	testPrintedStderr(t, "main is the name of the upstream branch",
+		"git codereview change <name>")
+	testNoStdout(t)
+
+	testCommitMsg = "foo: new content"
+	defer func() { testCommitMsg = "" }()
+	testMain(t, "change", "work")
+	testRan(t, "git checkout -q -b work HEAD",
+		"git branch -q --set-upstream-to origin/main",
+		"git commit -q --allow-empty -m foo: new content")
+}
+
+func TestChangeNonInteractiveMessage(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	defer func(f func() bool) { stdinIsTerminal = f }(stdinIsTerminal)
+	stdinIsTerminal = func() bool { return false }
+
+	testMain(t, "change", "new_branch")
+	testMain(t, "change", "-m", "not the standard form")
+	testRan(t, "git commit -q --allow-empty -m not the standard form")
+	testPrintedStderr(t, "warning: keeping nonstandard commit message", "change updated")
+
+	testMainDied(t, "change", "-strict-message", "-m", "still not the standard form")
+	testPrintedStderr(t, "commit message does not use the standard form", "!change updated")
+}
+
+func TestChangeNewFromDetached(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMainDied(t, "change", "-new", "work")
+	testPrintedStderr(t, "not in detached HEAD mode")
+
+	trun(t, gt.client, "git", "checkout", "-q", "origin/dev.branch")
+	write(t, gt.client+"/file", "detached work", 0644)
+	trun(t, gt.client, "git", "commit", "-q", "-a", "-m", "detached work")
+	head := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+
+	testMainDied(t, "change", "-new", "main")
+	testPrintedStderr(t, "branch main already exists")
+	testMainDied(t, "change", "-new", "release.branch")
+	testPrintedStderr(t, "invalid branch name")
+
+	testMain(t, "change", "-new", "work")
+	testRan(t, "git checkout -q -b work",
+		"git branch -q --set-upstream-to origin/dev.branch")
+	testPrintedStderr(t, "created branch work tracking origin/dev.branch")
+
+	b := CurrentBranch()
+	if b.Name != "work" {
+		t.Fatalf("current branch = %q, want work", b.Name)
+	}
+	if p := b.Pending(); len(p) != 1 || p[0].Hash != head {
+		t.Fatalf("pending commits not moved to new branch")
+	}
+}
+
+func TestChangeRecover(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+	gt.work(t)
+	tip := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+
+	testMain(t, "change", "main")
+	trun(t, gt.client, "git", "branch", "-D", "work")
+
+	testMainDied(t, "change", "-recover", "other")
+	testPrintedStderr(t, "no record of branch other in the HEAD reflog")
+	testMainDied(t, "change", "-recover", "main")
+	testPrintedStderr(t, "branch main already exists")
+
+	testMain(t, "change", "-recover", "work")
+	testRan(t, "git branch -q work "+tip,
+		"git branch -q --set-upstream-to origin/main work")
+	testPrintedStderr(t, "recovered branch work at "+tip[:7]+" tracking origin/main.")
+	if b := CurrentBranch(); b.Name != "main" {
+		t.Fatalf("current branch = %q, want main", b.Name)
+	}
+	if up := trim(trun(t, gt.client, "git", "rev-parse", "--abbrev-ref", "work@{u}")); up != "origin/main" {
+		t.Fatalf("work tracks %s, want origin/main", up)
+	}
+}
+
+func TestChangeOrphan(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+	write(t, gt.client+"/notes", "untracked", 0644)
+
+	testMainDied(t, "change", "-orphan", "work")
+	testPrintedStderr(t, "cannot change -orphan: branch work already exists")
+
+	write(t, gt.client+"/file", "unstaged edit", 0644)
+	testMainDied(t, "change", "-orphan", "docs")
+	testPrintedStderr(t, "cannot change -orphan: unstaged changes")
+	trun(t, gt.client, "git", "checkout", "--", "file")
+
+	testMain(t, "change", "-orphan", "docs")
+	testPrintedStderr(t, "created branch docs with no history, tracking origin/main.")
+	if b := CurrentBranch(); b.Name != "docs" {
+		t.Fatalf("current branch = %q, want docs", b.Name)
+	}
+	if files := trun(t, gt.client, "git", "ls-files"); files != "" {
+		t.Fatalf("files left in index: %q", files)
+	}
+	if _, err := os.Stat(gt.client + "/file"); err == nil {
+		t.Fatalf("tracked file left in working tree")
+	}
+	if data := read(t, gt.client+"/notes"); string(data) != "untracked" {
+		t.Fatalf("untracked file changed: %q", data)
+	}
+	if up := trim(trun(t, gt.client, "git", "rev-parse", "--abbrev-ref", "docs@{u}")); up != "origin/main" {
+		t.Fatalf("docs tracks %s, want origin/main", up)
+	}
+
+	testMainDied(t, "branchpoint")
+	testPrintedStderr(t, "cannot branchpoint: docs shares no history with origin/main")
+
+	// The first change amends the empty root commit.
+	write(t, gt.client+"/index.md", "docs", 0644)
+	trun(t, gt.client, "git", "add", "index.md")
+	testMain(t, "change", "-m", "docs: add index")
+	if n := trun(t, gt.client, "git", "rev-list", "--count", "HEAD"); trim(n) != "1" {
+		t.Fatalf("docs has %s commits, want 1", trim(n))
+	}
+	b := CurrentBranch()
+	if len(b.Pending()) != 1 || b.Branchpoint() != "" {
+		t.Fatalf("pending %d commits with branchpoint %q, want 1 with none", len(b.Pending()), b.Branchpoint())
+	}
+	if files := ListFiles(b.Pending()[0]); len(files) != 1 || files[0] != "index.md" {
+		t.Fatalf("ListFiles = %q, want [index.md]", files)
+	}
+
+	testMain(t, "pending", "-c", "-l", "-s")
+	testPrintedStdout(t, "docs "+b.Pending()[0].ShortHash+" (current branch)", "docs: add index")
+
+	// Syncing would rebase the branch onto unrelated history.
+	testMainDied(t, "sync")
+	testPrintedStderr(t, "cannot sync: docs shares no history with origin/main")
+}
+
+func TestChangeEditMessage(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	gt.work(t)
+	trun(t, gt.client, "git", "tag", "MSG3")
+	gt.work(t)
+
+	write(t, gt.client+"/file", "pending work", 0644)
+
+	os.Setenv("GIT_EDITOR", "sed -i.bak -e s/msg/MESSAGE/")
+	defer os.Unsetenv("GIT_EDITOR")
+
+	testMainDied(t, "change", "-edit-message")
+	testPrintedStderr(t, "-edit-message commit")
+
+	testMainDied(t, "change", "-edit-message", "origin/main")
+	testPrintedStderr(t, "cannot reword", "not found in the current branch")
+
+	testMain(t, "change", "-edit-message", "MSG3")
+	testPrintedStderr(t, "editing messages")
+
+	testMain(t, "pending", "-c", "-l", "-s")
+	testPrintedStdout(t, "msg\n", "MESSAGE #2", "msg #3")
+
+	if data, err := os.ReadFile(gt.client + "/file"); err != nil || string(data) != "pending work" {
+		t.Fatalf("working tree was modified: %q, %v", data, err)
+	}
+}
+
+func TestChangeEditor(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testCommitMsg = ""
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "work")
+	write(t, gt.client+"/file", "new content", 0644)
+	trun(t, gt.client, "git", "add", "file")
+
+	// The test setup configures core.editor=false.
+	os.Unsetenv("GIT_EDITOR")
+	defer os.Unsetenv("GIT_EDITOR")
+	testMainDied(t, "change")
+	testPrintedStderr(t, `cannot change: editor "false" always fails`)
+	testRan(t)
+
+	// Git runs the editor using the shell, so a quoted path
+	// containing a space is fine.
+	dir := t.TempDir() + "/my editor"
+	mkdir(t, dir)
+	write(t, dir+"/ed.sh", "#!/bin/sh\nsed -i.bak -e 1s/^/edited/ \"$1\"\n", 0755)
+	os.Setenv("GIT_EDITOR", "'"+dir+"/ed.sh'")
+	testMain(t, "change")
+	testPrintedStderr(t, "!editor")
+	if msg := trun(t, gt.client, "git", "log", "-n", "1", "--format=%s"); !strings.HasPrefix(msg, "edited") {
+		t.Fatalf("commit message %q, want edited", msg)
+	}
+}
+
+func TestChangeTrack(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMainDied(t, "change", "-track", "dev.nosuch", "mydev")
+	testPrintedStderr(t, "cannot change -track: branch dev.nosuch does not exist on origin")
+
+	testMainDied(t, "change", "-track", "dev.branch", "main")
+	testPrintedStderr(t, "cannot change -track: branch main already exists")
+
+	// dev.branch already has a complete codereview.cfg.
+	testMain(t, "change", "-track", "dev.branch", "mydev")
+	testPrintedStderr(t, "created branch mydev tracking origin/dev.branch", "!codereview.cfg")
+	checkCurrentBranch(t, "mydev", "origin/dev.branch", false, "", "")
+	testMain(t, "pending", "-c", "-l")
+	testPrintedStdout(t, "!uncommitted changes")
+
+	// dev.feature2 has none, so one is added, with the previous branch's upstream as parent.
+	trun(t, gt.server, "git", "checkout", "-b", "dev.feature2", "main")
+	trun(t, gt.server, "git", "checkout", "main")
+	trun(t, gt.client, "git", "fetch", "-q")
+	testMain(t, "change", "-track", "origin/dev.feature2", "feature2")
+	testPrintedStderr(t, "created branch feature2 tracking origin/dev.feature2",
+		"added development branch settings to codereview.cfg")
+	checkCurrentBranch(t, "feature2", "origin/dev.feature2", false, "", "")
+	if data := read(t, gt.client+"/codereview.cfg"); string(data) != "branch: dev.feature2\nparent-branch: dev.branch\n" {
+		t.Fatalf("codereview.cfg:\n%s", data)
+	}
+}
+
+func TestChangeFromManifest(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	trun(t, gt.server, "git", "branch", "dev.feature", "main")
+	trun(t, gt.server, "git", "branch", "dev.feature.part", "main")
+	trun(t, gt.client, "git", "fetch", "-q")
+	manifest := gt.tmpdir + "/manifest"
+
+	write(t, manifest, "feature dev.feature main\nnosuch dev.nosuch main\n", 0644)
+	testMainDied(t, "change", "-from-manifest", manifest)
+	testPrintedStderr(t, "manifest:2: branch dev.nosuch does not exist on origin")
+
+	write(t, manifest, "feature dev.feature main\nfeature dev.feature.part dev.feature\n", 0644)
+	testMainDied(t, "change", "-from-manifest", manifest)
+	testPrintedStderr(t, "manifest:2: branch feature is listed twice")
+
+	write(t, manifest, "main dev.feature main\n", 0644)
+	testMainDied(t, "change", "-from-manifest", manifest)
+	testPrintedStderr(t, "branch main already exists")
+
+	// Work branches cannot use the reserved dotted names
+	// or the names of the upstream branches.
+	write(t, manifest, "dev.feature dev.feature main\n", 0644)
+	testMainDied(t, "change", "-from-manifest", manifest)
+	testPrintedStderr(t, `invalid branch name "dev.feature"`)
+	trun(t, gt.server, "git", "branch", "feature", "main")
+	trun(t, gt.client, "git", "fetch", "-q")
+	write(t, manifest, "feature dev.feature main\n", 0644)
+	testMainDied(t, "change", "-from-manifest", manifest)
+	testPrintedStderr(t, "branch feature already exists on origin")
+	trun(t, gt.server, "git", "branch", "-D", "feature")
+	trun(t, gt.client, "git", "fetch", "-q", "--prune")
+
+	write(t, manifest, "feature dev.feature\n", 0644)
+	testMainDied(t, "change", "-from-manifest", manifest)
+	testPrintedStderr(t, "manifest:1: expected work branch, origin branch, and parent branch")
+
+	write(t, manifest, "# feature branches\nfeature dev.feature main\n\npart dev.feature.part dev.feature # a part\n", 0644)
+	testMain(t, "change", "-from-manifest", manifest)
+	testPrintedStderr(t,
+		"created branch feature tracking origin/dev.feature, with a pending commit setting its parent branch to main.",
+		"created branch part tracking origin/dev.feature.part, with a pending commit setting its parent branch to dev.feature.")
+	checkCurrentBranch(t, "main", "origin/main", false, "", "")
+
+	for _, e := range []struct{ branch, upstream, parent string }{
+		{"feature", "dev.feature", "main"},
+		{"part", "dev.feature.part", "dev.feature"},
+	} {
+		want := "branch: " + e.upstream + "\nparent-branch: " + e.parent + "\n"
+		if cfg := trun(t, gt.client, "git", "show", e.branch+":codereview.cfg"); cfg != want {
+			t.Errorf("%s:codereview.cfg = %q, want %q", e.branch, cfg, want)
+		}
+		if msg := trim(trun(t, gt.client, "git", "log", "-n", "1", "--format=%s", e.branch)); msg != "["+e.upstream+"] codereview.cfg: configure "+e.upstream+" for sync-branch" {
+			t.Errorf("%s commit subject = %q", e.branch, msg)
+		}
+		if up := trim(trun(t, gt.client, "git", "rev-parse", "--abbrev-ref", e.branch+"@{u}")); up != "origin/"+e.upstream {
+			t.Errorf("%s tracks %s, want origin/%s", e.branch, up, e.upstream)
+		}
+	}
+}
+
+func TestChangePathspecs(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	testCommitMsg = "" // left behind by other tests
+
+	testMain(t, "change", "work")
+	mkdir(t, gt.client+"/dir")
+	write(t, gt.client+"/dir/a", "a", 0644)
+	write(t, gt.client+"/b", "b", 0644)
+	trun(t, gt.client, "git", "add", "dir/a", "b")
+	trun(t, gt.client, "git", "commit", "-q", "-m", "dir: add files")
+	write(t, gt.client+"/dir/a", "a2", 0644)
+	write(t, gt.client+"/b", "b2", 0644)
+
+	testMainDied(t, "change", "work", "--", "dir")
+	testPrintedStderr(t, "Usage")
+
+	testMain(t, "change", "-q", "-a", "--", "dir")
+	testRan(t, "git commit -q --allow-empty --amend --no-edit -- dir")
+	testMain(t, "pending", "-c", "-l")
+	testPrintedStdout(t, "Files unstaged:\n\t\tb\n", "Files in this change:\n\t\tb\n\t\tdir/a\n")
+	if out := trun(t, gt.client, "git", "show", "HEAD:dir/a"); out != "a2" {
+		t.Fatalf("dir/a in commit = %q, want %q", out, "a2")
+	}
+
+	// The -a is implied by the paths.
+	write(t, gt.client+"/dir/a", "a3", 0644)
+	testMain(t, "change", "-q", "--", "dir/a")
+	testRan(t, "git commit -q --allow-empty --amend --no-edit -- dir/a")
+}
+
+func TestChangeModuleGuard(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMain(t, "change", "work")
+	mkdir(t, gt.client+"/sub")
+	write(t, gt.client+"/go.mod", "module example.com/m\n", 0644)
+	write(t, gt.client+"/sub/go.mod", "module example.com/m/sub\n", 0644)
+	write(t, gt.client+"/a.go", "package a\n", 0644)
+	write(t, gt.client+"/sub/b.go", "package b\n", 0644)
+	trun(t, gt.client, "git", "add", "a.go", "sub/b.go")
+
+	// Off by default.
+	testMain(t, "change", "-m", "foo: add files")
+	testPrintedStderr(t, "!modules")
+
+	write(t, gt.client+"/codereview.cfg", "module-guard: true\n", 0644)
+	write(t, gt.client+"/a.go", "package a // edited\n", 0644)
+	trun(t, gt.client, "git", "add", "a.go")
+	testMain(t, "change", "-q")
+	testPrintedStderr(t, "!modules")
+
+	write(t, gt.client+"/a.go", "package a // edited again\n", 0644)
+	write(t, gt.client+"/sub/b.go", "package b // edited\n", 0644)
+	trun(t, gt.client, "git", "add", "a.go", "sub/b.go")
+	testMain(t, "change", "-q")
+	testPrintedStderr(t, "warning: committing files from 2 modules: ., sub")
+}
+
+func TestChangeGeneratedFiles(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMain(t, "change", "work")
+	mkdir(t, gt.client+"/pb")
+	write(t, gt.client+"/pb/x.proto", "message X {}\n", 0644)
+	write(t, gt.client+"/pb/x.pb.go", "package pb\n", 0644)
+	trun(t, gt.client, "git", "add", "pb")
+
+	// Off by default.
+	testMain(t, "change", "-m", "pb: add x")
+	testPrintedStderr(t, "!generated")
+
+	write(t, gt.client+"/codereview.cfg", "generated-files: *.pb.go\ngenerated-sources: *.proto\n", 0644)
+	write(t, gt.client+"/pb/x.proto", "message X { int32 y = 1; }\n", 0644)
+	write(t, gt.client+"/pb/x.pb.go", "package pb // regenerated\n", 0644)
+	trun(t, gt.client, "git", "add", "pb")
+	testMain(t, "change", "-q")
+	testPrintedStderr(t, "!generated")
+
+	write(t, gt.client+"/pb/x.proto", "message X { int32 z = 1; }\n", 0644)
+	trun(t, gt.client, "git", "add", "pb")
+	testMain(t, "change", "-q")
+	testPrintedStderr(t, "warning: committing changes to generator sources without regenerated files: pb/x.proto", "forget to regenerate")
+
+	write(t, gt.client+"/pb/x.pb.go", "package pb // edited by hand\n", 0644)
+	testMain(t, "change", "-a", "-q")
+	testPrintedStderr(t, "warning: committing generated files without changes to their sources: pb/x.pb.go")
+}
+
+func TestChangeGPGSign(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	testCommitMsg = "" // left behind by other tests
+
+	testMain(t, "change", "work")
+
+	// Signing with a broken GPG program fails; say why.
+	trun(t, gt.client, "git", "config", "commit.gpgsign", "true")
+	trun(t, gt.client, "git", "config", "gpg.program", "false")
+	testMainDied(t, "change", "-m", "foo: signed")
+	testPrintedStderr(t, "commit.gpgsign is set", "change -no-gpg-sign")
+
+	testMain(t, "change", "-no-gpg-sign", "-m", "foo: unsigned")
+	testRan(t, "git commit -q --allow-empty -m foo: unsigned --no-gpg-sign")
+
+	// Without commit.gpgsign, a failed commit gets no signing hint.
+	trun(t, gt.client, "git", "config", "commit.gpgsign", "false")
+	write(t, gt.client+"/.git/hooks/pre-commit", "#!/bin/sh\nexit 1\n", 0755)
+	testMainDied(t, "change", "-q")
+	testPrintedStderr(t, "!commit.gpgsign")
+}
+
+func TestChangeList(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMain(t, "change", "-list")
+	testNoStdout(t)
+
+	gt.work(t)
+	testMain(t, "change", "work2")
+	write(t, gt.client+"/file2", "x", 0644)
+	trun(t, gt.client, "git", "add", "file2")
+	trun(t, gt.client, "git", "commit", "-q", "-m", "foo: work2")
+	testMain(t, "change", "idle")
+	testMain(t, "change", "dev.branch")
+
+	testMain(t, "change", "-list")
+	testPrintedStdout(t, "work\nwork2\n", "!idle", "!main", "!dev.branch")
+
+	testMainDied(t, "change", "-list", "work")
+	testPrintedStderr(t, "Usage")
+}
+
+func TestChangeFixUpstream(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	trun(t, gt.server, "git", "branch", "dev.feature")
+	trun(t, gt.client, "git", "fetch", "-q")
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "loose")
+
+	testMain(t, "change", "-fix-upstream")
+	testPrintedStderr(t, "loose now tracks origin/main (was not tracking a branch)")
+	testMain(t, "change", "-fix-upstream")
+	testPrintedStderr(t, "loose already tracks origin/main")
+
+	write(t, gt.client+"/codereview.cfg", "branch: dev.feature\n", 0644)
+	testMain(t, "change", "-fix-upstream")
+	testPrintedStderr(t, "loose now tracks origin/dev.feature (was origin/main)")
+	if out := trun(t, gt.client, "git", "rev-parse", "--abbrev-ref", "loose@{u}"); strings.TrimSpace(out) != "origin/dev.feature" {
+		t.Fatalf("upstream = %q, want origin/dev.feature", out)
+	}
+
+	write(t, gt.client+"/codereview.cfg", "branch: dev.missing\n", 0644)
+	testMainDied(t, "change", "-fix-upstream")
+	testPrintedStderr(t, "origin/dev.missing does not exist")
+
+	testMainDied(t, "change", "-fix-upstream", "-list")
+	testPrintedStderr(t, "Usage")
+}
+
+func TestChangeTouch(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	testCommitMsg = "" // left behind by other tests
+
+	testMainDied(t, "change", "-touch")
+	testPrintedStderr(t, "cannot change -touch: no pending commit")
+
+	// Make an old commit, and leave GIT_COMMITTER_DATE set to the old date,
+	// to check that -touch overrides it.
+	defer os.Unsetenv("GIT_COMMITTER_DATE")
+	os.Setenv("GIT_COMMITTER_DATE", "1000000000 +0000")
+	gt.work(t)
+	trun(t, gt.client, "git", "commit", "-q", "--amend", "--no-edit", "--date", "1000000000 +0000")
+	author := trun(t, gt.client, "git", "log", "-n", "1", "--format=%an <%ae> %at")
+
+	// The committer date keeps the local time zone.
+	defer os.Unsetenv("TZ")
+	os.Setenv("TZ", "EST5")
+
+	testMain(t, "change", "-touch")
+	testRan(t, "git commit -q --allow-empty --amend --no-edit")
+	if got := trun(t, gt.client, "git", "log", "-n", "1", "--format=%cd", "--date=raw"); !strings.HasSuffix(strings.TrimSpace(got), " -0500") {
+		t.Errorf("committer date after change -touch = %q, want -0500 zone", got)
+	}
+	if v := os.Getenv("GIT_COMMITTER_DATE"); v != "1000000000 +0000" {
+		t.Errorf("change -touch changed GIT_COMMITTER_DATE to %q", v)
+	}
+	if got := trun(t, gt.client, "git", "log", "-n", "1", "--format=%an <%ae> %at"); got != author {
+		t.Errorf("author after change -touch = %q, want %q", got, author)
+	}
+	if got := trun(t, gt.client, "git", "log", "-n", "1", "--format=%ct"); strings.TrimSpace(got) == "1000000000" {
+		t.Errorf("committer date not updated by change -touch")
+	}
+	if got := trun(t, gt.client, "git", "log", "-n", "1", "--format=%B"); !strings.HasPrefix(got, "msg\n") {
+		t.Errorf("commit message changed by change -touch:\n%s", got)
+	}
+}
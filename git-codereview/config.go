@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -28,15 +29,17 @@ func config() map[string]string {
 	}
 	configPath = filepath.Join(repoRoot(), "codereview.cfg")
 	b, err := os.ReadFile(configPath)
-	raw := string(b)
 	if err != nil {
-		verbosef("%sfailed to load config from %q: %v", raw, configPath, err)
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(stderr(), "warning: failed to load config: %v\n", err)
+		}
+		verbosef("failed to load config from %q: %v", configPath, err)
 		cachedConfig = make(map[string]string)
 		return cachedConfig
 	}
-	cachedConfig, err = parseConfig(raw)
+	cachedConfig, err = parseConfig(string(b))
 	if err != nil {
-		dief("%v", err)
+		dief("%s:%v", configPath, err)
 	}
 	return cachedConfig
 }
@@ -79,19 +82,178 @@ func haveGitHub() bool {
 	return strings.Contains(origin, "github.com")
 }
 
+// parseConfig parses the text of a codereview.cfg file.
+// Errors are prefixed with the offending line number, as in "3: bad config line".
 func parseConfig(raw string) (map[string]string, error) {
 	cfg := make(map[string]string)
-	for _, line := range nonBlankLines(raw) {
+	for i, line := range strings.Split(raw, "\n") {
 		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "#") {
-			// comment line
+		if line == "" || strings.HasPrefix(line, "#") {
+			// blank or comment line
 			continue
 		}
 		fields := strings.SplitN(line, ":", 2)
 		if len(fields) != 2 {
-			return nil, fmt.Errorf("bad config line, expected 'key: value': %q", line)
+			return nil, fmt.Errorf("%d: bad config line, expected 'key: value': %q", i+1, line)
 		}
 		cfg[strings.TrimSpace(fields[0])] = strings.TrimSpace(fields[1])
 	}
 	return cfg, nil
 }
+
+// configMatch reports whether file matches one of the glob patterns
+// listed in the codereview.cfg key.
+// The patterns are separated by commas or spaces.
+// A pattern containing a slash is matched against the file name
+// relative to the repo root; a pattern without a slash is matched
+// against the final element of the file name, so that "*.pb.go"
+// matches generated files in every directory.
+func configMatch(key, file string) bool {
+	list := config()[key]
+	if list == "" {
+		return false
+	}
+	for _, pattern := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' }) {
+		name := file
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(file)
+		}
+		match, err := path.Match(pattern, name)
+		if err != nil {
+			dief("invalid %s pattern %q in codereview.cfg: %v", key, pattern, err)
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// configKeys lists the codereview.cfg keys that git-codereview understands,
+// along with a function to check the values of those keys that have a
+// required form. It omits the "<command>-flags" keys read by configFlags.
+var configKeys = map[string]func(string) error{
+	"always-signoff":         nil,
+	"branch":                 checkConfigBranch,
+	"cc-watchers":            nil,
+	"ci-labels":              nil,
+	"commit-queue-vote":      nil,
+	"dedup-trailers":         nil,
+	"do-not-mail-markers":    nil,
+	"generated-files":        nil,
+	"generated-sources":      nil,
+	"gerrit":                 checkConfigGerrit,
+	"gofmt-exclude":          nil,
+	"gofmt-simplify":         nil,
+	"issuerepo":              checkConfigIssueRepo,
+	"mail-confirm-count":     nil,
+	"mail-markers":           nil,
+	"module-guard":           nil,
+	"parent-branch":          checkConfigBranch,
+	"reviewers-file-default": nil,
+	"verify-upload":          nil,
+	"warn-missing-tests":     nil,
+}
+
+func checkConfigGerrit(v string) error {
+	if v == "off" {
+		return nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("want off or an https URL")
+	}
+	return nil
+}
+
+func checkConfigIssueRepo(v string) error {
+	owner, repo, ok := strings.Cut(v, "/")
+	if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return fmt.Errorf("want owner/repo")
+	}
+	return nil
+}
+
+func checkConfigBranch(v string) error {
+	if _, err := cmdOutputErr("git", "check-ref-format", "--branch", v); err != nil {
+		return fmt.Errorf("not a valid branch name")
+	}
+	return nil
+}
+
+func cmdConfig(args []string) {
+	// NOTE: New flags should be added to the usage message below as well as doc.go.
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s config %s get key\n", progName, globalFlags)
+		fmt.Fprintf(stderr(), "       %s config %s set key value\n", progName, globalFlags)
+		exit(2)
+	}
+	flags.Parse(args)
+	args = flags.Args()
+	switch {
+	case len(args) == 2 && args[0] == "get":
+		v, ok := config()[args[1]]
+		if !ok {
+			exit(1)
+		}
+		fmt.Fprintf(stdout(), "%s\n", v)
+	case len(args) == 3 && args[0] == "set":
+		setConfig(args[1], args[2])
+	default:
+		flags.Usage()
+	}
+}
+
+// setConfig sets key to value in the repository's codereview.cfg,
+// creating the file if needed. It rewrites the line setting key in place,
+// keeping the other lines and comments, or appends a new line if there is none.
+func setConfig(key, value string) {
+	value = strings.TrimSpace(value)
+	if key == "" || strings.HasPrefix(key, "#") || strings.ContainsAny(key, ": \t\r\n") {
+		dief("cannot set config: invalid key %q", key)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		dief("cannot set config: value for %s must be a single line", key)
+	}
+	check, known := configKeys[key]
+	if !known && !strings.HasSuffix(key, "-flags") {
+		printf("warning: %s is not a known codereview.cfg key", key)
+	}
+	if check != nil {
+		if err := check(value); err != nil {
+			dief("cannot set config: invalid %s %q: %v", key, value, err)
+		}
+	}
+
+	config() // for configPath and for dying if the existing file is malformed
+	data, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		dief("%v", err)
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	newLine := key + ": " + value
+	found := false
+	var out []string
+	for _, line := range lines {
+		k, _, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok && !strings.HasPrefix(strings.TrimSpace(line), "#") && strings.TrimSpace(k) == key {
+			if found {
+				// Drop later duplicates, which would otherwise override the new value.
+				continue
+			}
+			found = true
+			line = newLine
+		}
+		out = append(out, line)
+	}
+	if !found {
+		out = append(out, newLine)
+	}
+	if err := os.WriteFile(configPath, []byte(strings.Join(out, "\n")+"\n"), 0666); err != nil {
+		dief("%v", err)
+	}
+	cachedConfig = nil
+}
@@ -19,6 +19,7 @@ func TestParseConfig(t *testing.T) {
 		{raw: "issuerepo: golang/go", want: map[string]string{"issuerepo": "golang/go"}},
 		{raw: "# comment", want: map[string]string{}},
 		{raw: "# comment\n  k  :   v   \n# comment 2\n\n k2:v2\n", want: map[string]string{"k": "v", "k2": "v2"}},
+		{raw: "k: v\nbad line\n", wanterr: true},
 	}
 
 	for _, tt := range cases {
@@ -33,6 +34,57 @@ func TestParseConfig(t *testing.T) {
 	}
 }
 
+func TestConfigMalformed(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// A malformed config in the work tree is fatal.
+	write(t, gt.client+"/codereview.cfg", "gerrit: on\n\nbranch = main\n", 0644)
+	testMainDied(t, "pending", "-c", "-l")
+	testPrintedStderr(t, "codereview.cfg:3: bad config line, expected 'key: value': \"branch = main\"")
+
+	// A malformed config on another branch is reported but ignored.
+	trun(t, gt.client, "git", "checkout", "-b", "other")
+	trun(t, gt.client, "git", "add", "codereview.cfg")
+	trun(t, gt.client, "git", "commit", "-m", "bad config")
+	trun(t, gt.client, "git", "checkout", "main")
+	cachedConfig = nil
+	testMain(t, "pending", "-l")
+	testPrintedStderr(t, "warning: ignoring codereview.cfg on branch other: line 3: bad config line")
+}
+
+func TestConfigFlags(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	// fake auth information to avoid Gerrit error
+	auth.initialized = true
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.initialized = false
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	write(t, gt.client+"/codereview.cfg", "mail-flags: -wip -topic team\n", 0644)
+	testMain(t, "mail", "-n")
+	testPrintedStderr(t, "git push -q origin HEAD:refs/for/main%topic=team,wip\n")
+
+	// Command-line flags take precedence, also for the m alias.
+	testMain(t, "m", "-n", "-topic", "mine")
+	testPrintedStderr(t, "git push -q origin HEAD:refs/for/main%topic=mine,wip\n")
+
+	// Other commands are unaffected unless configured.
+	testMain(t, "pending", "-c", "-l")
+	testPrintedStdout(t, "\tmsg\n")
+
+	write(t, gt.client+"/codereview.cfg", "pending-flags: -c -l -s\n", 0644)
+	testMain(t, "pending")
+	testPrintedStdout(t, "(current branch)", "+ uncommitted changes", "msg\n", "!\tmsg\n")
+}
+
 func TestHaveGerritInternal(t *testing.T) {
 	tests := []struct {
 		gerrit string
@@ -67,3 +119,41 @@ func TestHaveGerritInternal(t *testing.T) {
 		}
 	}
 }
+
+func TestConfigGetSet(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	cfg := gt.client + "/codereview.cfg"
+	write(t, cfg, "# project settings\ngerrit: off\n\nbranch: main\n# trailing comment\n", 0644)
+
+	testMain(t, "config", "get", "branch")
+	testPrintedStdout(t, "main\n")
+
+	testMainDied(t, "config", "get", "issuerepo")
+	testNoStdout(t)
+
+	testMainDied(t, "config", "set", "branch")
+	testPrintedStderr(t, "Usage")
+
+	testMainDied(t, "config", "set", "issuerepo", "golang")
+	testPrintedStderr(t, `cannot set config: invalid issuerepo "golang": want owner/repo`)
+
+	testMainDied(t, "config", "set", "gerrit", "example.com")
+	testPrintedStderr(t, "want off or an https URL")
+
+	testMain(t, "config", "set", "branch", "dev.x")
+	testMain(t, "config", "set", "issuerepo", "golang/go")
+	testMain(t, "config", "set", "my-key", "some value")
+	testPrintedStderr(t, "warning: my-key is not a known codereview.cfg key")
+	testMain(t, "config", "set", "mail-flags", "-trybot")
+	testNoStderr(t)
+
+	want := "# project settings\ngerrit: off\n\nbranch: dev.x\n# trailing comment\nissuerepo: golang/go\nmy-key: some value\nmail-flags: -trybot\n"
+	if got := read(t, cfg); string(got) != want {
+		t.Errorf("codereview.cfg:\n%s\nwant:\n%s", got, want)
+	}
+
+	testMain(t, "config", "get", "issuerepo")
+	testPrintedStdout(t, "golang/go\n")
+}
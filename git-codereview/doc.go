@@ -43,15 +43,20 @@ This document uses the first form for clarity, but most users install these
 aliases in their .gitconfig file:
 
 	[alias]
+		amend-fixup = codereview amend-fixup
 		change = codereview change
 		gofmt = codereview gofmt
+		log-cl = codereview log-cl
 		mail = codereview mail
 		pending = codereview pending
 		rebase-work = codereview rebase-work
+		reviewers = codereview reviewers
 		reword = codereview reword
 		submit = codereview submit
 		sync = codereview sync
 		sync-branch = codereview sync-branch
+		trybot = codereview trybot
+		verify = codereview verify
 
 # Single-Commit Work Branches
 
@@ -83,6 +88,7 @@ multiple pending changes.
 The “git codereview change” command amends the top commit in the stack (HEAD).
 To amend a commit further down the stack, use Git's rebase support,
 for example by using “git commit --fixup” followed by “git codereview rebase-work”.
+The “git codereview amend-fixup” command combines those two steps.
 
 The “git codereview mail” command requires an explicit revision argument,
 but note that since “git codereview mail” is implemented as a “git push”,
@@ -118,30 +124,65 @@ trigger more verbosity in some commands, including sync.
 
 These are omitted from the per-command descriptions below.
 
+# Amend-fixup
+
+The amend-fixup command folds the staged changes into a pending commit
+further down the stack.
+
+	git codereview amend-fixup <revision>
+
+It commits the staged changes using “git commit --fixup=<revision>” and then
+runs “git rebase -i --autosquash” over the pending changes, accepting the
+generated rebase script without opening an editor.
+The revision must be a pending commit on the current branch, and there must
+be staged changes and no unstaged changes.
+
 # Branchpoint
 
 The branchpoint command prints the commit hash of the most recent commit
 on the current branch that is shared with the Gerrit server.
 
-	git codereview branchpoint
+	git codereview branchpoint [-all | -verify rev]
 
 This commit is the point where local work branched from the published tree.
 The command is intended mainly for use in scripts. For example,
 “git diff $(git codereview branchpoint)” or
 “git log $(git codereview branchpoint)..HEAD”.
 
+The -all flag causes the command to print the branchpoints of all local
+branches instead, one per line, each preceded by the branch name.
+
+The -verify flag causes the command to print nothing and instead exit
+with status 0 if the branchpoint is the given revision, or 1 if not,
+so that a script can check that a branch is based on the expected
+upstream commit, as in “git codereview branchpoint -verify origin/main”.
+
 # Change
 
 The change command creates and moves between Git branches and maintains the
 pending changes on work branches.
 
-	git codereview change [-a] [-q] [-m <message>] [branchname]
+	git codereview change [-a] [-f] [-q] [-m <message>] [-no-gpg-sign] [-s | -no-signoff]
+		[-touch] [branchname | -]
+	git codereview change [-f] [-q] [-m <message>] [-no-gpg-sign] [-s | -no-signoff] -- path...
+	git codereview change -detach cl
+	git codereview change -new branchname
+	git codereview change -recover branchname
+	git codereview change [-m <message>] -orphan branchname
+	git codereview change -edit-message revision
+	git codereview change -track origin-branch branchname
+	git codereview change -from-manifest file
+	git codereview change -list
+	git codereview change -fix-upstream
 
 Given a branch name as an argument, the change command switches to the named
 branch, creating it if necessary. If the branch is created and there are staged
 changes, it will commit the changes to the branch, creating a new pending
 change.
 
+As with “git checkout -”, the argument “-” names the branch that was checked
+out before the current one.
+
 With no argument, the change command creates a new pending change from the
 staged changes in the current branch or, if there is already a pending change,
 amends that change.
@@ -149,23 +190,138 @@ amends that change.
 The -q option skips the editing of an extant pending change's commit message.
 If -m is present, -q is ignored.
 
+The -touch option amends the pending change, even if there are no staged
+changes, setting its committer date to the current time. The author and author
+date are preserved. Like -q, it skips the editing of the commit message.
+The command fails if there is no pending change to amend.
+
 The -a option automatically adds any unstaged edits in tracked files during
 commit; it is equivalent to the 'git commit' -a option.
 
+The change command refuses to amend a pending commit whose change has already
+been submitted, as can happen after “git codereview submit -no-sync”, since
+amending the merged commit would only cause confusion. Running
+“git codereview sync” first brings the branch up to date instead.
+The -f option amends the commit anyway.
+
+Paths listed after a “--” argument limit the commit to the current contents
+of those paths, staged or not, as with “git commit -- path...”.
+Other staged changes are left staged but not committed.
+
 The -m option specifies a commit message and skips the editor prompt. This
 option is only useful when creating commits (e.g. if there are unstaged
 changes). If a commit already exists, it is overwritten. If -q is also
 present, -q will be ignored.
 
 The -s option adds a Signed-off-by trailer at the end of the commit message;
-it is equivalent to the 'git commit' -s option.
+it is equivalent to the 'git commit' -s option. If the “always-signoff”
+configuration key is set to “true”, the change command behaves as if -s
+were always given, unless the -no-signoff option is given instead.
+
+The change command commits using git's usual configuration, so if
+commit.gpgsign is set, the commit is signed as usual. If the commit fails
+while commit.gpgsign is set, the change command says so. The -no-gpg-sign
+option commits without signing for just this command; it is equivalent
+to the 'git commit' --no-gpg-sign option.
+
+The change command refuses to commit on a branch with the same name as
+its upstream branch, such as main tracking origin/main, since that would
+leave the local copy of the upstream branch out of sync with the server.
+Create a work branch with “git codereview change <name>” instead.
+
+If the commit message does not use the standard form (see “Hooks” below),
+the change command offers to re-edit it. When standard input is not a terminal,
+as when running from a script, it cannot ask; instead it keeps the message
+and prints a warning, or, if the -strict-message option is given, fails.
+
+The -new option creates a new work branch named branchname at the current
+commit when in detached HEAD mode, such as after “git checkout <hash>”.
+Any commits made in detached HEAD mode become pending changes on the new branch.
+The new branch tracks the origin branch sharing the most history with HEAD.
+The command refuses to overwrite an existing branch.
+
+The -recover option recreates a work branch that was deleted by mistake.
+It finds the commit the branch last pointed to when it was checked out,
+as recorded in the HEAD reflog, creates the branch there, and sets it to
+track the origin branch sharing the most history with that commit.
+It does not switch to the recovered branch.
+
+The -orphan option creates a new branch named branchname with no history,
+for work unrelated to the rest of the repository, such as documentation.
+It requires a clean working tree, removes all the tracked files, leaving
+untracked and ignored files alone, and starts the branch with an empty commit,
+using the -m message if given. The first change on the branch then amends
+that commit. The branch tracks the usual origin branch; use
+“git codereview mail -target” to upload its changes to a different one.
+Since such a branch shares no history with its origin branch, it has
+no branchpoint: “git codereview branchpoint” and “git codereview sync”
+report an error for it, and rebase-work rebases its whole history.
+
+The -track option creates a new work branch named branchname starting at
+and tracking the named branch on the origin server, such as a development
+branch. If that is a “dev.” branch and its codereview.cfg lacks the “branch”
+or “parent-branch” keys needed by the sync-branch command, the change command
+adds them to codereview.cfg, using the current branch's upstream as the parent.
+The edited codereview.cfg is left for the user to review and commit.
+
+The -from-manifest option sets up work branches for a hierarchy of
+development branches in one step. The manifest file has one line per
+work branch, giving its name, the origin branch it tracks, and that
+branch's parent branch, such as “feature dev.feature main”; a # starts
+a comment. As with -track, the change command creates each work branch
+tracking its origin branch, and the work branch names must not already
+exist, contain dots, or name an origin branch. If an origin branch's
+codereview.cfg lacks the “branch” or “parent-branch” keys needed by the
+sync-branch command, the change command commits a codereview.cfg with
+them added, leaving that commit pending on the new work branch, ready
+to mail. When it is done, the change command returns to the branch
+where it started.
+
+The -list option prints the names of the local branches that have
+pending commits, one per line, for use in choosing a branch to switch to.
+It is a quicker alternative to “git codereview pending” when only the
+branch names are needed.
+
+The -fix-upstream option checks the upstream branch that Git records for
+the current branch. If it is missing, or if it differs from the origin
+branch named by the “branch” key in codereview.cfg, the change command
+corrects it with “git branch -u” and reports the old and new settings.
+Other commands make this correction silently when they notice the problem.
+
+The -edit-message option edits the commit message of the named pending
+commit, which need not be the most recent one, in the same way as
+“git codereview reword revision”. It leaves the index and working tree
+untouched.
 
 As a special case, if branchname is a decimal CL number, such as 987, the change
-command downloads the latest patch set of that CL from the server and switches to it.
+command downloads the latest patch set of that CL from the server and switches to it
+on a new branch named cl987, tracking the branch the CL is for.
 A specific patch set P can be requested by adding /P: 987.2 for patch set 2 of CL 987.
 If the origin server is GitHub instead of Gerrit, then the number is
 treated a GitHub pull request number, and the change command downloads the latest
-version of that pull request. In this case, the /P suffix is disallowed.
+version of that pull request onto a new branch named pr987.
+In this case, the /P suffix is disallowed.
+The command refuses to overwrite an existing branch with that name.
+
+The -detach option instead checks out the CL or pull request in detached HEAD
+mode, without creating a branch, for inspecting it without leaving a branch behind.
+
+# Config
+
+The config command reads and writes the repository's codereview.cfg file.
+
+	git codereview config get key
+	git codereview config set key value
+
+The get form prints the value of key, or exits with status 1
+if the configuration does not set it.
+
+The set form sets key to value, rewriting the line that sets key
+in place, or adding a line if there is none, and keeping the other lines
+and comments unchanged. It creates codereview.cfg if needed.
+The values of the gerrit, issuerepo, branch, and parent-branch keys
+are checked before writing, and a key that git-codereview does not know
+draws a warning. See the Configuration section below.
 
 # Gofmt
 
@@ -173,13 +329,36 @@ The gofmt command applies the gofmt program to all files modified in the
 current work branch, both in the staging area (index) and the working tree
 (local directory).
 
-	git codereview gofmt [-l]
+	git codereview gofmt [-check | -l] [-simplify] [-since rev] [path...]
 
 The -l option causes the command to list the files that need reformatting but
 not reformat them. Otherwise, the gofmt command reformats modified files in
 place. That is, files in the staging area are reformatted in the staging area,
 and files in the working tree are reformatted in the working tree.
 
+The -check option is like -l, but the command also exits with a nonzero
+status if any file needs reformatting or cannot be parsed, and with a zero
+status otherwise, for use in continuous integration scripts.
+
+If paths are given, the command considers only the modified files matching
+them, interpreted as Git pathspecs relative to the current directory, such as
+“git codereview gofmt .” to format only files in or below the current directory.
+
+The -simplify option also applies gofmt's -s simplifications, such as
+removing redundant types from composite literals, so that files whose code
+can be simplified are reformatted, or listed by -l and -check, as well.
+Setting the “gofmt-simplify” configuration key to “true” does the same
+for every run of the gofmt command and for the pre-commit hook.
+
+The -since option further limits the command to files that differ between
+the given revision and HEAD, such as “git codereview gofmt -since HEAD~2”
+to format only the files touched by the two most recent commits.
+
+Files matching the “gofmt-exclude” configuration key are skipped.
+See the Configuration section below. Symbolic links are skipped too,
+with a warning, so that formatting never writes through a link. Reformatted
+files keep their modes and are replaced atomically.
+
 # Help
 
 The help command displays basic usage instructions.
@@ -190,7 +369,7 @@ The help command displays basic usage instructions.
 
 The hooks command installs the Git hooks to enforce code review conventions.
 
-	git codereview hooks
+	git codereview hooks [-uninstall]
 
 The pre-commit hook checks that all Go code is formatted with gofmt and that
 the commit is not being made directly to a branch with the same name as the
@@ -204,6 +383,13 @@ The hooks command will not overwrite an existing hook.
 This hook installation is also done at startup by all other git codereview
 commands, except “git codereview help”.
 
+The -uninstall flag removes the hooks instead. Only hook files whose content
+is exactly what the hooks command would install are removed; customized hooks
+are left in place. It also sets the Git configuration key codereview.autohooks
+to false, which stops the other git codereview commands from installing the
+hooks again. Running the hooks command without -uninstall reinstalls the hooks
+and removes that setting.
+
 # Hook-Invoke
 
 The hook-invoke command is an internal command that invokes the named Git hook.
@@ -212,47 +398,178 @@ The hook-invoke command is an internal command that invokes the named Git hook.
 
 It is run by the shell scripts installed by the “git codereview hooks” command.
 
+# Log-cl
+
+The log-cl command prints the history of a change on the Gerrit server:
+the messages recorded as patch sets were uploaded, votes were cast,
+and comments were published, each with its time and author.
+
+	git codereview log-cl <number>
+
+The number is the CL number, as in “git codereview log-cl 987”.
+
 # Mail
 
 The mail command starts the code review process for the pending change.
 
 	git codereview mail [-r email,...] [-cc email,...]
-		[-autosubmit] [-diff] [-f] [-hashtag tag,...]
-		[-nokeycheck] [-topic topic] [-trybot] [-wip]
-		[revision]
+		[-allow-nonprintable] [-auto] [-autosubmit] [-cc-from-reviewed-by]
+		[-change-id-from rev | -cl number] [-depends-on number] [-diff] [-f]
+		[-hashtag tag,...] [-label label=n,...] [-no-cc-watchers] [-no-renotify]
+		[-nokeycheck] [-pick-reviewers] [-private | -remove-private]
+		[-resolve | -unresolved] [-squash] [-strict]
+		[-target branch] [-topic topic] [-trybot]
+		[-validate-reviewers] [-verify-upload] [-wip] [revision]
 
 It pushes the pending change commit in the current branch to the Gerrit code
 review server and prints the URL for the change on the server.
 If the change already exists on the server, the mail command updates that
 change with a new changeset.
 
+Before pushing, the mail command checks that git would push to the Gerrit
+server, not to a read-only mirror such as a GitHub copy of the repository,
+and if not, explains how to set the origin's push URL.
+After pushing, it repeats any warnings that Gerrit included in its
+response, such as about unknown reviewers, so that they are not lost
+among the other messages from the server.
+
 If there are multiple pending commits, the revision argument is mandatory.
 If no revision is specified, the mail command prints a short summary of
 the pending commits for use in deciding which to mail.
 
+The -auto flag mails all the pending commits, as if the revision were HEAD,
+each as its own CL. After pushing, it asks Gerrit for the resulting CLs and
+prints each pending commit followed by the URL of its CL. It cannot be
+combined with a revision argument or with -squash.
+
 If any commit that would be pushed to the server contains the text
 “DO NOT MAIL” (case insensitive) in its commit message, the mail command
-will refuse to send the commit to the server.
+will refuse to send the commit to the server. The phrase can be changed
+with the “do-not-mail-markers” configuration key.
+
+The mail command also refuses to send a commit whose message is not valid
+UTF-8 or contains non-printable characters. The -allow-nonprintable flag
+turns the latter into a warning, for the rare message that needs such
+characters; invalid UTF-8 is always rejected.
+
+If the subject line of any such commit contains a work-in-progress marker,
+by default “TODO(me)”, “WIP”, or “XXX”, the mail command prints a warning.
+The -strict flag makes it refuse to send the commit instead.
+The list of markers can be changed with the “mail-markers” configuration key.
 
 The -r and -cc flags identify the email addresses of people to do the code
 review and to be CC'ed about the code review.
 Multiple addresses are given as a comma-separated list.
 
+An address prefixed with a minus sign, as in “-r -name@domain”, instead
+removes that reviewer from the named change, using the Gerrit API after uploading.
+
 An email address passed to -r or -cc can be shortened from name@domain to name.
 The mail command resolves such shortenings by reading the list of past reviewers
 from the git repository log to find email addresses of the form name@somedomain
 and then, in case of ambiguity, using the reviewer who appears most often.
 
+The -pick-reviewers flag opens the configured text editor on that same list
+of past reviewers, most frequent first, with every line commented out.
+The addresses on the lines left uncommented when the editor exits are added
+to the reviewers, along with any given by -r.
+Because it needs someone to edit the list, -pick-reviewers fails when
+standard input is not a terminal; use -r in scripts instead.
+
+The -cc-from-reviewed-by flag adds the people who were reviewers of the
+change on the Gerrit server but have since been removed from it to the CC
+list, along with any addresses given by -cc. It leaves out the change's owner
+and anyone given by -r or -cc. Current reviewers and CCs are left as they are,
+since Gerrit already notifies them of the new version. This keeps earlier
+reviewers informed when mailing a new version of an old change.
+
+The addresses listed by the “cc-watchers” configuration key are added
+to the CC list of every mailed change. The -no-cc-watchers flag omits them.
+
+The -no-renotify flag causes the mail command to check whether the change
+already exists on the Gerrit server and, if so, not to add the reviewers
+given with -r, or from the “reviewers-file-default” key, so that uploading
+a new patch set to address comments does not notify them again. The -cc list
+is used as usual, and reviewers to remove, as in “-r -rsc”, are still removed.
+
+If the “reviewers-file-default” configuration key names an owners file,
+such as OWNERS, and no -r flag is given, the mail command reads the owners
+files along the paths of the files changed by the commit and adds the
+addresses they list as reviewers, printing the addresses it adds.
+
+If the “mail-confirm-count” configuration key is set to a number and
+the mail command would upload more commits than that, it lists them and
+asks for confirmation first. When standard input is not a terminal,
+it prints the list as a warning instead of asking.
+The -f flag skips this confirmation, as does -squash, which mails a single CL.
+
+If the “warn-missing-tests” configuration key is set to true, the mail
+command prints a reminder for each commit that changes .go files but
+no _test.go files. The reminder is only advisory.
+
+The -validate-reviewers flag causes the mail command to look up each
+reviewer and CC address on the Gerrit server before pushing, and to fail
+if any of them is not a known Gerrit account.
+
+The -verify-upload flag causes the mail command, after pushing, to ask the
+Gerrit server for the change's current revision and to print a warning if it
+is not the commit that was uploaded, as when the server rewrote the commit
+or did not record it. Setting the “verify-upload” configuration key to
+“true” does the same for every mail.
+
+The -change-id-from flag copies the Change-Id line from the commit message
+of the given revision into the commit being mailed, which must not already
+have one. Mailing then uploads a new patch set to that revision's change
+instead of creating a new change, as when a change has been deliberately
+recreated on a new base by cherry-picking it without its Change-Id.
+Because the commit message is amended, the commit must be the most recent
+pending commit. The commit is amended only after the other checks pass,
+and staged changes are not added to it.
+
+The -cl flag looks up the Change-Id of the numbered change on the Gerrit server
+and sets it in the commit being mailed, replacing any Change-Id the commit
+already has, so that mailing uploads a new patch set to that change. This
+restores the association between a commit and its change after it has been
+lost, as in a new clone. As with -change-id-from, the commit must be the
+most recent pending commit, and it is amended only after the other checks pass.
+
+The -depends-on flag uploads the change based on the current patch set
+of the numbered CL, using Gerrit's “base” push option, so that Gerrit records
+the change as depending on that CL, as when stacking changes across branches.
+The commit must already be based on that patch set, and it must have
+a Change-Id, under which the dependency is remembered in the local Git
+configuration. Later mails of the same change use the CL's then-current
+patch set as the base without needing the flag again.
+Use “-depends-on none” to forget the dependency.
+
 The -diff flag shows a diff of the named revision compared against the latest
 upstream commit incorporated into the local branch.
 
 The -f flag forces mail to proceed even if there are staged changes that have
-not been committed. By default, mail fails in that case.
+not been committed, or if the commits being mailed add or modify binary files,
+which are often committed by mistake. By default, mail fails in those cases,
+listing any binary files it found.
 
 The -nokeycheck flag disables the Gerrit server check for committed files
 containing data that looks like public keys. (The most common time -nokeycheck
 is needed is when checking in test cases for cryptography libraries.)
 
+The -squash flag mails the named revision and all the pending commits
+below it as a single change, for those who prefer to build one change
+out of several local commits. The mail command creates a temporary commit
+combining them, with the named revision's author, commit message, and
+Change-Id, and uploads that instead. The branch itself is left unchanged.
+It refuses to combine a commit that has a different Change-Id, which
+belongs to a change of its own; remove that Change-Id line to combine it.
+
+The -target flag uploads the change for review on the named branch
+instead of the branch's upstream, as when proposing a cherry-pick for
+a release branch. The branch must already exist on origin.
+
+The -topic flag sets the Gerrit topic of the uploaded changes. Because the
+topic is sent as part of the git push, it may contain only letters, digits,
+and the punctuation characters - _ . + / @, and it may not contain “..”.
+
 The -trybot flag sets a Commit-Queue+1 vote on any uploaded changes.
 The Go project uses this vote to start running integration tests on the CL.
 During the transition between two CI systems, the environment variable
@@ -261,27 +578,125 @@ to explicitly override where the -trybot flag starts integration tests.
 
 The -autosubmit flag sets a Auto-Submit+1 vote on any uploaded changes.
 
+The -label flag sets the given comma-separated label votes, such as
+“-label Hold=1” or “-label API-Review=1,Hold=-1”, on any uploaded changes.
+It may be repeated. This allows setting project-specific Gerrit labels
+at upload time; the -trybot and -autosubmit flags are shorthands
+for particular votes.
+
 The -wip flag marks any uploaded changes as work-in-progress.
 
+The -private flag marks any uploaded changes as private, so that only
+their owner and reviewers can see them, for sensitive work that should
+not yet be broadly visible. It can be combined with -wip.
+The -remove-private flag clears the private mark again.
+
+The -resolve flag marks all unresolved comment threads on the mailed change
+as resolved, by replying “Done” to each. The -unresolved flag does the reverse,
+reopening all resolved comment threads. Only the change for the named revision
+is affected, not any other changes uploaded along with it.
+
 The mail command updates the tag <branchname>.mailed to refer to the
 commit that was most recently mailed, so running “git diff <branchname>.mailed”
 shows diffs between what is on the Gerrit server and the current directory.
 
+# Open
+
+The open command opens the code review page for a pending change
+in the default web browser.
+
+	git codereview open [revision]
+
+If there are multiple pending commits, the revision argument is mandatory.
+If the change has not yet been mailed, the open command says so
+and suggests using “git codereview mail” to create it.
+
 # Pending
 
 The pending command prints to standard output the status of all pending changes
 and staged, unstaged, and untracked files in the local repository.
 
-	git codereview pending [-c] [-l] [-s]
+	git codereview pending [-c] [-color when] [-l | -no-fetch] [-max-behind n] [-new] [-q | -url-only | -watch n] [-s | -oneline] [-since duration] [-summary]
 
 The -c flag causes the command to show pending changes only on the current branch.
 
 The -l flag causes the command to use only locally available information.
 By default, it fetches recent commits and code review information from the
-Gerrit server.
+Gerrit server. That information includes the Gerrit topic of each
+mailed change, shown as a “topic: name” tag.
+
+The -no-fetch flag causes the command to skip fetching recent commits from
+the origin server, using the upstream branches as last fetched, while still
+fetching code review information from the Gerrit server. Since it depends on
+that fetch, the “upstream deleted” check described below is skipped too.
+It makes for a faster listing just after a “git fetch”. It cannot be
+combined with -l.
 
 The -s flag causes the command to print abbreviated (short) output.
 
+The -oneline flag causes the command to print exactly one line for each branch,
+including the number of pending changes and whether there are uncommitted changes,
+followed by one line for each pending change, with no blank lines.
+It cannot be combined with -s.
+
+The -summary flag causes the command to end its output with a line of
+total counts for the branches shown, such as
+“5 branches, 12 pending CLs (8 mailed, 2 submitted), 3 behind upstream”,
+where the last count is the number of branches behind their upstream branch.
+
+The -new flag causes the command to remember the number of comments on each
+mailed change and, on later runs with -new, to tag changes that have gained
+comments since then with “N new comments”. The counts are kept in
+the file .git/codereview-pending-comments.
+
+The -q flag causes the command to print nothing and instead report the state
+of the current branch in its exit status, for use in scripts: 0 if all pending
+changes (if any) are mailed and the branch is up to date, 1 if there are pending
+changes that have not been mailed, or 2 if the branch is behind its upstream branch.
+With -v, it also prints a one-line description of the state.
+
+The -url-only flag causes the command to print only the Gerrit URLs of the
+mailed changes, one per line, for piping into other tools. Combined with -c,
+it prints only the URLs for the current branch. It cannot be combined with
+-l, -q, or -watch.
+
+Unless -l or -no-fetch is given, a branch whose upstream branch has been deleted on the
+server since it was created is tagged “upstream deleted”, as a reminder
+to retarget it with “git branch -u” or to delete it.
+
+The -watch flag causes the command to repeat the listing every n seconds
+until interrupted, clearing the screen before each update when standard output
+is a terminal, as a simple dashboard for following the progress of reviews
+and trybots. It cannot be combined with -q.
+
+The -since flag causes the command to show only the branches with activity
+within the given duration, written as a Go duration such as “72h”, hiding
+long-stale ones. A branch's latest activity is the later of the committer date
+of its newest pending commit and, unless -l is given, the time its changes
+were last updated on the Gerrit server. The current branch is always shown.
+
+The -max-behind flag causes the command to add a “STALE” tag to any branch
+that is more than n commits behind its upstream branch, as a reminder to sync.
+
+A change that has been mailed from the branch but has since been amended
+locally, so that the commit differs from every version mailed from the branch,
+is tagged “local edits not mailed”, as a reminder to mail it again.
+This check uses only local information: the mail command records each
+commit it mails in the reflog of the <branchname>.mailed tag.
+
+A change that exists on the Gerrit server but has no patch set matching the
+local commit, as when it was mailed from another clone or its old revisions
+are gone from the server, is tagged “revision not on server”, to tell it apart
+from a change that was never mailed. This check needs the Gerrit information,
+so -l omits it.
+
+The -color flag controls whether the command colors its output, highlighting
+branch names, mailed and submitted tags, and how far branches are behind.
+It takes the value always, never, or auto. The default, auto, colors the output
+only when standard output is a terminal, the NO_COLOR environment variable
+is unset, and Git's color.pending or color.ui configuration allows color.
+The text of the output is the same with or without color.
+
 Useful aliases include “git p” for “git pending” and “git pl” for “git pending -l”
 (notably faster but without Gerrit information).
 
@@ -299,11 +714,25 @@ new commits from the origin branch during the rebase;
 In multiple-commit workflows, rebase-work is used so often that it can be helpful
 to alias it to “git rw”.
 
+# Reviewers
+
+The reviewers command lists the reviewers known from the Reviewed-by lines
+in recent commits on the current branch, most frequent first, along with
+the number of commits each has reviewed.
+
+	git codereview reviewers [prefix]
+
+If a prefix is given, only addresses beginning with that prefix are listed.
+This is the list the mail command uses to expand short names given to its
+-r and -cc flags: a short name like “name” expands to the first listed
+address beginning with “name@”.
+
 # Reword
 
 The reword command edits pending commit messages.
 
-	git codereview reword [commit...]
+	git codereview reword [-s] [-trailer key=value...] [commit...]
+	git codereview reword -undo
 
 Reword opens the editor on the commit messages for the named comments.
 When the editing is finished, it applies the changes to the pending commits.
@@ -321,22 +750,67 @@ Reword is most useful for editing commit messages on a multiple-commit work
 branch, but it can also be useful in single-commit work branches to allow
 editing a commit message without committing staged changes at the same time.
 
+The -s flag adds a Signed-off-by trailer for the current user, as “git commit -s”
+would, to each reworded message that does not already have one.
+
+The -trailer flag, which may be repeated, sets the trailer “key: value”
+in each named commit message without opening an editor, following the rules of
+“git interpret-trailers --if-exists replace”: an existing trailer with the
+same key is updated in place, and otherwise the trailer is added at the end
+of the message. An empty value removes the trailer. For example:
+
+	git codereview reword -trailer Bug=12345 -trailer Cq-Include-Trybots= HEAD
+
+The -undo flag undoes the most recent reword on the current branch,
+restoring the commits as they were before it, again without touching the
+index or working tree. It refuses if the branch has changed in any other
+way since the reword, such as by a new commit. The reworded messages remain
+saved in .git/REWORD_MSGS, in case they are wanted again.
+
 # Submit
 
 The submit command pushes the pending change to the Gerrit server and tells
 Gerrit to submit it to the upstream branch.
 
-	git codereview submit [-i | revision...]
+	git codereview submit [-check-ci] [-f] [-json] [-no-sync | -queue] [-vote label+n,...] [-i | revision...]
 
 The command fails if there are modified files (staged or unstaged) that are not
 part of the pending change.
 
+Before submitting, the command checks that the change looks submittable.
+If the Gerrit server reports the change's submit requirements, the command
+names any requirement that is not satisfied. On older servers, it instead
+checks that every required label, such as Code-Review, is approved.
+
 The -i option causes the submit command to open a list of commits to submit
 in the configured text editor, similar to “git rebase -i”.
 
 If multiple revisions are specified, the submit command submits each one in turn,
 stopping at the first failure.
 
+Because Gerrit submits a change together with the changes it depends on,
+submitting a revision also submits the changes for any pending commits
+below it in a multiple-commit work branch. If any of those are not
+themselves being submitted and have not already been merged, according to
+either the local copy of the upstream branch or the Gerrit server, the
+submit command warns that the stack is being submitted out of order,
+lists them, and asks for confirmation before continuing. When standard
+input is not a terminal, or with -json, it cannot ask, so it stops instead.
+The -f option skips this confirmation.
+
+The -vote option applies the given comma-separated label votes, such as
+“-vote Code-Review+2”, to each change before submitting it, for changes
+that the user is allowed to approve. The usual check that the change has
+the approvals it needs happens after the votes are applied.
+
+The -check-ci option makes the command also require that the change's
+CI results be green, separately from its review approvals. It refuses to
+submit a change unless the local commit is the revision that CI tested and
+the CI label votes on it report success, naming any CI label that is
+failing or still pending. By default the CI labels are TryBot-Result and
+LUCI-TryBot-Result; the “ci-labels” configuration key can list others.
+The -check-ci option cannot be combined with -queue.
+
 When run in a multiple-commit work branch,
 either the -i option or the revision argument is mandatory.
 If both are omitted, the submit command prints a short summary of
@@ -348,15 +822,54 @@ If not, it will prompt the user to run “git codereview sync” manually.
 
 After a successful sync, the branch can be used to prepare a new change.
 
+The -no-sync option skips that synchronization, leaving the current branch
+as it was, for continuing work on follow-up commits. The command then
+reminds the user to run “git codereview sync” later.
+
+The -queue option, for projects that land changes through a commit queue,
+applies a Commit-Queue+2 vote to each change instead of submitting it directly,
+leaving the commit queue to submit it. The “commit-queue-vote” configuration
+key can name a different vote, such as “Commit-Queue+1”. Any -vote votes are
+applied at the same time. Since the changes are not yet submitted, the
+command does not sync the local branch afterward.
+
+The -json option, for use by automation, replaces the usual messages with
+one line of JSON on standard output for each revision submitted, giving its
+commit hash, CL number, Change-Id, final Gerrit status, and, once merged,
+the hash of the merged commit. If a submit fails, the line for that revision
+also gives the error, and the command stops and exits with a non-zero status.
+
 # Sync
 
 The sync command updates the local repository.
 
-	git codereview sync
+	git codereview sync [-continue | [-no-fetch | -prune] [-rebase-merges]]
 
 It fetches commits from the remote repository and merges them from the
 upstream branch to the current branch, rebasing any pending changes.
 
+The -no-fetch flag skips contacting the remote repository and instead
+rebases onto the upstream branch as of the most recent fetch,
+which is faster when another command has just fetched.
+
+The -prune flag first removes the remote-tracking branches, such as
+origin/dev.feature, whose branches have been deleted on the server.
+By default, sync leaves them in place, and they linger until removed.
+Pruning them keeps the list of origin branches that git-codereview
+consults, as when creating branches with “change -track”, accurate.
+
+The -rebase-merges flag preserves merge commits among the pending changes,
+passing “--rebase-merges” to the underlying rebase. By default, the rebase
+flattens them, as “git pull -r” does.
+
+If rebasing the pending changes stops with conflicts, resolve them,
+use “git add” to mark them resolved, and then run
+“git codereview sync -continue” to finish the rebase and the sync.
+
+If the only pending change has been submitted, sync drops the local commit.
+Any edits in that commit that were not part of the submitted version are
+left as uncommitted changes, and sync prints a warning listing the affected files.
+
 # Sync-branch
 
 The sync-branch command merges changes from the parent branch into
@@ -373,6 +886,19 @@ conflicts and exits. After fixing the conflicts, running
 
 will continue the process. (If the merge should be abandoned, use
 the standard “git merge -abort” command instead.)
+To see the state of an interrupted sync-branch, including the merged
+commits, the conflicting files, and what to do next, run
+
+	git codereview sync-branch -status
+
+To preview a sync-branch without merging, run
+
+	git codereview sync-branch -dry-run
+
+which fetches the current and parent branches, lists the commits that
+the merge would bring in, and reports any files expected to conflict.
+It leaves the current branch and the working tree unchanged and writes
+no sync-branch status. Predicting conflicts requires Git 2.38 or later.
 
 The sync-branch command depends on the codereview.cfg having
 branch and parent-branch keys. See the Configuration section below.
@@ -389,6 +915,36 @@ After running this command, the local checkout of the dev branch will
 be configured to mail changes to the parent branch instead of the
 dev branch.
 
+# Trybot
+
+The trybot command starts the trybots for a change that has already been mailed.
+
+	git codereview trybot [revision]
+
+It sets the same votes on the change on the Gerrit server as the mail command's
+-trybot flag, including the choice of votes made by GIT_CODEREVIEW_TRYBOT,
+but without uploading a new patch set. This is useful for re-running trybots
+after a spurious failure.
+
+If there are multiple pending commits, the revision argument is mandatory.
+
+# Verify
+
+The verify command checks pending changes for the problems that would
+otherwise be reported one at a time by the commit hooks or the mail command,
+and reports them all at once.
+
+	git codereview verify [revision]
+
+It checks the named revision and the pending commits below it, or all pending
+commits if no revision is given. For each commit, it checks that the commit
+message has the conventional “pkg/path: summary” form, that it has exactly one
+Change-Id line (when using Gerrit), that it does not say “DO NOT MAIL”, that it
+is not a fixup! or squash! commit, and that it adds no editor temporary files
+and no leftover merge conflict markers. It also checks that the Go files
+modified anywhere on the branch are formatted with gofmt, as the pre-commit
+hook does.
+
 # Configuration
 
 If a file named codereview.cfg is present in the repository root,
@@ -407,6 +963,104 @@ if different from the source repository. If set to “golang/go”, for example,
 lines such as “Fixes #123” in a commit message will be rewritten to
 “Fixes golang/go#123”.
 
+The “gofmt-exclude” key lists glob patterns, separated by commas or spaces,
+naming Go files that the gofmt command and the pre-commit hook should neither
+check nor reformat, such as generated files. A pattern containing a slash
+is matched against the file's path relative to the repository root;
+a pattern without a slash is matched against the file's base name.
+For example:
+
+	gofmt-exclude: *.pb.go, internal/gen/*.go
+
+The “gofmt-simplify” key, if set to “true”, makes the gofmt command and
+the pre-commit hook apply gofmt's -s simplifications too, as with
+“git codereview gofmt -simplify”.
+
+The “do-not-mail-markers” key lists comma-separated phrases that, appearing
+anywhere in a commit message (case insensitive), make the mail command refuse
+to send the commit. It replaces the default phrase “DO NOT MAIL”. For example:
+
+	do-not-mail-markers: DO NOT MAIL, DO NOT REVIEW
+
+The “mail-markers” key lists comma-separated work-in-progress markers that
+the mail command looks for in commit subjects, replacing the default list of
+“TODO(me)”, “WIP”, and “XXX”. Setting it to “off” disables the check.
+
+A key of the form “<command>-flags” lists default flags for that command,
+separated by spaces, as if they had been given first on the command line.
+Flags given on the command line still take precedence. For example, to
+start trybots and set Auto-Submit whenever a change is mailed:
+
+	mail-flags: -trybot -autosubmit
+
+The “cc-watchers” key lists comma-separated addresses, such as a mailing list,
+that the mail command CCs on every change unless given -no-cc-watchers.
+For example:
+
+	cc-watchers: project-dev@example.com
+
+The “reviewers-file-default” key names the OWNERS-style file from which
+the mail command takes reviewers when run without -r. For each changed file,
+the mail command reads that file in the changed file's directory and in each
+parent directory up to the repository root, stopping at a file containing
+“set noparent”. Each line holding a single address names a reviewer;
+comments, “per-file” rules, “file:” includes, and “*” are ignored,
+as is the commit author's own address. For example:
+
+	reviewers-file-default: OWNERS
+
+The “mail-confirm-count” key sets the number of commits that the mail
+command uploads without asking for confirmation, guarding against
+surprise bulk uploads from deep stacks. For example:
+
+	mail-confirm-count: 3
+
+The “verify-upload” key, if set to true, makes the mail command check
+after each upload that Gerrit recorded the uploaded commit, as with
+“git codereview mail -verify-upload”.
+
+The “warn-missing-tests” key, if set to true, makes the mail command
+warn about commits that change Go code without changing any tests:
+
+	warn-missing-tests: true
+
+The “ci-labels” key lists, separated by commas or spaces, the labels that
+“git codereview submit -check-ci” consults for CI results, replacing the
+default of TryBot-Result and LUCI-TryBot-Result. For example:
+
+	ci-labels: Verified, Commit-Queue
+
+The “commit-queue-vote” key names the label vote that
+“git codereview submit -queue” applies to ask the commit queue
+to submit a change. The default is Commit-Queue+2. For example:
+
+	commit-queue-vote: Commit-Queue+1
+
+The “always-signoff” key, if set to “true”, makes the change command add
+a Signed-off-by trailer to every commit, as with “git codereview change -s”,
+for projects that require a sign-off on every commit.
+
+The “dedup-trailers” key, if set to “true”, makes the commit-msg hook
+remove repeated identical trailers, such as a “Bug:” or “Fixes:” line
+added again on each amend, from the final paragraph of the commit message,
+keeping the first of each. Trailers with the same key but different values
+are kept, and a Change-Id line is never removed.
+
+The “module-guard” key, if set to “true”, makes the change command print
+a warning when the files being committed belong to more than one Go module,
+as determined by the nearest go.mod file above each file. This can help
+catch mistakes in repositories containing nested modules.
+
+The “generated-files” and “generated-sources” keys, if both set, list glob
+patterns matching generated files and the sources they are generated from,
+separated by commas or spaces and matched as for “gofmt-exclude”.
+The change command then prints a warning when the files being committed
+include generated files but no sources, or sources but no generated files,
+as a reminder to regenerate. For example:
+
+	generated-files: *.pb.go
+	generated-sources: *.proto
+
 The “branch” key specifies the name of the branch on the origin server
 corresponding to the current checkout. If this setting is missing, git-codereview
 uses the name of the remote branch that the current checkout is tracking.
@@ -10,6 +10,26 @@ import (
 	"os/exec"
 )
 
+// checkEditor dies with an explanation if the editor git would run
+// for a commit message cannot possibly work, so that the problem is
+// reported up front instead of as a failure deep inside git commit.
+// Git chooses the editor from GIT_EDITOR, core.editor, VISUAL, and EDITOR,
+// in that order (see git-var(1)), and runs it using the shell,
+// so the setting may quote its path or set environment variables.
+// Only an empty setting or one that is always false is rejected.
+func checkEditor(cmd string) {
+	gitEditor, err := cmdOutputErr("git", "var", "GIT_EDITOR")
+	gitEditor = trim(gitEditor)
+	if err != nil || gitEditor == "" {
+		dief("cannot %s: no editor configured for the commit message\n"+
+			"\tset core.editor or EDITOR, or use -m to give the message", cmd)
+	}
+	if gitEditor == "false" {
+		dief("cannot %s: editor %q always fails\n"+
+			"\tset GIT_EDITOR, core.editor, or EDITOR to a usable editor, or use -m to give the message", cmd, gitEditor)
+	}
+}
+
 // editor invokes an interactive editor on a temporary file containing
 // initial, blocks until the editor exits, and returns the (possibly
 // edited) contents of the temporary file. It follows the conventions
@@ -6,7 +6,7 @@ package main
 
 import (
 	"bytes"
-	"flag"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -20,33 +20,99 @@ var gofmtList bool
 func cmdGofmt(args []string) {
 	// NOTE: New flags should be added to the usage message below as well as doc.go.
 	flags.BoolVar(&gofmtList, "l", false, "list files that need to be formatted")
+	check := flags.Bool("check", false, "list files that need to be formatted and exit nonzero if there are any")
+	since := flags.String("since", "", "consider only files changed between `rev` and HEAD")
+	simplify := flags.Bool("simplify", false, "also simplify code, as with gofmt -s")
 	flags.Parse(args)
-	if len(flag.Args()) > 0 {
-		fmt.Fprintf(stderr(), "Usage: %s gofmt %s [-l]\n", progName, globalFlags)
-		exit(2)
+	for _, arg := range flags.Args() {
+		if strings.HasPrefix(arg, "-") {
+			fmt.Fprintf(stderr(), "Usage: %s gofmt %s [-check | -l] [-simplify] [-since rev] [path...]\n", progName, globalFlags)
+			exit(2)
+		}
+	}
+	if *since != "" {
+		if _, err := cmdOutputErr("git", "rev-parse", "--verify", "--quiet", *since+"^{commit}"); err != nil {
+			dief("cannot gofmt -since: unknown revision %s", *since)
+		}
+	}
+	if *check {
+		gofmtList = true
 	}
 
 	f := gofmtCommand
 	if !gofmtList {
 		f |= gofmtWrite
 	}
+	if *simplify {
+		f |= gofmtSimplify
+	}
 
-	files, stderr := runGofmt(f)
+	results := runGofmt(f, *since, flags.Args())
+	files := gofmtNames(results)
 	if gofmtList {
 		w := stdout()
 		for _, file := range files {
 			fmt.Fprintf(w, "%s\n", file)
 		}
 	}
-	if stderr != "" {
-		dief("gofmt reported errors:\n\t%s", strings.Replace(strings.TrimSpace(stderr), "\n", "\n\t", -1))
+	if errs := gofmtErrors(results); errs != "" {
+		dief("gofmt reported errors:\n\t%s", strings.Replace(errs, "\n", "\n\t", -1))
+	}
+	if *check && len(files) > 0 {
+		exit(1)
+	}
+}
+
+// A gofmtResult is runGofmt's report about a single file.
+type gofmtResult struct {
+	path        string // file name, relative to the current directory if inside it
+	staged      bool   // refers to the index copy, which differs from the working tree
+	unstaged    bool   // refers to the working tree copy, which differs from the index
+	needsFormat bool   // gofmt would reformat (or did reformat) the file
+	err         error  // errors reported by gofmt, one per line, in file:line form
+}
+
+// name returns the file name to show for r, including a
+// " (staged)" or " (unstaged)" suffix if needed.
+func (r *gofmtResult) name() string {
+	switch {
+	case r.staged:
+		return r.path + " (staged)"
+	case r.unstaged:
+		return r.path + " (unstaged)"
 	}
+	return r.path
+}
+
+// gofmtNames returns the names of the files in results that need formatting.
+func gofmtNames(results []*gofmtResult) []string {
+	var names []string
+	for _, r := range results {
+		if r.needsFormat {
+			names = append(names, r.name())
+		}
+	}
+	return names
+}
+
+// gofmtErrors returns the errors reported in results,
+// as a single newline-separated block of text,
+// or the empty string if there are none.
+func gofmtErrors(results []*gofmtResult) string {
+	var errs []string
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err.Error())
+		}
+	}
+	return strings.Join(errs, "\n")
 }
 
 const (
 	gofmtPreCommit = 1 << iota
 	gofmtCommand
 	gofmtWrite
+	gofmtSimplify
 )
 
 // runGofmt runs the external gofmt command over modified files.
@@ -58,11 +124,19 @@ const (
 // If gofmtCommand is set, then runGofmt considers all those files
 // in addition to files with unstaged modifications.
 // It never considers untracked files.
+// If paths is not empty, runGofmt considers only files matching
+// those git pathspecs, which are relative to the current directory.
+// If since is not empty, runGofmt considers only files that also
+// differ between the since revision and HEAD.
 //
 // As a special case for the main repo (but applied everywhere)
 // *.go files under a top-level test directory are excluded from the
 // formatting requirement, except run.go and those in test/bench/.
 //
+// If gofmtSimplify is set, or if the "gofmt-simplify" configuration key
+// is "true", runGofmt runs gofmt -s, so that files whose code gofmt would
+// simplify also count as needing formatting.
+//
 // If gofmtWrite is set (only with gofmtCommand, meaning this is 'git gofmt'),
 // runGofmt replaces the original files with their formatted equivalents.
 // Git makes this difficult. In general the file in the working tree
@@ -89,21 +163,22 @@ const (
 // that if 'git gofmt' is interrupted, a second 'git gofmt' will put things into
 // the same state the first would have.
 //
-// runGofmt returns a list of files that need (or needed) reformatting.
-// If gofmtPreCommit is set, the names always refer to files in the index.
-// If gofmtCommand is set, then a name without a suffix (see below)
+// runGofmt returns a result for each file that needs (or needed) reformatting,
+// sorted by name, followed by a result for each file that gofmt reported
+// errors for, usually syntax errors in the Go source files, in the order
+// gofmt reported them.
+// If gofmtPreCommit is set, the results always refer to files in the index.
+// If gofmtCommand is set, then a result with neither staged nor unstaged set
 // refers to both the copy in the index and the copy in the working tree
 // and implies that the two copies are identical. Otherwise, in the case
-// that the index and working tree differ, the file name will have an explicit
+// that the index and working tree differ, the result's name has an explicit
 // " (staged)" or " (unstaged)" suffix saying which is meant.
 //
-// runGofmt also returns any standard error output from gofmt,
-// usually indicating syntax errors in the Go source files.
-// If gofmtCommand is set, syntax errors in index files that do not match
+// If gofmtCommand is set, errors in index files that do not match
 // the working tree show a " (staged)" suffix after the file name.
 // The errors never use the " (unstaged)" suffix, in order to keep
 // references to the local file system in the standard file:line form.
-func runGofmt(flags int) (files []string, stderrText string) {
+func runGofmt(flags int, since string, paths []string) []*gofmtResult {
 	pwd, err := os.Getwd()
 	if err != nil {
 		dief("%v", err)
@@ -128,17 +203,56 @@ func runGofmt(flags int) (files []string, stderrText string) {
 		isBranchTagMove := strings.Contains(cmdOutput("git", "branch", "-r", "--contains", b.FullName()), "origin/")
 		if !isBranchTagMove {
 			branchpt = b.Branchpoint()
+			if branchpt == "" {
+				branchpt = emptyTree()
+			}
 		}
 	}
-	indexFiles := addRoot(repo, filter(gofmtRequired, nonBlankLines(cmdOutput("git", "diff", "--name-only", "--diff-filter=ACM", "--cached", branchpt, "--"))))
-	localFiles := addRoot(repo, filter(gofmtRequired, nonBlankLines(cmdOutput("git", "diff", "--name-only", "--diff-filter=ACM"))))
+	indexArgs := append([]string{"diff", "--name-only", "--diff-filter=ACM", "--cached", branchpt, "--"}, paths...)
+	localArgs := append([]string{"diff", "--name-only", "--diff-filter=ACM", "--"}, paths...)
+	indexFiles := addRoot(repo, filter(gofmtRequired, nonBlankLines(cmdOutput("git", indexArgs...))))
+	localFiles := addRoot(repo, filter(gofmtRequired, nonBlankLines(cmdOutput("git", localArgs...))))
+	if since != "" {
+		sinceFiles := stringMap(addRoot(repo, nonBlankLines(cmdOutput("git", "diff", "--name-only", since, "HEAD", "--"))))
+		isSince := func(file string) bool {
+			return sinceFiles[file]
+		}
+		indexFiles = filter(isSince, indexFiles)
+		localFiles = filter(isSince, localFiles)
+	}
+
+	// Skip symbolic links: gofmt would write through a link to its target,
+	// which may be outside the repository, and the index copy of a link
+	// holds only the name of its target.
+	indexModes := indexFileModes(repo, indexFiles)
+	var links []string
+	indexFiles = filter(func(file string) bool {
+		if indexModes[file] == "120000" {
+			links = append(links, file)
+			return false
+		}
+		return true
+	}, indexFiles)
+	localFiles = filter(func(file string) bool {
+		if fi, err := os.Lstat(file); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			if flags&gofmtCommand != 0 && indexModes[file] == "" {
+				links = append(links, file)
+			}
+			return false
+		}
+		return true
+	}, localFiles)
+	for _, file := range links {
+		printf("warning: gofmt: skipping symbolic link %s", strings.TrimPrefix(file, pwd))
+	}
+
 	localFilesMap := stringMap(localFiles)
 	isUnstaged := func(file string) bool {
 		return localFilesMap[file]
 	}
 
 	if len(indexFiles) == 0 && ((flags&gofmtCommand) == 0 || len(localFiles) == 0) {
-		return
+		return nil
 	}
 
 	// Determine which files have unstaged changes and are therefore
@@ -236,15 +350,11 @@ func runGofmt(flags int) (files []string, stderrText string) {
 		dief(format, args...) // calling top-level dief function
 	}
 
-	// Run gofmt to find out which files need reformatting;
-	// if gofmtWrite is set, reformat them in place.
+	// Run gofmt to find out which files need reformatting.
 	// For references to local files, remove leading pwd if present
 	// to make relative to current directory.
 	// Temp files and local-only files stay as absolute paths for easy matching in output.
-	args := []string{"-l"}
-	if flags&gofmtWrite != 0 {
-		args = append(args, "-w")
-	}
+	var args []string
 	for _, file := range indexFiles {
 		if isUnstaged(file) {
 			args = append(args, fileToTemp[file])
@@ -256,22 +366,24 @@ func runGofmt(flags int) (files []string, stderrText string) {
 		args = append(args, localFiles...)
 	}
 
-	if *verbose > 1 {
-		fmt.Fprintln(stderr(), commandString("gofmt", args))
-	}
-	cmd := exec.Command("gofmt", args...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	err = cmd.Run()
-
-	if stderr.Len() == 0 && err != nil {
+	simplify := flags&gofmtSimplify != 0 || config()["gofmt-simplify"] == "true"
+	stdout, stderr, err := runGofmtParallel(simplify, args)
+	if stderr == "" && err != nil {
 		// Error but no stderr: usually can't find gofmt.
 		dief("invoking gofmt: %v", err)
 	}
 
 	// Build file list.
-	files = lines(stdout.String())
+	files := lines(stdout)
+
+	// If gofmtWrite is set, reformat the files in place.
+	if flags&gofmtWrite != 0 {
+		for _, file := range files {
+			if err := gofmtWriteFile(file, simplify); err != nil {
+				dief("%v", err)
+			}
+		}
+	}
 
 	// Restage files that need to be restaged.
 	if flags&gofmtWrite != 0 {
@@ -296,7 +408,11 @@ func runGofmt(flags int) (files []string, stderrText string) {
 			}
 			var buf bytes.Buffer
 			for i, name := range updateIndex {
-				fmt.Fprintf(&buf, "100644 %s\t%s\n", hashes[i], name)
+				mode := indexModes[filepath.Join(repo, name)]
+				if mode == "" {
+					mode = "100644"
+				}
+				fmt.Fprintf(&buf, "%s %s\t%s\n", mode, hashes[i], name)
 			}
 			verbosef("git update-index --index-info")
 			cmd := exec.Command("git", "update-index", "--index-info")
@@ -308,44 +424,236 @@ func runGofmt(flags int) (files []string, stderrText string) {
 		}
 	}
 
-	// Remap temp files back to original names for caller.
-	for i, file := range files {
+	// Build results, remapping temp files back to original names for caller.
+	var results []*gofmtResult
+	byName := make(map[string]*gofmtResult)
+	for _, file := range files {
+		r := &gofmtResult{needsFormat: true}
 		if real := tempToFile[file]; real != "" {
-			if flags&gofmtCommand != 0 {
-				real += " (staged)"
-			}
-			files[i] = strings.TrimPrefix(real, pwd)
-		} else if isUnstaged(file) {
-			files[i] = strings.TrimPrefix(file+" (unstaged)", pwd)
+			r.path = strings.TrimPrefix(real, pwd)
+			r.staged = flags&gofmtCommand != 0
+		} else {
+			r.path = strings.TrimPrefix(file, pwd)
+			r.unstaged = isUnstaged(file)
 		}
+		results = append(results, r)
+		byName[r.name()] = r
 	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].name() < results[j].name()
+	})
 
 	// Rewrite temp names in stderr, and shorten local file names.
 	// No suffix added for local file names (see comment above).
-	text := "\n" + stderr.String()
+	// Then attribute each line to the file it names.
+	errFiles := make(map[string]*gofmtResult)
+	text := "\n" + stderr
 	for temp, file := range tempToFile {
-		if flags&gofmtCommand != 0 {
-			file += " (staged)"
-		}
-		text = strings.Replace(text, "\n"+temp+":", "\n"+strings.TrimPrefix(file, pwd)+":", -1)
+		r := &gofmtResult{path: strings.TrimPrefix(file, pwd), staged: flags&gofmtCommand != 0}
+		errFiles[r.name()] = r
+		text = strings.Replace(text, "\n"+temp+":", "\n"+r.name()+":", -1)
 	}
 	for _, file := range localFiles {
+		errFiles[strings.TrimPrefix(file, pwd)] = &gofmtResult{path: strings.TrimPrefix(file, pwd)}
 		text = strings.Replace(text, "\n"+file+":", "\n"+strings.TrimPrefix(file, pwd)+":", -1)
 	}
-	text = text[1:]
+	for _, file := range args {
+		if tempToFile[file] == "" && errFiles[file] == nil {
+			errFiles[file] = &gofmtResult{path: file}
+		}
+	}
+	var errResults []*gofmtResult // in order of first error
+	errLines := make(map[*gofmtResult][]string)
+	var cur *gofmtResult
+	for _, line := range lines(strings.TrimSpace(text)) {
+		// Find the longest file name the line starts with.
+		// A line that does not name a file continues the previous one.
+		var match *gofmtResult
+		for name, r := range errFiles {
+			if strings.HasPrefix(line, name+":") && (match == nil || len(name) > len(match.name())) {
+				match = r
+			}
+		}
+		if match != nil {
+			cur = match
+		} else if cur == nil {
+			cur = new(gofmtResult)
+		}
+		if errLines[cur] == nil {
+			errResults = append(errResults, cur)
+		}
+		errLines[cur] = append(errLines[cur], line)
+	}
+	for _, r := range errResults {
+		r.err = errors.New(strings.Join(errLines[r], "\n"))
+		if f := byName[r.name()]; f != nil && r.path != "" {
+			f.err = r.err
+			continue
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// gofmtBatchSize is the maximum number of files passed to a single
+// gofmt invocation by runGofmtParallel.
+var gofmtBatchSize = 50
+
+// runGofmtParallel runs gofmt -l (and -s, if simplify is set) over files,
+// splitting them into batches that are processed concurrently.
+// It returns the concatenated standard output and standard error
+// of the batches, in the order the files were given,
+// along with the first error encountered, if any.
+func runGofmtParallel(simplify bool, files []string) (stdoutText, stderrText string, err error) {
+	type result struct {
+		stdout, stderr bytes.Buffer
+		err            error
+	}
+	var batches [][]string
+	for len(files) > 0 {
+		n := len(files)
+		if n > gofmtBatchSize {
+			n = gofmtBatchSize
+		}
+		batches = append(batches, files[:n])
+		files = files[n:]
+	}
+	results := make([]result, len(batches))
 
-	sort.Strings(files)
-	return files, text
+	// Build work queue.
+	work := make(chan int, len(batches))
+	done := make(chan bool, len(batches))
+	for i := range batches {
+		work <- i
+	}
+	close(work)
+
+	// Kick off goroutines to do work.
+	n := len(batches)
+	if n > 10 {
+		n = 10
+	}
+	for i := 0; i < n; i++ {
+		go func() {
+			for i := range work {
+				args := []string{"-l"}
+				if simplify {
+					args = append(args, "-s")
+				}
+				args = append(args, batches[i]...)
+				if *verbose > 1 {
+					// os.Stderr, not stderr(), because the latter is not safe for
+					// use from multiple goroutines.
+					fmt.Fprintln(os.Stderr, commandString("gofmt", args))
+				}
+				cmd := exec.Command("gofmt", args...)
+				r := &results[i]
+				cmd.Stdout = &r.stdout
+				cmd.Stderr = &r.stderr
+				r.err = cmd.Run()
+				done <- true
+			}
+		}()
+	}
+
+	// Wait for goroutines to finish.
+	for range batches {
+		<-done
+	}
+
+	var stdout, stderr bytes.Buffer
+	for i := range results {
+		r := &results[i]
+		stdout.Write(r.stdout.Bytes())
+		stderr.Write(r.stderr.Bytes())
+		if err == nil {
+			err = r.err
+		}
+	}
+	return stdout.String(), stderr.String(), err
+}
+
+// gofmtWriteFile reformats file in place, as gofmt -w would,
+// but keeps the file's mode and replaces it atomically,
+// by writing a temporary file in the same directory and renaming it,
+// so that an interrupted write cannot leave a truncated file behind.
+func gofmtWriteFile(file string, simplify bool) error {
+	fi, err := os.Lstat(file)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("cannot reformat %s: not a regular file", file)
+	}
+	args := []string{file}
+	if simplify {
+		args = []string{"-s", file}
+	}
+	if *verbose > 1 {
+		fmt.Fprintln(stderr(), commandString("gofmt", args))
+	}
+	var out, errOut bytes.Buffer
+	cmd := exec.Command("gofmt", args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("reformatting %s: %v\n%s", file, err, errOut.Bytes())
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".gofmt")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(out.Bytes())
+	if err1 := tmp.Chmod(fi.Mode().Perm()); err == nil {
+		err = err1
+	}
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("reformatting %s: %v", file, err)
+	}
+	return nil
+}
+
+// indexFileModes returns the modes of the index entries for files,
+// which are absolute paths in the repository with root repo,
+// as a map from file name to octal mode string, such as "100644".
+func indexFileModes(repo string, files []string) map[string]string {
+	modes := make(map[string]string)
+	if len(files) == 0 {
+		return modes
+	}
+	args := []string{"ls-files", "-s", "-z", "--"}
+	for _, file := range files {
+		args = append(args, strings.TrimPrefix(file, repo))
+	}
+	for _, entry := range strings.Split(cmdOutputDir(repo, "git", args...), "\x00") {
+		// mode SP hash SP stage TAB name
+		info, name, ok := strings.Cut(entry, "\t")
+		if !ok {
+			continue
+		}
+		modes[filepath.Join(repo, name)] = strings.Fields(info)[0]
+	}
+	return modes
 }
 
 // gofmtRequired reports whether the specified file should be checked
 // for gofmt'dness by the pre-commit hook.
 // The file name is relative to the repo root.
 func gofmtRequired(file string) bool {
-	// TODO: Consider putting this policy into codereview.cfg.
 	if !strings.HasSuffix(file, ".go") {
 		return false
 	}
+	if gofmtExcluded(file) {
+		return false
+	}
 	if strings.HasPrefix(file, "vendor/") || strings.Contains(file, "/vendor/") {
 		return false
 	}
@@ -358,6 +666,12 @@ func gofmtRequired(file string) bool {
 	return strings.HasPrefix(file, "test/bench/") || file == "test/run.go"
 }
 
+// gofmtExcluded reports whether file matches one of the glob patterns
+// listed in the codereview.cfg "gofmt-exclude" key.
+func gofmtExcluded(file string) bool {
+	return configMatch("gofmt-exclude", file)
+}
+
 // stringMap returns a map m such that m[s] == true if s was in the original list.
 func stringMap(list []string) map[string]bool {
 	m := map[string]bool{}
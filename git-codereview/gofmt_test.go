@@ -7,6 +7,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -47,11 +48,19 @@ func TestGofmt(t *testing.T) {
 	testMain(t, "gofmt", "-l")
 	testPrintedStdout(t, "bad.go\n", "!good.go", fromSlash("!test/bad"), fromSlash("test/bench/bad.go"), fromSlash("!vendor/bad.go"))
 
+	// -check lists the same files, exits nonzero, and changes nothing.
+	testMainDied(t, "gofmt", "-check")
+	testPrintedStdout(t, "bad.go\n", "!good.go", fromSlash("!test/bad"), fromSlash("test/bench/bad.go"), fromSlash("!vendor/bad.go"))
+	testMainDied(t, "gofmt", "-check")
+	testPrintedStdout(t, "bad.go\n")
+
 	testMain(t, "gofmt")
 	testNoStdout(t)
 
 	testMain(t, "gofmt", "-l")
 	testNoStdout(t)
+	testMain(t, "gofmt", "-check")
+	testNoStdout(t)
 
 	write(t, gt.client+"/bad.go", badGo, 0644)
 	write(t, gt.client+"/broken.go", brokenGo, 0644)
@@ -59,6 +68,39 @@ func TestGofmt(t *testing.T) {
 	testMainDied(t, "gofmt", "-l")
 	testPrintedStdout(t, "bad.go")
 	testPrintedStderr(t, "gofmt reported errors", "broken.go")
+	testMainDied(t, "gofmt", "-check")
+	testPrintedStdout(t, "bad.go")
+	testPrintedStderr(t, "gofmt reported errors", "broken.go")
+}
+
+func TestGofmtResults(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+
+	write(t, gt.client+"/bad.go", badGo, 0644)
+	write(t, gt.client+"/good.go", goodGo, 0644)
+	write(t, gt.client+"/broken.go", brokenGo, 0644)
+	trun(t, gt.client, "git", "add", ".")
+	write(t, gt.client+"/good.go", bad2Go, 0644)
+
+	results := runGofmt(gofmtCommand, "", nil)
+	var got []string
+	for _, r := range results {
+		got = append(got, fmt.Sprintf("%s needsFormat=%v err=%v", r.name(), r.needsFormat, r.err != nil))
+	}
+	want := []string{
+		"bad.go needsFormat=true err=false",
+		"good.go (unstaged) needsFormat=true err=false",
+		"broken.go needsFormat=false err=true",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("runGofmt results:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if !strings.HasPrefix(results[2].err.Error(), "broken.go:") {
+		t.Errorf("broken.go error = %q, want prefix %q", results[2].err, "broken.go:")
+	}
 }
 
 func TestGofmtSubdir(t *testing.T) {
@@ -90,6 +132,21 @@ func TestGofmtSubdir(t *testing.T) {
 	chdir(t, gt.client+"/z")
 	testMain(t, "gofmt", "-l")
 	testPrintedStdout(t, fromSlash("longnamedir2/bad2.go"), fromSlash("dir1/bad1.go"))
+
+	// A path argument limits the files considered,
+	// relative to the current directory.
+	chdir(t, gt.client+"/dir1")
+	testMain(t, "gofmt", "-l", ".")
+	testPrintedStdout(t, "bad1.go", fromSlash("!longnamedir2/bad2.go"))
+
+	chdir(t, gt.client)
+	testMain(t, "gofmt", "longnamedir2")
+	testNoStdout(t)
+	testMain(t, "gofmt", "-l")
+	testPrintedStdout(t, fromSlash("dir1/bad1.go"), fromSlash("!longnamedir2/bad2.go"))
+
+	testMainDied(t, "gofmt", "dir1", "-l")
+	testPrintedStderr(t, "Usage")
 }
 
 func TestGofmtSubdirIndexCheckout(t *testing.T) {
@@ -288,3 +345,208 @@ func TestGofmtFastForwardMerge(t *testing.T) {
 	testNoStdout(t)
 	testNoStderr(t)
 }
+
+func TestGofmtManyFiles(t *testing.T) {
+	// Check that batching files across parallel gofmt invocations
+	// finds all the files and reports them in a deterministic order.
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+
+	defer func(n int) { gofmtBatchSize = n }(gofmtBatchSize)
+	gofmtBatchSize = 7
+
+	var want []string
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("f%03d.go", i)
+		if i%3 == 0 {
+			write(t, gt.client+"/"+name, badGo, 0644)
+			want = append(want, name)
+		} else {
+			write(t, gt.client+"/"+name, goodGo, 0644)
+		}
+	}
+	write(t, gt.client+"/broken.go", brokenGo, 0644)
+	trun(t, gt.client, "git", "add", ".")
+
+	for i := 0; i < 3; i++ {
+		testMainDied(t, "gofmt", "-l")
+		if got := testStdout.String(); got != strings.Join(want, "\n")+"\n" {
+			t.Fatalf("gofmt -l printed:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+		}
+		testPrintedStderr(t, "gofmt reported errors", "broken.go")
+	}
+
+	remove(t, gt.client+"/broken.go")
+	trun(t, gt.client, "git", "add", ".")
+	testMain(t, "gofmt")
+	testMain(t, "gofmt", "-l")
+	testNoStdout(t)
+}
+
+func TestGofmtExclude(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+
+	if err := os.MkdirAll(gt.client+"/gen", 0755); err != nil {
+		t.Fatal(err)
+	}
+	write(t, gt.client+"/codereview.cfg", "gofmt-exclude: *.pb.go, gen/*.go\n", 0644)
+	write(t, gt.client+"/bad.go", badGo, 0644)
+	write(t, gt.client+"/bad.pb.go", badGo, 0644)
+	write(t, gt.client+"/gen/bad.go", badGo, 0644)
+	trun(t, gt.client, "git", "add", ".")
+
+	testMain(t, "gofmt", "-l")
+	testPrintedStdout(t, "bad.go\n", "!bad.pb.go", fromSlash("!gen/bad.go"))
+
+	testMainDied(t, "hook-invoke", "pre-commit")
+	testPrintedStderr(t, "gofmt needs to format these files", "bad.go", "!bad.pb.go", fromSlash("!gen/bad.go"))
+
+	testMain(t, "gofmt")
+	testNoStdout(t)
+	for _, file := range []string{"bad.pb.go", "gen/bad.go"} {
+		if data, err := os.ReadFile(gt.client + "/" + file); err != nil || string(data) != badGo {
+			t.Errorf("%s was modified by gofmt, but it is excluded", file)
+		}
+	}
+	testMain(t, "hook-invoke", "pre-commit")
+
+	write(t, gt.client+"/codereview.cfg", "gofmt-exclude: [\n", 0644)
+	cachedConfig = nil
+	testMainDied(t, "gofmt", "-l")
+	testPrintedStderr(t, `invalid gofmt-exclude pattern "["`)
+}
+
+func TestGofmtSince(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	write(t, gt.client+"/bad1.go", badGo, 0644)
+	trun(t, gt.client, "git", "add", "bad1.go")
+	trun(t, gt.client, "git", "commit", "-m", "bad1")
+	write(t, gt.client+"/bad2.go", bad2Go, 0644)
+	trun(t, gt.client, "git", "add", "bad2.go")
+	trun(t, gt.client, "git", "commit", "-m", "bad2")
+
+	testMain(t, "gofmt", "-l")
+	testPrintedStdout(t, "bad1.go\n", "bad2.go\n")
+
+	testMain(t, "gofmt", "-l", "-since", "HEAD~1")
+	testPrintedStdout(t, "!bad1.go", "bad2.go\n")
+
+	testMain(t, "gofmt", "-since", "HEAD~1")
+	if data := read(t, gt.client+"/bad1.go"); string(data) != badGo {
+		t.Errorf("bad1.go was modified by gofmt -since HEAD~1")
+	}
+	if data := read(t, gt.client+"/bad2.go"); string(data) != bad2GoFixed {
+		t.Errorf("bad2.go = %q, want %q", data, bad2GoFixed)
+	}
+
+	testMainDied(t, "gofmt", "-since", "nonexistent")
+	testPrintedStderr(t, "cannot gofmt -since: unknown revision nonexistent")
+}
+
+func TestGofmtSimplify(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+
+	// simpleGo is formatted but not simplified: gofmt -s removes the [2]int.
+	const (
+		simpleGo      = "package simple\n\nvar x = [][2]int{[2]int{1, 2}}\n"
+		simpleGoFixed = "package simple\n\nvar x = [][2]int{{1, 2}}\n"
+	)
+	write(t, gt.client+"/simple.go", simpleGo, 0644)
+	trun(t, gt.client, "git", "add", "simple.go")
+
+	testMain(t, "gofmt", "-l")
+	testNoStdout(t)
+	testMain(t, "hook-invoke", "pre-commit")
+
+	testMain(t, "gofmt", "-l", "-simplify")
+	testPrintedStdout(t, "simple.go\n")
+	testMainDied(t, "gofmt", "-check", "-simplify")
+	testPrintedStdout(t, "simple.go\n")
+
+	write(t, gt.client+"/codereview.cfg", "gofmt-simplify: true\n", 0644)
+	testMain(t, "gofmt", "-l")
+	testPrintedStdout(t, "simple.go\n")
+	testMainDied(t, "hook-invoke", "pre-commit")
+	testPrintedStderr(t, "gofmt needs to format these files", "simple.go")
+
+	testMain(t, "gofmt")
+	if data := read(t, gt.client+"/simple.go"); string(data) != simpleGoFixed {
+		t.Errorf("simple.go = %q, want %q", data, simpleGoFixed)
+	}
+	testMain(t, "hook-invoke", "pre-commit")
+}
+
+func TestGofmtModeAndSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes and symbolic links behave differently on Windows")
+	}
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+
+	check := func(file, data string, mode os.FileMode, indexMode string) {
+		t.Helper()
+		fi, err := os.Lstat(gt.client + "/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode() != mode {
+			t.Errorf("%s: mode %v, want %v", file, fi.Mode(), mode)
+		}
+		if got := string(read(t, gt.client+"/"+file)); got != data {
+			t.Errorf("%s = %q, want %q", file, got, data)
+		}
+		if got := strings.Fields(trun(t, gt.client, "git", "ls-files", "-s", file))[0]; got != indexMode {
+			t.Errorf("%s: index mode %s, want %s", file, got, indexMode)
+		}
+	}
+
+	// An executable file and a read-only file keep their modes.
+	write(t, gt.client+"/exec.go", badGo, 0755)
+	write(t, gt.client+"/ro.go", bad2Go, 0444)
+	os.Chmod(gt.client+"/exec.go", 0755)
+	os.Chmod(gt.client+"/ro.go", 0444)
+	trun(t, gt.client, "git", "add", "exec.go", "ro.go")
+
+	// A staged executable file with unstaged changes keeps its index mode.
+	write(t, gt.client+"/staged.go", badGo, 0755)
+	os.Chmod(gt.client+"/staged.go", 0755)
+	trun(t, gt.client, "git", "add", "staged.go")
+	write(t, gt.client+"/staged.go", bad2Go, 0755)
+
+	// A symbolic link is skipped, leaving its target alone.
+	target := t.TempDir() + "/target.go"
+	write(t, target, badGo, 0644)
+	if err := os.Symlink(target, gt.client+"/link.go"); err != nil {
+		t.Fatal(err)
+	}
+	trun(t, gt.client, "git", "add", "link.go")
+
+	testMain(t, "gofmt")
+	testPrintedStderr(t, "warning: gofmt: skipping symbolic link link.go")
+
+	check("exec.go", badGoFixed, 0755, "100755")
+	check("ro.go", bad2GoFixed, 0444, "100644")
+	check("staged.go", bad2GoFixed, 0755, "100755")
+	if got := trun(t, gt.client, "git", "show", ":staged.go"); got != badGoFixed {
+		t.Errorf("staged.go in index = %q, want %q", got, badGoFixed)
+	}
+	if got := string(read(t, target)); got != badGo {
+		t.Errorf("symlink target = %q, want %q", got, badGo)
+	}
+	if fi, err := os.Lstat(gt.client + "/link.go"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link.go is no longer a symbolic link: %v", err)
+	}
+}
@@ -20,13 +20,34 @@ var hookFiles = []string{
 	"pre-commit",
 }
 
+// autoHooksKey is the git config key that 'hooks -uninstall' sets to false
+// to stop other git-codereview commands from reinstalling the hooks.
+const autoHooksKey = "codereview.autohooks"
+
 // installHook installs Git hooks to enforce code review conventions.
 //
 // auto is whether hooks are being installed automatically as part of
 // running another git-codereview command, rather than an explicit
 // invocation of the 'hooks' command itself.
 func installHook(args []string, auto bool) {
+	var uninstall bool
+	if !auto {
+		flags.BoolVar(&uninstall, "uninstall", false, "remove the installed hooks")
+	}
 	flags.Parse(args)
+	if uninstall {
+		uninstallHooks()
+		return
+	}
+	autoHooks, _ := trimErr(cmdOutputErr("git", "config", "--bool", "--get", autoHooksKey))
+	if auto && autoHooks == "false" {
+		verbosef("not installing hooks: %s is false", autoHooksKey)
+		return
+	}
+	if !auto && autoHooks != "" {
+		// An explicit install undoes an earlier uninstall.
+		run("git", "config", "--unset", autoHooksKey)
+	}
 	hooksDir := gitPath("hooks")
 	var existingHooks []string
 	for _, hookFile := range hookFiles {
@@ -95,6 +116,40 @@ func installHook(args []string, auto bool) {
 	}
 }
 
+// uninstallHooks removes the Git hooks installed by installHook.
+// It only removes hook files whose content is exactly what installHook
+// writes, so that hooks the user has customized are left in place.
+// It also sets autoHooksKey to false, so that other commands do not
+// install the hooks again, until an explicit 'hooks' command does.
+func uninstallHooks() {
+	run("git", "config", autoHooksKey, "false")
+
+	hooksDir := gitPath("hooks")
+	removed := 0
+	for _, hookFile := range hookFiles {
+		filename := filepath.Join(hooksDir, hookFile)
+		data, err := os.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			dief("reading hook: %v", err)
+		}
+		if string(data) != fmt.Sprintf(hookScript, hookFile) {
+			printf("leaving %s in place: not installed by %s", filename, progName)
+			continue
+		}
+		if err := os.Remove(filename); err != nil {
+			dief("removing hook: %v", err)
+		}
+		printf("removed %s hook", hookFile)
+		removed++
+	}
+	if removed == 0 {
+		printf("no hooks to remove")
+	}
+}
+
 // repoRoot returns the root of the currently selected git repo, or
 // worktree root if this is an alternate worktree of a repo.
 func repoRoot() string {
@@ -228,6 +283,11 @@ func fixCommitMessage(msg []byte) []byte {
 	oldFixesRE := regexp.MustCompile(fmt.Sprintf(oldFixesRETemplate, regexp.QuoteMeta(issueRepo)))
 	data = oldFixesRE.ReplaceAll(data, []byte("Fixes "+issueRepo+"#${issueNum}"))
 
+	// Merge identical trailers accumulated by repeated amends, if requested.
+	if config()["dedup-trailers"] == "true" {
+		data = dedupTrailers(data)
+	}
+
 	if haveGerrit() {
 		// Complain if two Change-Ids are present.
 		// This can happen during an interactive rebase;
@@ -281,6 +341,35 @@ func endsWithMetadataLine(msg []byte) bool {
 	return i >= 0 && metadataLineRE.Match(msg[i+1:])
 }
 
+// dedupTrailers returns msg with repeated identical metadata lines
+// removed from its final trailer paragraph, keeping the first of each.
+// Lines in the trailer paragraph that differ, even only in value,
+// are left alone, as are lines elsewhere in the message.
+func dedupTrailers(msg []byte) []byte {
+	data := bytes.TrimRight(msg, "\n")
+	if !endsWithMetadataLine(data) {
+		return msg
+	}
+	i := bytes.LastIndex(data, []byte("\n\n"))
+	if i < 0 {
+		return msg
+	}
+	body, block := data[:i+2], data[i+2:]
+	var out []byte
+	seen := make(map[string]bool)
+	for _, line := range lines(string(block)) {
+		if metadataLineRE.MatchString(line) {
+			key := strings.TrimSpace(line)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		out = append(out, line+"\n"...)
+	}
+	return append(append([]byte{}, body...), out...)
+}
+
 var (
 	fixupBang  = []byte("fixup!")
 	squashBang = []byte("squash!")
@@ -333,22 +422,30 @@ func hookGofmt() {
 		return
 	}
 
-	files, stderr := runGofmt(gofmtPreCommit)
-
-	if stderr != "" {
-		msgf := printf
-		if len(files) == 0 {
-			msgf = dief
-		}
-		msgf("gofmt reported errors:\n\t%s", strings.Replace(strings.TrimSpace(stderr), "\n", "\n\t", -1))
+	if err := gofmtCheck(); err != nil {
+		dief("%v", err)
 	}
+}
 
-	if len(files) == 0 {
-		return
-	}
+// gofmtCheck runs gofmt over the files modified in the index
+// since the branchpoint and returns an error describing
+// any files that need formatting or that gofmt could not parse.
+func gofmtCheck() error {
+	results := runGofmt(gofmtPreCommit, "", nil)
+	files := gofmtNames(results)
 
-	dief("gofmt needs to format these files (run 'git gofmt'):\n\t%s",
-		strings.Join(files, "\n\t"))
+	var msgs []string
+	if errs := gofmtErrors(results); errs != "" {
+		msgs = append(msgs, fmt.Sprintf("gofmt reported errors:\n\t%s", strings.Replace(errs, "\n", "\n\t", -1)))
+	}
+	if len(files) > 0 {
+		msgs = append(msgs, fmt.Sprintf("gofmt needs to format these files (run 'git gofmt'):\n\t%s",
+			strings.Join(files, "\n\t")))
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "\n"))
 }
 
 // This is NOT USED ANYMORE.
@@ -148,6 +148,44 @@ func TestHookCommitMsgIssueRepoRewrite(t *testing.T) {
 	cachedConfig = nil
 }
 
+func TestHookCommitMsgDedupTrailers(t *testing.T) {
+	gt := newGitTest(t)
+	gt.enableGerrit(t)
+	defer gt.done()
+
+	const dup = "math/big: catch all the rats\n\nBug: 1\nBug: 1\n\nBug: 1\nFixes: 2\nBug: 1\nChange-Id: I123456789\nFixes: 2\nBug: 3\nChange-Id: I123456789\n"
+
+	// Without config, duplicates are left alone (and the two Change-Ids rejected).
+	write(t, gt.client+"/msg.txt", dup, 0644)
+	testMainDied(t, "hook-invoke", "commit-msg", gt.client+"/msg.txt")
+	testPrintedStderr(t, "multiple Change-Id lines")
+
+	write(t, gt.client+"/codereview.cfg", "gerrit: myserver\ndedup-trailers: true\n", 0644)
+	cachedConfig = nil
+	defer func() { cachedConfig = nil }()
+
+	msgs := []struct{ in, want string }{
+		{dup, "math/big: catch all the rats\n\nBug: 1\nBug: 1\n\nBug: 1\nFixes: 2\nChange-Id: I123456789\nBug: 3\n"},
+		// Change-Id is never dropped, and a new one is still added when missing.
+		{"x: y\n\nBug: 1\nBug: 1\n", "x: y\n\nBug: 1\nChange-Id: I"},
+		// Different Change-Ids are still an error.
+		{"x: y\n\nChange-Id: I123456789\nChange-Id: I223456789\n", ""},
+	}
+	for _, tt := range msgs {
+		write(t, gt.client+"/msg.txt", tt.in, 0644)
+		if tt.want == "" {
+			testMainDied(t, "hook-invoke", "commit-msg", gt.client+"/msg.txt")
+			testPrintedStderr(t, "multiple Change-Id lines")
+			continue
+		}
+		testMain(t, "hook-invoke", "commit-msg", gt.client+"/msg.txt")
+		got := string(read(t, gt.client+"/msg.txt"))
+		if !strings.HasPrefix(got, tt.want) || strings.Count(got, "Change-Id:") != 1 {
+			t.Errorf("dedup of %q:\ngot:\n%s\nwant:\n%s", tt.in, got, tt.want)
+		}
+	}
+}
+
 func TestHookCommitMsgBranchPrefix(t *testing.T) {
 	testHookCommitMsgBranchPrefix(t, false)
 	testHookCommitMsgBranchPrefix(t, true)
@@ -544,3 +582,43 @@ func TestHookCommitMsgFromGit(t *testing.T) {
 		t.Fatalf("did not find Change-Id in git log output:\n%s", log)
 	}
 }
+
+func TestHooksUninstall(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.removeStubHooks()
+	testMain(t, "hooks") // install hooks
+
+	const customHook = "#!/bin/sh\necho custom\n"
+	write(t, gt.client+"/.git/hooks/pre-commit", customHook, 0755)
+
+	testMain(t, "hooks", "-uninstall")
+	testPrintedStderr(t, "removed commit-msg hook", "pre-commit in place: not installed by git-codereview")
+	if _, err := os.Stat(gt.client + "/.git/hooks/commit-msg"); !os.IsNotExist(err) {
+		t.Errorf("commit-msg hook not removed: %v", err)
+	}
+	if data := read(t, gt.client+"/.git/hooks/pre-commit"); string(data) != customHook {
+		t.Errorf("custom pre-commit hook was modified")
+	}
+
+	testMain(t, "hooks", "-uninstall")
+	testPrintedStderr(t, "no hooks to remove")
+
+	// Other commands do not reinstall the hooks...
+	gt.enableGerrit(t)
+	testMain(t, "pending", "-l")
+	if _, err := os.Stat(gt.client + "/.git/hooks/commit-msg"); !os.IsNotExist(err) {
+		t.Errorf("commit-msg hook reinstalled after uninstall: %v", err)
+	}
+
+	// ... until an explicit hooks command does.
+	remove(t, gt.client+"/.git/hooks/pre-commit")
+	testMain(t, "hooks")
+	if _, err := os.Stat(gt.client + "/.git/hooks/commit-msg"); err != nil {
+		t.Errorf("commit-msg hook not reinstalled: %v", err)
+	}
+	if out, err := cmdOutputErr("git", "config", "--get", "codereview.autohooks"); err == nil {
+		t.Errorf("codereview.autohooks still set after hooks: %s", out)
+	}
+}
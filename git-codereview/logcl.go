@@ -0,0 +1,73 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+func cmdLogCL(args []string) {
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s log-cl %s <number>\n", progName, globalFlags)
+		exit(2)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) != 1 {
+		flags.Usage()
+		exit(2)
+	}
+	cl, ps, ok := parseCL(flags.Arg(0))
+	if !ok || ps != "" {
+		flags.Usage()
+		exit(2)
+	}
+	if !haveGerrit() {
+		dief("cannot show CL history without gerrit")
+	}
+
+	g, err := readGerritChange(cl + "?o=MESSAGES")
+	if err != nil {
+		dief("cannot read CL %s: %v", cl, err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "CL %d: %s", g.Number, g.Subject)
+	if g.Status != "" {
+		fmt.Fprintf(&buf, " (%s)", strings.ToLower(g.Status))
+	}
+	fmt.Fprintf(&buf, "\n")
+	for _, m := range g.Messages {
+		formatGerritMessage(&buf, m)
+	}
+	stdout().Write(buf.Bytes())
+}
+
+// formatGerritMessage writes m to buf as a header line giving the time,
+// the author, and the patch set, followed by the indented message text.
+func formatGerritMessage(buf *bytes.Buffer, m *GerritMessage) {
+	// Gerrit timestamps are UTC, with nanoseconds: "2006-01-02 15:04:05.000000000".
+	date := m.Date
+	if i := strings.Index(date, "."); i >= 0 {
+		date = date[:i]
+	}
+	author := m.Author.Name
+	if author == "" {
+		author = "Gerrit"
+	}
+	fmt.Fprintf(buf, "\n%s UTC %s", date, author)
+	if m.RevisionNumber > 0 {
+		fmt.Fprintf(buf, " (patch set %d)", m.RevisionNumber)
+	}
+	fmt.Fprintf(buf, ":\n")
+	for _, line := range lines(strings.TrimRight(m.Message, "\n")) {
+		if line == "" {
+			fmt.Fprintf(buf, "\n")
+		} else {
+			fmt.Fprintf(buf, "\t%s\n", line)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestLogCL(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	testMainDied(t, "log-cl", "work")
+	testPrintedStderr(t, "Usage")
+
+	testMainDied(t, "log-cl", "1234")
+	testPrintedStderr(t, "cannot show CL history without gerrit")
+
+	// Test address is injected by newGerritServer.
+	write(t, gt.client+"/codereview.cfg", "gerrit: on", 0644)
+
+	testMainDied(t, "log-cl", "1234")
+	testPrintedStderr(t, "cannot read CL 1234")
+
+	srv.setReply("/a/changes/1234?o=MESSAGES", gerritReply{body: ")]}'\n" + `{
+		"_number": 1234,
+		"subject": "dir: fix bug",
+		"status": "MERGED",
+		"messages": [
+			{"author": {"name": "Gopher"}, "date": "2026-01-02 15:04:05.000000000", "_revision_number": 1, "message": "Uploaded patch set 1."},
+			{"author": {"name": "Reviewer"}, "date": "2026-01-03 09:00:00.000000000", "_revision_number": 1, "message": "Patch Set 1: Code-Review+2\n\n(1 comment)"},
+			{"date": "2026-01-03 10:00:00.000000000", "_revision_number": 2, "message": "Change has been successfully merged"}
+		]
+	}`})
+	testMain(t, "log-cl", "1234")
+	testPrintedStdout(t, `CL 1234: dir: fix bug (merged)
+
+2026-01-02 15:04:05 UTC Gopher (patch set 1):
+	Uploaded patch set 1.
+
+2026-01-03 09:00:00 UTC Reviewer (patch set 1):
+	Patch Set 1: Code-Review+2
+
+	(1 comment)
+
+2026-01-03 10:00:00 UTC Gerrit (patch set 2):
+	Change has been successfully merged
+`)
+}
@@ -5,10 +5,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -20,66 +27,105 @@ func cmdMail(args []string) {
 		rList  = new(stringList) // installed below
 		ccList = new(stringList) // installed below
 
+		auto        = flags.Bool("auto", false, "mail all pending commits, one CL each, and summarize the uploaded CLs")
 		diff        = flags.Bool("diff", false, "show change commit diff and don't upload or mail")
-		force       = flags.Bool("f", false, "mail even if there are staged changes")
+		force       = flags.Bool("f", false, "mail even if there are staged changes or binary files, without confirmation")
 		hashtagList = new(stringList) // installed below
+		labelList   = new(stringList) // installed below
 		noKeyCheck  = flags.Bool("nokeycheck", false, "set 'git push -o nokeycheck', to prevent Gerrit from checking for private keys")
+		target      = flags.String("target", "", "upload to `branch` instead of the upstream branch")
 		topic       = flags.String("topic", "", "set Gerrit topic")
 		trybot      = flags.Bool("trybot", false, "run trybots on the uploaded CLs")
 		wip         = flags.Bool("wip", false, "set the status of a change to Work-in-Progress")
+		private     = flags.Bool("private", false, "mark the uploaded changes private, visible only to the owner and reviewers")
+		notPrivate  = flags.Bool("remove-private", false, "clear the private flag on the uploaded changes")
 		noverify    = flags.Bool("no-verify", false, "disable presubmits")
 		autoSubmit  = flags.Bool("autosubmit", false, "set autosubmit on the uploaded CLs")
+		idFrom      = flags.String("change-id-from", "", "copy the Change-Id from `rev` into a commit that has none")
+		clNumber    = flags.String("cl", "", "set the commit's Change-Id to that of CL `number`, to update that CL")
+		dependsOn   = flags.String("depends-on", "", "upload based on the current revision of CL `number`, remembering it for later mails (none to forget)")
+		ccReviewers = flags.Bool("cc-from-reviewed-by", false, "CC the earlier reviewers who have been removed from the change")
+		noWatchers  = flags.Bool("no-cc-watchers", false, "do not CC the addresses listed by the cc-watchers config key")
+		noRenotify  = flags.Bool("no-renotify", false, "do not add reviewers if the CL already exists on Gerrit")
+		nonPrint    = flags.Bool("allow-nonprintable", false, "warn about, instead of rejecting, non-printable characters in commit messages")
+		pick        = flags.Bool("pick-reviewers", false, "choose reviewers in an editor from the list of recent reviewers")
+		validate    = flags.Bool("validate-reviewers", false, "check that reviewer addresses are known to Gerrit")
+		verifyUp    = flags.Bool("verify-upload", false, "check afterward that Gerrit recorded the uploaded commit unchanged")
+		squash      = flags.Bool("squash", false, "mail all pending commits up to the named one as a single CL")
+		strict      = flags.Bool("strict", false, "refuse to mail commits whose subjects contain work-in-progress markers")
+		resolve     = flags.Bool("resolve", false, "mark all unresolved comments on the CL as resolved")
+		unresolve   = flags.Bool("unresolved", false, "mark all resolved comments on the CL as unresolved")
 	)
 	flags.Var(rList, "r", "comma-separated list of reviewers")
 	flags.Var(ccList, "cc", "comma-separated list of people to CC:")
 	flags.Var(hashtagList, "hashtag", "comma-separated list of tags to set")
+	flags.Var(labelList, "label", "comma-separated list of label votes (like Hold=1) to set")
 
 	flags.Usage = func() {
 		fmt.Fprintf(stderr(),
 			"Usage: %s mail %s [-r reviewer,...] [-cc mail,...]\n"+
-				"\t[-autosubmit] [-f] [-diff] [-hashtag tag,...]\n"+
-				"\t[-nokeycheck] [-topic topic] [-trybot] [-wip]\n"+
+				"\t[-allow-nonprintable] [-auto] [-autosubmit] [-cc-from-reviewed-by] [-change-id-from rev | -cl number]\n"+
+				"\t[-depends-on number] [-f] [-diff] [-hashtag tag,...] [-label label=n,...]\n"+
+				"\t[-no-cc-watchers] [-no-renotify] [-nokeycheck] [-pick-reviewers]\n"+
+				"\t[-private | -remove-private] [-resolve | -unresolved]\n"+
+				"\t[-squash] [-strict] [-target branch] [-topic topic] [-trybot]\n"+
+				"\t[-validate-reviewers] [-verify-upload] [-wip]\n"+
 				"\t[commit]\n", progName, globalFlags)
 		exit(2)
 	}
 	flags.Parse(args)
-	if len(flags.Args()) > 1 {
+	if len(flags.Args()) > 1 || *resolve && *unresolve || *idFrom != "" && *clNumber != "" ||
+		*auto && (len(flags.Args()) > 0 || *squash) || *private && *notPrivate {
 		flags.Usage()
 		exit(2)
 	}
 
-	var trybotVotes []string
-	switch os.Getenv("GIT_CODEREVIEW_TRYBOT") {
-	case "", "luci":
-		trybotVotes = []string{"Commit-Queue+1"}
-	case "farmer":
-		trybotVotes = []string{"Run-TryBot"}
-	case "both":
-		trybotVotes = []string{"Commit-Queue+1", "Run-TryBot"}
-	default:
-		fmt.Fprintf(stderr(), "GIT_CODEREVIEW_TRYBOT must be unset, blank, or one of 'luci', 'farmer', or 'both'\n")
-		exit(2)
-	}
+	votes := trybotVotes()
+	labels := mailLabels(string(*labelList))
 
 	b := CurrentBranch()
 
 	var c *Commit
 	if len(flags.Args()) == 1 {
 		c = b.CommitByRev("mail", flags.Arg(0))
+	} else if *auto {
+		c = b.CommitByRev("mail", "HEAD")
 	} else {
 		c = b.DefaultCommit("mail", "must specify commit on command line; use HEAD to mail all pending changes")
 	}
 
 	if *diff {
+		if b.Branchpoint() == "" {
+			run("git", "diff", emptyTree(), c.ShortHash, "--")
+			return
+		}
 		run("git", "diff", b.Branchpoint()[:7]+".."+c.ShortHash, "--")
 		return
 	}
 
+	// Look up the new Change-Id now, but amend c to use it only
+	// after the checks below, so that a failed check leaves c alone.
+	var newID, idSource string
+	if *idFrom != "" {
+		newID, idSource = copyChangeID(c, *idFrom), *idFrom
+	}
+	if *clNumber != "" {
+		newID, idSource = clChangeID(*clNumber), "CL "+*clNumber
+	}
+	// The dependency is recorded by Change-Id after the push,
+	// too late to stop for a missing one.
+	if *dependsOn != "" && c.ChangeID == "" && newID == "" {
+		dief("cannot mail: -depends-on needs a Change-Id in %s", c.ShortHash)
+	}
+
 	if len(ListFiles(c)) == 0 && len(c.Parents) == 1 {
 		dief("cannot mail: commit %s is empty", c.ShortHash)
 	}
 
+	markers := mailMarkers()
 	foundCommit := false
+	var binaries []string
+	var mailing []*Commit
 	for _, c1 := range b.Pending() {
 		if c1 == c {
 			foundCommit = true
@@ -87,21 +133,21 @@ func cmdMail(args []string) {
 		if !foundCommit {
 			continue
 		}
-		if strings.Contains(strings.ToLower(c1.Message), "do not mail") {
-			dief("%s: CL says DO NOT MAIL", c1.ShortHash)
+		mailing = append(mailing, c1)
+		if errs := mailCommitErrors(c1); len(errs) > 0 {
+			dief("%v", errs[0])
 		}
-		if strings.HasPrefix(c1.Message, "fixup!") {
-			dief("%s: CL is a fixup! commit", c1.ShortHash)
+		if m := findMarker(c1.Subject, markers); m != "" {
+			if *strict {
+				dief("%s: CL subject contains %q", c1.ShortHash, m)
+			}
+			printf("warning: %s: CL subject contains %q", c1.ShortHash, m)
 		}
-		if strings.HasPrefix(c1.Message, "squash!") {
-			dief("%s: CL is a squash! commit", c1.ShortHash)
+		for _, f := range binaryFiles(c1) {
+			binaries = append(binaries, c1.ShortHash+": "+f)
 		}
-
-		for _, f := range ListFiles(c1) {
-			if strings.HasPrefix(f, ".#") || strings.HasSuffix(f, "~") ||
-				(strings.HasPrefix(f, "#") && strings.HasSuffix(f, "#")) {
-				dief("cannot mail temporary files: %s", f)
-			}
+		if config()["warn-missing-tests"] == "true" && missingTests(c1) {
+			printf("warning: %s: CL changes Go code but no tests", c1.ShortHash)
 		}
 	}
 	if !foundCommit {
@@ -109,32 +155,115 @@ func cmdMail(args []string) {
 		dief("internal error: did not find chosen commit on current branch")
 	}
 
+	if len(binaries) > 0 {
+		if !*force {
+			dief("cannot mail: binary files in commits:\n\t%s\n"+
+				"Use '%s mail -f' to mail them anyway.", strings.Join(binaries, "\n\t"), progName)
+		}
+		printf("warning: mailing binary files:\n\t%s", strings.Join(binaries, "\n\t"))
+	}
+
+	if !*force && !*squash {
+		confirmMailCount(mailing)
+	}
+
 	if !*force && HasStagedChanges() {
 		dief("there are staged changes; aborting.\n"+
 			"Use '%s change' to include them or '%s mail -f' to force it.", progName, progName)
 	}
 
-	if !utf8.ValidString(c.Message) {
-		dief("cannot mail message with invalid UTF-8")
-	}
-	for _, r := range c.Message {
-		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
-			dief("cannot mail message with non-printable rune %q", r)
+	if *nonPrint && utf8.ValidString(c.Message) {
+		if r, ok := nonPrintableRune(c.Message); ok {
+			printf("warning: mailing message with non-printable rune %q", r)
 		}
+	} else if err := mailMessageError(c.Message); err != nil {
+		dief("%v", err)
+	}
+
+	if newID != "" {
+		c = setChangeID(b, c, newID, idSource)
+		mailing[0] = c
 	}
 
 	// for side effect of dying with a good message if origin is GitHub
 	loadGerritOrigin()
+	checkPushURL()
 
 	refSpec := b.PushSpec(c)
+	mailed := c.ShortHash
+	pushed := c.Hash
+	if *squash {
+		if h := squashCommits(b, c); h != "" {
+			refSpec = h + refSpec[strings.Index(refSpec, ":"):]
+			mailed = h
+			pushed = h
+		}
+	}
+	// The Gerrit changes are on the target branch, if any,
+	// not the branch's upstream that fullChangeID uses.
+	gerritBranch := strings.TrimPrefix(b.OriginBranch(), "origin/")
+	if *target != "" {
+		gerritBranch = checkTargetBranch(*target)
+		refSpec = pushSpecTarget(refSpec, gerritBranch)
+	}
+	changeID := auth.project + "~" + gerritBranch + "~" + c.ChangeID
+	if *ccReviewers {
+		for _, addr := range priorReviewers(c, changeID, string(*rList)+","+string(*ccList)) {
+			ccList.Set(addr)
+		}
+	}
+	if *pick {
+		if picked := pickReviewers(); len(picked) > 0 {
+			rList.Set(strings.Join(picked, ","))
+		}
+	}
+	reupload := *noRenotify && changeExists(c, changeID)
+	if reupload && *rList != "" {
+		// Keep only the removals, which notify no one.
+		var keep, skipped []string
+		for _, addr := range strings.Split(string(*rList), ",") {
+			if strings.HasPrefix(addr, "-") {
+				keep = append(keep, addr)
+			} else if addr != "" {
+				skipped = append(skipped, addr)
+			}
+		}
+		if len(skipped) > 0 {
+			printf("CL already exists; not adding reviewers: %s", strings.Join(skipped, ", "))
+		}
+		*rList = stringList(strings.Join(keep, ","))
+	}
+	if name := config()["reviewers-file-default"]; name != "" && *rList == "" && !reupload {
+		if owners := fileOwners(c, name, c.AuthorEmail); len(owners) > 0 {
+			printf("adding reviewers from %s files: %s", name, strings.Join(owners, ", "))
+			rList.Set(strings.Join(owners, ","))
+		}
+	}
+	if watchers := config()["cc-watchers"]; watchers != "" && !*noWatchers {
+		ccList.Set(watchers)
+	}
 	start := "%"
+	var addrs, removed []string
 	if *rList != "" {
-		refSpec += mailList(start, "r", string(*rList))
-		start = ","
+		spec, list, rm := mailList(start, "r", string(*rList))
+		if spec != "" {
+			refSpec += spec
+			start = ","
+		}
+		addrs = append(addrs, list...)
+		removed = append(removed, rm...)
 	}
 	if *ccList != "" {
-		refSpec += mailList(start, "cc", string(*ccList))
-		start = ","
+		spec, list, rm := mailList(start, "cc", string(*ccList))
+		if spec != "" {
+			refSpec += spec
+			start = ","
+		}
+		addrs = append(addrs, list...)
+		removed = append(removed, rm...)
+	}
+	if *validate {
+		checkReviewers(addrs)
 	}
 	if *hashtagList != "" {
 		for _, tag := range strings.Split(string(*hashtagList), ",") {
@@ -146,17 +275,14 @@ func cmdMail(args []string) {
 		}
 	}
 	if *topic != "" {
-		// There's no way to escape the topic, but the only
-		// ambiguous character is ',' (though other characters
-		// like ' ' will be rejected outright by git).
-		if strings.Contains(*topic, ",") {
-			dief("topic may not contain a comma")
+		if err := topicError(*topic); err != nil {
+			dief("%v", err)
 		}
 		refSpec += start + "topic=" + *topic
 		start = ","
 	}
 	if *trybot {
-		for _, v := range trybotVotes {
+		for _, v := range votes {
 			refSpec += start + "l=" + v
 			start = ","
 		}
@@ -165,6 +291,22 @@ func cmdMail(args []string) {
 		refSpec += start + "wip"
 		start = ","
 	}
+	if *private {
+		refSpec += start + "private"
+		start = ","
+	}
+	if *notPrivate {
+		refSpec += start + "remove-private"
+		start = ","
+	}
+	if base := dependsOnBase(c, *dependsOn); base != "" {
+		refSpec += start + "base=" + base
+		start = ","
+	}
+	for _, l := range labels {
+		refSpec += start + "l=" + l
+		start = ","
+	}
 	if *autoSubmit {
 		refSpec += start + "l=Auto-Submit"
 	}
@@ -176,20 +318,298 @@ func cmdMail(args []string) {
 		args = append(args, "--no-verify")
 	}
 	args = append(args, "origin", refSpec)
-	run("git", args...)
+	gitPush(args)
 
 	// Create local tag for mailed change.
 	// If in the 'work' branch, this creates or updates work.mailed.
 	// Older mailings are in the reflog, so work.mailed is newest,
 	// work.mailed@{1} is the one before that, work.mailed@{2} before that,
-	// and so on.
+	// and so on. Tags have no reflog unless core.logAllRefUpdates is
+	// "always", so ask for one; pending's “local edits not mailed”
+	// check reads it. Git expires the old entries in the usual way
+	// and deletes the reflog along with the tag.
 	// Git doesn't actually have a concept of a local tag,
 	// but Gerrit won't let people push tags to it, so the tag
 	// can't propagate out of the local client into the official repo.
 	// There is no conflict with the branch names people are using
 	// for work, because git change rejects any name containing a dot.
 	// The space of names with dots is ours (the Go team's) to define.
-	run("git", "tag", "--no-sign", "-f", b.Name+".mailed", c.ShortHash)
+	run("git", "tag", "--no-sign", "--create-reflog", "-f", b.Name+".mailed", mailed)
+
+	// Remember the dependency so that later mails keep it.
+	switch *dependsOn {
+	case "":
+		// nothing
+	case "none":
+		if _, err := cmdOutputErr("git", "config", "--get", dependsOnKey(c)); err == nil {
+			run("git", "config", "--unset", dependsOnKey(c))
+		}
+	default:
+		run("git", "config", dependsOnKey(c), *dependsOn)
+	}
+
+	if (*verifyUp || config()["verify-upload"] == "true") && c.ChangeID != "" && !*noRun {
+		verifyUpload(changeID, pushed)
+	}
+
+	if len(removed) > 0 && !*noRun {
+		removeReviewers(changeID, removed)
+	}
+
+	if (*resolve || *unresolve) && !*noRun {
+		setCommentResolution(changeID, !*resolve)
+	}
+
+	if *auto && !*noRun {
+		printMailSummary(b, mailing, gerritBranch)
+	}
+}
+
+// verifyUpload checks that the Gerrit change with the given ID has
+// the commit hash that was just pushed as its current revision,
+// warning if not, as when the server rewrote the commit
+// or did not record the upload.
+func verifyUpload(changeID, hash string) {
+	g, err := readGerritChange(changeID + "?o=CURRENT_REVISION")
+	if err != nil {
+		printf("warning: cannot verify upload: %v", err)
+		return
+	}
+	if g.CurrentRevision != hash {
+		printf("warning: current revision of CL %d is %.7s, not the uploaded %.7s\n"+
+			"\tthe server may have rewritten or rejected the commit", g.Number, g.CurrentRevision, hash)
+		return
+	}
+	verbosef("verified CL %d is at %.7s", g.Number, hash)
+}
+
+// changeExists reports whether the change for c, with the given full ID,
+// has already been mailed, meaning that it exists on the Gerrit server.
+func changeExists(c *Commit, changeID string) bool {
+	if c.ChangeID == "" {
+		dief("cannot mail: missing Change-Id")
+	}
+	_, err := readGerritChange(changeID)
+	if err == nil {
+		return true
+	}
+	var gerr *gerritError
+	if errors.As(err, &gerr) && gerr.statusCode == http.StatusNotFound {
+		return false
+	}
+	dief("cannot mail: %v", err)
+	return false
+}
+
+// dependsOnKey returns the git config key recording
+// the CL that the change for c depends on.
+func dependsOnKey(c *Commit) string {
+	return "codereview." + c.ChangeID + ".dependson"
+}
+
+// dependsOnBase returns the current revision of the CL that c depends on,
+// for use as the base of c's upload, or "" if there is none.
+// The CL is the one numbered cl or, if cl is empty, the one recorded by
+// an earlier mail -depends-on of the same change. A cl of "none" means none.
+func dependsOnBase(c *Commit, cl string) string {
+	if cl == "none" {
+		return ""
+	}
+	if cl == "" {
+		cl, _ = trimErr(cmdOutputErr("git", "config", "--get", dependsOnKey(c)))
+		if cl == "" {
+			return ""
+		}
+		verbosef("using recorded dependency on CL %s", cl)
+	}
+	if _, err := strconv.Atoi(cl); err != nil {
+		dief("cannot mail: invalid CL number %q", cl)
+	}
+	g, err := readGerritChange(cl + "?o=CURRENT_REVISION")
+	if err != nil {
+		dief("cannot mail: reading CL %s: %v", cl, err)
+	}
+	if g.CurrentRevision == "" {
+		dief("cannot mail: CL %s has no current revision", cl)
+	}
+	return g.CurrentRevision
+}
+
+// printMailSummary prints to standard output a line for each commit in cs
+// giving the commit and the URL of the CL it was uploaded to on the
+// Gerrit branch named branch, as reported by Gerrit.
+func printMailSummary(b *Branch, cs []*Commit, branch string) {
+	var ids []string
+	for _, c := range cs {
+		ids = append(ids, auth.project+"~"+branch+"~"+c.ChangeID)
+	}
+	gs, err := b.GerritChanges(ids)
+	if len(gs) != len(cs) && err == nil {
+		err = fmt.Errorf("invalid response from Gerrit server - %d queries but %d results", len(ids), len(gs))
+	}
+	if err != nil {
+		printf("warning: cannot list mailed CLs: %v", err)
+		return
+	}
+	w := stdout()
+	for i, c := range cs {
+		if len(gs[i]) != 1 {
+			fmt.Fprintf(w, "%s %s\n\tnot found on Gerrit\n", c.ShortHash, c.Subject)
+			continue
+		}
+		fmt.Fprintf(w, "%s %s\n\t%s/%d\n", c.ShortHash, c.Subject, auth.url, gs[i][0].Number)
+	}
+}
+
+// gitPush runs git with the push arguments args, dying if it fails.
+// Gerrit reports problems that do not stop the upload, such as
+// an unknown reviewer, as warnings amid the other remote output,
+// where they are easy to miss, so gitPush repeats them afterward.
+func gitPush(args []string) {
+	var out bytes.Buffer
+	if err := runDirTeeErr(".", &out, "git", args...); err != nil {
+		if *verbose == 0 {
+			fmt.Fprintf(stderr(), "(running: %s)\n", commandString("git", args))
+		}
+		dief("%v", err)
+	}
+	if warnings := pushWarnings(out.String()); len(warnings) > 0 {
+		printf("warning: Gerrit reported:\n\t%s", strings.Join(warnings, "\n\t"))
+	}
+}
+
+// pushWarnings returns the warnings in the output of git push,
+// which are the remote output lines like "remote: warning: ...".
+func pushWarnings(out string) []string {
+	var warnings []string
+	for _, line := range lines(out) {
+		if !strings.HasPrefix(line, "remote:") {
+			continue
+		}
+		msg := strings.TrimSpace(strings.TrimPrefix(line, "remote:"))
+		if strings.HasPrefix(strings.ToLower(msg), "warning") {
+			warnings = append(warnings, msg)
+		}
+	}
+	return warnings
+}
+
+// setCommentResolution replies to the comment threads on the Gerrit change
+// with the given full ID, marking them all unresolved (if unresolved is true)
+// or resolved.
+// Threads already in the requested state are left alone.
+// Each reply is posted to the revision of the comment it replies to,
+// since its line numbers refer to that revision's version of the file.
+func setCommentResolution(id string, unresolved bool) {
+	var comments map[string][]*GerritComment
+	if err := gerritAPI("/a/changes/"+id+"/comments", nil, &comments); err != nil {
+		dief("cannot read comments: %v", err)
+	}
+
+	msg := "Done"
+	if unresolved {
+		msg = "Reopened"
+	}
+	reviews := make(map[string]*GerritReviewInput) // by revision
+	n := 0
+	for path, list := range comments {
+		// The state of a thread is the state of its last comment,
+		// which is the one no other comment replies to.
+		replied := make(map[string]bool)
+		for _, cm := range list {
+			replied[cm.InReplyTo] = true
+		}
+		for _, cm := range list {
+			if replied[cm.ID] || cm.Unresolved == unresolved {
+				continue
+			}
+			rev := "current"
+			if cm.PatchSet > 0 {
+				rev = strconv.Itoa(cm.PatchSet)
+			}
+			review := reviews[rev]
+			if review == nil {
+				review = &GerritReviewInput{Comments: make(map[string][]*GerritCommentInput)}
+				reviews[rev] = review
+			}
+			review.Comments[path] = append(review.Comments[path], &GerritCommentInput{
+				Path:       path,
+				Side:       cm.Side,
+				Line:       cm.Line,
+				Range:      cm.Range,
+				InReplyTo:  cm.ID,
+				Message:    msg,
+				Unresolved: &unresolved,
+			})
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+
+	var revs []string
+	for rev := range reviews {
+		revs = append(revs, rev)
+	}
+	sort.Strings(revs)
+	for _, rev := range revs {
+		js, err := json.Marshal(reviews[rev])
+		if err != nil {
+			dief("%v", err)
+		}
+		if err := gerritAPI("/a/changes/"+id+"/revisions/"+rev+"/review", js, nil); err != nil {
+			dief("cannot update comments: %v", err)
+		}
+	}
+	what := "resolved"
+	if unresolved {
+		what = "unresolved"
+	}
+	printf("marked %d comment thread%s %s.", n, suffix(n, "s"), what)
+}
+
+// trybotVotes returns the Gerrit votes that start trybots,
+// as selected by $GIT_CODEREVIEW_TRYBOT.
+// Each vote is a label name optionally followed by a signed value, like "Commit-Queue+1".
+func trybotVotes() []string {
+	switch os.Getenv("GIT_CODEREVIEW_TRYBOT") {
+	case "", "luci":
+		return []string{"Commit-Queue+1"}
+	case "farmer":
+		return []string{"Run-TryBot"}
+	case "both":
+		return []string{"Commit-Queue+1", "Run-TryBot"}
+	}
+	fmt.Fprintf(stderr(), "GIT_CODEREVIEW_TRYBOT must be unset, blank, or one of 'luci', 'farmer', or 'both'\n")
+	exit(2)
+	panic("not reached")
+}
+
+// mailLabelRE matches a single label vote given to mail -label, like Hold=1.
+var mailLabelRE = regexp.MustCompile(`^([A-Za-z][-A-Za-z0-9]*)=([+-]?[0-9]+)$`)
+
+// mailLabels parses the comma-separated list of label votes
+// given to mail -label and returns them in the form used in
+// Gerrit push options, like Hold+1. It dies if any vote is malformed.
+func mailLabels(list string) []string {
+	var labels []string
+	for _, vote := range strings.Split(list, ",") {
+		vote = strings.TrimSpace(vote)
+		if vote == "" {
+			continue
+		}
+		m := mailLabelRE.FindStringSubmatch(vote)
+		if m == nil {
+			dief("invalid label vote %q: expected label=n, as in Hold=1", vote)
+		}
+		n, err := strconv.Atoi(m[2])
+		if err != nil {
+			dief("invalid label vote %q: %v", vote, err)
+		}
+		labels = append(labels, fmt.Sprintf("%s%+d", m[1], n))
+	}
+	return labels
 }
 
 // PushSpec returns the spec for a Gerrit push command to publish the change c in b.
@@ -202,19 +622,490 @@ func (b *Branch) PushSpec(c *Commit) string {
 	return local + ":refs/for/" + strings.TrimPrefix(b.OriginBranch(), "origin/")
 }
 
+// doNotMailMarkers returns the phrases that, appearing anywhere in a
+// commit message (in any case), prevent the commit from being mailed.
+// The codereview.cfg "do-not-mail-markers" key, if set, replaces
+// the default "DO NOT MAIL" with a comma-separated list of its own.
+func doNotMailMarkers() []string {
+	var markers []string
+	for _, m := range strings.Split(config()["do-not-mail-markers"], ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			markers = append(markers, m)
+		}
+	}
+	if len(markers) == 0 {
+		return []string{"DO NOT MAIL"}
+	}
+	return markers
+}
+
+// mailCommitErrors returns the reasons, if any, that the pending commit c
+// must not be mailed: it says DO NOT MAIL, it is a fixup! or squash! commit,
+// or it adds editor temporary files.
+func mailCommitErrors(c *Commit) []error {
+	var errs []error
+	for _, phrase := range doNotMailMarkers() {
+		if strings.Contains(strings.ToLower(c.Message), strings.ToLower(phrase)) {
+			errs = append(errs, fmt.Errorf("%s: CL says %s", c.ShortHash, phrase))
+			break
+		}
+	}
+	if strings.HasPrefix(c.Message, "fixup!") {
+		errs = append(errs, fmt.Errorf("%s: CL is a fixup! commit", c.ShortHash))
+	}
+	if strings.HasPrefix(c.Message, "squash!") {
+		errs = append(errs, fmt.Errorf("%s: CL is a squash! commit", c.ShortHash))
+	}
+	for _, f := range ListFiles(c) {
+		if strings.HasPrefix(f, ".#") || strings.HasSuffix(f, "~") ||
+			(strings.HasPrefix(f, "#") && strings.HasSuffix(f, "#")) {
+			errs = append(errs, fmt.Errorf("cannot mail temporary files: %s", f))
+		}
+	}
+	return errs
+}
+
+// confirmMailCount asks the user to confirm mailing the commits in cs
+// if there are more of them than the "mail-confirm-count" configuration key allows.
+// When standard input is not a terminal, it cannot ask, so it only warns.
+func confirmMailCount(cs []*Commit) {
+	limit := config()["mail-confirm-count"]
+	if limit == "" {
+		return
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 0 {
+		dief("invalid mail-confirm-count %q in codereview.cfg", limit)
+	}
+	if len(cs) <= n {
+		return
+	}
+	var buf bytes.Buffer
+	for _, c := range cs {
+		fmt.Fprintf(&buf, "\n\t%s %s", c.ShortHash, c.Subject)
+	}
+	if !stdinIsTerminal() {
+		printf("warning: mailing %d commits:%s", len(cs), buf.String())
+		return
+	}
+	printf("mailing %d commits:%s", len(cs), buf.String())
+	fmt.Fprintf(stderr(), "mail them all (y/n)? ")
+	if !scanYes() {
+		dief("mail aborted; use 'git codereview mail -f' to skip this check")
+	}
+}
+
+// binaryFiles returns the names of the files that c adds or modifies
+// that Git considers binary.
+func binaryFiles(c *Commit) []string {
+	if c.Parent == "" {
+		return nil
+	}
+	var files []string
+	for _, line := range nonBlankLines(cmdOutput("git", "diff", "--numstat", "--no-renames", "--diff-filter=AM", c.Parent, c.Hash, "--")) {
+		// Binary files show "-" for the added and deleted line counts.
+		f := strings.SplitN(line, "\t", 3)
+		if len(f) == 3 && f[0] == "-" && f[1] == "-" {
+			files = append(files, f[2])
+		}
+	}
+	return files
+}
+
+// missingTests reports whether c changes .go files
+// without changing any _test.go files.
+func missingTests(c *Commit) bool {
+	code := false
+	for _, f := range ListFiles(c) {
+		if !strings.HasSuffix(f, ".go") {
+			continue
+		}
+		if strings.HasSuffix(f, "_test.go") {
+			return false
+		}
+		code = true
+	}
+	return code
+}
+
+// topicError returns an error if topic cannot be passed
+// to Gerrit in the push refspec.
+// There's no way to escape the topic, so it must not contain ',',
+// which separates push options, nor any character that git
+// does not allow in a ref name, such as ' ' or ':'.
+// To be safe, only characters known to work are allowed.
+func topicError(topic string) error {
+	for _, r := range topic {
+		switch {
+		case r == ',':
+			return fmt.Errorf("topic may not contain a comma")
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9',
+			strings.ContainsRune("-_.+/@", r):
+			// ok
+		default:
+			return fmt.Errorf("topic may not contain %q", r)
+		}
+	}
+	if strings.Contains(topic, "..") {
+		return fmt.Errorf("topic may not contain %q", "..")
+	}
+	return nil
+}
+
+// mailMessageError returns an error if the commit message msg
+// is not valid UTF-8 or contains non-printable characters.
+func mailMessageError(msg string) error {
+	if !utf8.ValidString(msg) {
+		return fmt.Errorf("cannot mail message with invalid UTF-8")
+	}
+	if r, ok := nonPrintableRune(msg); ok {
+		return fmt.Errorf("cannot mail message with non-printable rune %q", r)
+	}
+	return nil
+}
+
+// nonPrintableRune returns the first rune in msg that is
+// neither printable nor space, and reports whether there is one.
+func nonPrintableRune(msg string) (rune, bool) {
+	for _, r := range msg {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+// defaultMailMarkers are the work-in-progress markers that mail
+// warns about when they appear in a commit subject.
+var defaultMailMarkers = []string{"TODO(me)", "WIP", "XXX"}
+
+// mailMarkers returns the list of work-in-progress markers to look for
+// in commit subjects. The codereview.cfg "mail-markers" key, if set,
+// replaces the default list with a comma-separated list of its own;
+// the value "off" disables the check.
+func mailMarkers() []string {
+	list, ok := config()["mail-markers"]
+	if !ok {
+		return defaultMailMarkers
+	}
+	if list == "off" {
+		return nil
+	}
+	var markers []string
+	for _, m := range strings.Split(list, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			markers = append(markers, m)
+		}
+	}
+	return markers
+}
+
+// findMarker returns the first of markers that appears in subject
+// as a separate word, or "" if none does.
+func findMarker(subject string, markers []string) string {
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for _, m := range markers {
+		for i := 0; ; {
+			j := strings.Index(subject[i:], m)
+			if j < 0 {
+				break
+			}
+			start, end := i+j, i+j+len(m)
+			before, _ := utf8.DecodeLastRuneInString(subject[:start])
+			after, _ := utf8.DecodeRuneInString(subject[end:])
+			if !isWord(before) && !isWord(after) {
+				return m
+			}
+			i = start + 1
+		}
+	}
+	return ""
+}
+
+// priorReviewers returns the email addresses of the accounts that were
+// reviewers of c's change on Gerrit but have since been removed from it,
+// other than the change owner and the addresses in the comma-separated
+// list known, in which short names are expanded as mailList does.
+// Accounts that are still reviewers or CCs are left alone: Gerrit already
+// notifies them, and pushing a reviewer as CC would demote them.
+// The change has the given full ID. If c has not been mailed yet,
+// there are none.
+func priorReviewers(c *Commit, changeID string, known string) []string {
+	if c.ChangeID == "" {
+		return nil
+	}
+	// Gerrit reports the reviewers by state only with the detailed labels.
+	g, err := readGerritChange(changeID + "?o=DETAILED_LABELS")
+	if err != nil {
+		var gerr *gerritError
+		if errors.As(err, &gerr) && gerr.statusCode == http.StatusNotFound {
+			return nil
+		}
+		dief("%v", err)
+	}
+	skip := map[string]bool{"": true}
+	if g.Owner != nil {
+		skip[g.Owner.Email] = true
+	}
+	for _, a := range g.Reviewers["REVIEWER"] {
+		skip[a.Email] = true
+	}
+	for _, a := range g.Reviewers["CC"] {
+		skip[a.Email] = true
+	}
+	for _, addr := range strings.Split(known, ",") {
+		addr = strings.TrimPrefix(addr, "-")
+		if m := mailAddressRE.FindStringSubmatch(addr); m != nil && m[2] == "" {
+			addr = mailLookup(addr)
+		}
+		skip[addr] = true
+	}
+	var addrs []string
+	for _, a := range g.Reviewers["REMOVED"] {
+		if !skip[a.Email] {
+			skip[a.Email] = true
+			addrs = append(addrs, a.Email)
+		}
+	}
+	return addrs
+}
+
+// fileOwners returns the addresses listed in the OWNERS-style files,
+// named by name, that govern the files changed by c, omitting skip.
+// For each changed file, fileOwners reads the owners file in the file's
+// directory and in each parent directory up to the repository root,
+// stopping early at a file containing "set noparent".
+// Only lines that are plain addresses are used: comments, "per-file" rules,
+// "file:" includes, and the "*" wildcard are ignored.
+// The owners files are read from c's tree, not the working tree.
+func fileOwners(c *Commit, name, skip string) []string {
+	seen := map[string]bool{"": true, skip: true}
+	done := make(map[string]bool)
+	var addrs []string
+	for _, file := range ListFiles(c) {
+		for dir := path.Dir(file); ; dir = path.Dir(dir) {
+			if done[dir] {
+				break
+			}
+			done[dir] = true
+			file := name
+			if dir != "." {
+				file = dir + "/" + name
+			}
+			data, err := cmdOutputErr("git", "show", c.Hash+":"+file)
+			noparent := false
+			if err == nil {
+				for _, line := range lines(data) {
+					if i := strings.Index(line, "#"); i >= 0 {
+						line = line[:i]
+					}
+					line = strings.TrimSpace(line)
+					if line == "set noparent" {
+						noparent = true
+					}
+					if strings.Contains(line, "@") && !strings.ContainsAny(line, " \t:=") && !seen[line] {
+						seen[line] = true
+						addrs = append(addrs, line)
+					}
+				}
+			}
+			if noparent || dir == "." {
+				break
+			}
+		}
+	}
+	return addrs
+}
+
+// copyChangeID returns the Change-Id from the commit message of rev,
+// for mail -change-id-from to copy into the commit message of c,
+// so that mailing c updates rev's change instead of creating a new one.
+// This is useful when a change has been deliberately recreated,
+// for example by cherry-picking it onto a new base.
+func copyChangeID(c *Commit, rev string) string {
+	msg, err := cmdOutputErr("git", "log", "-n", "1", "--format=format:%B", rev, "--")
+	if err != nil {
+		dief("cannot mail: unknown revision %s", rev)
+	}
+	id := ""
+	for _, line := range lines(msg) {
+		if strings.HasPrefix(line, "Change-Id: ") {
+			id = line[len("Change-Id: "):]
+		}
+	}
+	if id == "" {
+		dief("cannot mail: %s has no Change-Id", rev)
+	}
+	if c.ChangeID != "" && c.ChangeID != id {
+		dief("cannot mail: %s already has Change-Id %s", c.ShortHash, c.ChangeID)
+	}
+	return id
+}
+
+// clChangeID returns the Change-Id of the Gerrit change numbered cl,
+// for mail -cl to set in the commit message in place of any Change-Id
+// already there, so that mailing the commit uploads a new patch set to
+// that change. This is useful when a commit has lost its association
+// with its change, as in a new clone where the commit-msg hook added
+// a fresh Change-Id.
+func clChangeID(cl string) string {
+	if _, err := strconv.Atoi(cl); err != nil {
+		dief("cannot mail: invalid CL number %q", cl)
+	}
+	g, err := readGerritChange(cl)
+	if err != nil {
+		dief("cannot mail: reading CL %s: %v", cl, err)
+	}
+	if g.ChangeId == "" {
+		dief("cannot mail: CL %s has no Change-Id", cl)
+	}
+	return g.ChangeId
+}
+
+// setChangeID sets the Change-Id line in the commit message of c to id,
+// replacing any existing Change-Id line, and returns the amended commit.
+// It reports where the Change-Id came from, as described by from.
+// Because it amends c, c must be the most recent pending commit.
+// The amended commit leaves out any staged changes.
+func setChangeID(b *Branch, c *Commit, id, from string) *Commit {
+	if c.ChangeID == id {
+		return c
+	}
+	if c != b.Pending()[0] {
+		dief("cannot mail: can only set Change-Id in the most recent pending commit, %s", b.Pending()[0].ShortHash)
+	}
+
+	var msg []string
+	for _, line := range lines(c.Message) {
+		if !strings.HasPrefix(line, "Change-Id: ") {
+			msg = append(msg, line)
+		}
+	}
+	data := []byte(strings.TrimRight(strings.Join(msg, "\n"), "\n"))
+	sep := "\n\n"
+	if endsWithMetadataLine(data) {
+		sep = "\n"
+	}
+	run("git", "commit", "-q", "--amend", "--only", "--no-verify", "-m", string(data)+sep+"Change-Id: "+id+"\n")
+	b.loadedPending = false
+	c = b.Pending()[0]
+	printf("copied Change-Id %s from %s to %s", id, from, c.ShortHash)
+	return c
+}
+
+// squashCommits creates a single commit combining c and the pending
+// commits below it on b, for mail -squash, and returns its hash.
+// The new commit has c's tree, author, and message (and so its Change-Id)
+// and is parented on the branchpoint. It is not added to any branch.
+// If c is the only commit to combine, squashCommits returns "".
+//
+// squashCommits refuses to combine commits with a Change-Id other than c's:
+// those belong to separate changes, which would be orphaned if mailed,
+// or would be confused with c's change if mailed later.
+func squashCommits(b *Branch, c *Commit) string {
+	var cs []*Commit
+	found := false
+	for _, c1 := range b.Pending() {
+		if c1 == c {
+			found = true
+		}
+		if found {
+			cs = append(cs, c1)
+		}
+	}
+	if len(cs) <= 1 {
+		return ""
+	}
+	if c.ChangeID == "" {
+		dief("cannot squash: %s has no Change-Id", c.ShortHash)
+	}
+	for _, c1 := range cs[1:] {
+		if len(c1.Parents) > 1 {
+			dief("cannot squash: %s is a merge commit", c1.ShortHash)
+		}
+		if c1.ChangeID != "" && c1.ChangeID != c.ChangeID {
+			dief("cannot squash: %s has a different Change-Id, %s", c1.ShortHash, c1.ChangeID)
+		}
+	}
+
+	os.Setenv("GIT_AUTHOR_NAME", c.AuthorName)
+	os.Setenv("GIT_AUTHOR_EMAIL", c.AuthorEmail)
+	os.Setenv("GIT_AUTHOR_DATE", c.AuthorDate)
+	h := trim(cmdOutput("git", "commit-tree", "-p", cs[len(cs)-1].Parent, "-m", c.Message, c.Tree))
+	printf("squashed %d commits into %.7s", len(cs), h)
+	return h
+}
+
+// pushSpecTarget returns spec with its refs/for/ destination
+// replaced by the given branch.
+func pushSpecTarget(spec, branch string) string {
+	i := strings.Index(spec, ":refs/for/")
+	if i < 0 {
+		dief("internal error: unexpected push spec %q", spec)
+	}
+	return spec[:i] + ":refs/for/" + branch
+}
+
+// checkPushURL dies with advice if git would push to origin at a URL
+// that is obviously not the Gerrit server, such as a read-only GitHub
+// mirror, which would otherwise fail with a confusing error from git.
+// The push URL can differ from the remote.origin.url checked by
+// loadGerritOrigin, either because remote.origin.pushurl is set or
+// because the Gerrit server is named by codereview.cfg.
+func checkPushURL() {
+	pushURL, err := trimErr(cmdOutputErr("git", "remote", "get-url", "--push", "origin"))
+	if err != nil {
+		return // let git push report the problem
+	}
+	googlesource := strings.HasSuffix(auth.host, ".googlesource.com")
+	switch {
+	case strings.Contains(pushURL, "github.com"):
+		// GitHub is never a Gerrit server.
+	case googlesource && !strings.Contains(pushURL, ".googlesource.com") &&
+		!strings.HasPrefix(pushURL, "sso://") && !strings.HasPrefix(pushURL, "rpc://"):
+		// Some other host, such as a mirror.
+	default:
+		return
+	}
+	if !googlesource {
+		dief("cannot mail: origin push URL %s is not a Gerrit server\n"+
+			"\tset remote.origin.pushurl to the Gerrit server's URL for this repository", pushURL)
+	}
+	want := "https://" + auth.host + "/" + auth.project
+	dief("cannot mail: origin push URL %s is not the Gerrit server %s\n"+
+		"\trun 'git remote set-url --push origin %s' to push to Gerrit", pushURL, auth.host, want)
+}
+
+// checkTargetBranch checks that the branch named by the mail -target flag
+// exists on origin and returns its name without the origin/ prefix.
+func checkTargetBranch(target string) string {
+	target = strings.TrimPrefix(target, "origin/")
+	for _, name := range OriginBranches() {
+		if name == "origin/"+target {
+			return target
+		}
+	}
+	dief("cannot mail to %s: branch does not exist on origin", target)
+	panic("not reached")
+}
+
 // mailAddressRE matches the mail addresses we admit. It's restrictive but admits
 // all the addresses in the Go CONTRIBUTORS file at time of writing (tested separately).
 var mailAddressRE = regexp.MustCompile(`^([a-zA-Z0-9][-_.a-zA-Z0-9]*)(@[-_.a-zA-Z0-9]+)?$`)
 
 // mailList turns the list of mail addresses from the flag value into the format
 // expected by gerrit. The start argument is a % or , depending on where we
-// are in the processing sequence.
-func mailList(start, tag string, flagList string) string {
+// are in the processing sequence. It also returns the full addresses,
+// with any short names expanded.
+// Addresses prefixed with a minus sign, as in -r -rsc, are not added to the
+// spec but returned separately in removed, for removal using the Gerrit API.
+func mailList(start, tag string, flagList string) (spec string, addrs, removed []string) {
 	errors := false
-	spec := start
 	short := ""
 	long := ""
-	for i, addr := range strings.Split(flagList, ",") {
+	for _, addr := range strings.Split(flagList, ",") {
+		remove := strings.HasPrefix(addr, "-")
+		addr = strings.TrimPrefix(addr, "-")
 		m := mailAddressRE.FindStringSubmatch(addr)
 		if m == nil {
 			printf("invalid reviewer mail address: %s", addr)
@@ -232,10 +1123,17 @@ func mailList(start, tag string, flagList string) string {
 			long += "," + email
 			addr = email
 		}
-		if i > 0 {
+		if remove {
+			removed = append(removed, addr)
+			continue
+		}
+		if spec == "" {
+			spec = start
+		} else {
 			spec += ","
 		}
 		spec += tag + "=" + addr
+		addrs = append(addrs, addr)
 	}
 	if short != "" {
 		verbosef("expanded %s to %s", short[1:], long[1:])
@@ -243,7 +1141,36 @@ func mailList(start, tag string, flagList string) string {
 	if errors {
 		exit(1)
 	}
-	return spec
+	return spec, addrs, removed
+}
+
+// removeReviewers removes the reviewers with the given addresses
+// from the Gerrit change with the given full ID.
+func removeReviewers(id string, addrs []string) {
+	for _, addr := range addrs {
+		if err := gerritAPIMethod("DELETE", "/a/changes/"+id+"/reviewers/"+url.PathEscape(addr), nil, nil); err != nil {
+			dief("cannot remove reviewer %s: %v", addr, err)
+		}
+		printf("removed reviewer %s.", addr)
+	}
+}
+
+// checkReviewers looks up each of the mail addresses on the Gerrit server
+// and dies listing any that the server does not know about.
+func checkReviewers(addrs []string) {
+	var unknown []string
+	for _, addr := range addrs {
+		var accounts []*GerritAccount
+		if err := gerritAPI("/a/accounts/?q=email:"+url.QueryEscape(addr), nil, &accounts); err != nil {
+			dief("cannot validate reviewer %s: %v", addr, err)
+		}
+		if len(accounts) == 0 {
+			unknown = append(unknown, addr)
+		}
+	}
+	if len(unknown) > 0 {
+		dief("reviewer addresses not known to Gerrit server:\n\t%s", strings.Join(unknown, "\n\t"))
+	}
 }
 
 // reviewers is the list of reviewers for the current repository,
@@ -277,6 +1204,44 @@ func mailLookup(short string) string {
 	return ""
 }
 
+// pickReviewers opens the editor on the list of known reviewers,
+// most frequent first and each commented out, and returns the
+// addresses on the lines that the user uncommented.
+// There is nobody to edit the list when standard input
+// is not a terminal, so pickReviewers dies instead.
+func pickReviewers() []string {
+	if !stdinIsTerminal() {
+		dief("cannot pick reviewers: standard input is not a terminal\n" +
+			"\tuse 'git codereview mail -r' to name the reviewers")
+	}
+	loadReviewers()
+	if len(reviewers) == 0 {
+		printf("no reviewers found in recent commit history")
+		return nil
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Uncomment the reviewers to add to the change.\n")
+	fmt.Fprintf(&buf, "# Reviewers are listed by how many recent commits they reviewed.\n")
+	fmt.Fprintf(&buf, "# Lines beginning with # are ignored.\n#\n")
+	for _, r := range reviewers {
+		fmt.Fprintf(&buf, "# %s\n", r.addr)
+	}
+
+	var picked []string
+	for _, line := range lines(editor(buf.String())) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		picked = append(picked, strings.Fields(line)[0])
+	}
+	if len(picked) == 0 {
+		printf("no reviewers picked")
+	}
+	return picked
+}
+
 // shortOptOut lists email addresses whose owners have opted out
 // from consideration for purposes of expanding short user names.
 var shortOptOut = map[string]bool{
@@ -5,7 +5,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -29,7 +32,30 @@ func TestMail(t *testing.T) {
 	testMain(t, "mail")
 	testRan(t,
 		"git push -q origin HEAD:refs/for/main",
-		"git tag --no-sign -f work.mailed "+h)
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+}
+
+func TestMailAuto(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+	gt.work(t)
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	pending := CurrentBranch().Pending()
+	srv.setJSON("I223456789", `{"_number": 102}`)
+
+	testMainDied(t, "mail", "-auto", "HEAD~1")
+	testPrintedStderr(t, "Usage:")
+
+	testMain(t, "mail", "-auto")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main",
+		"git tag --no-sign --create-reflog -f work.mailed "+pending[0].ShortHash)
+	testPrintedStdout(t,
+		pending[0].ShortHash+" msg #2\n\t"+auth.url+"/102\n",
+		pending[1].ShortHash+" msg\n\tnot found on Gerrit\n")
 }
 
 func TestDoNotMail(t *testing.T) {
@@ -57,6 +83,54 @@ func TestDoNotMail(t *testing.T) {
 	gt.work(t)
 	testMainDied(t, "mail", "HEAD")
 	testPrintedStderr(t, "DO NOT MAIL")
+
+	// The phrases are configurable.
+	write(t, gt.client+"/codereview.cfg", "do-not-mail-markers: not for review, DO NOT MAIL\n", 0644)
+	cachedConfig = nil
+	trun(t, gt.client, "git", "reset", "-q", "--hard", "HEAD^")
+	trun(t, gt.client, "git", "commit", "--amend", "-m", "This is my commit.\n\nNot For Review\n")
+	testMainDied(t, "mail")
+	testPrintedStderr(t, "CL says not for review")
+}
+
+func TestMailMarkers(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	// fake auth information to avoid Gerrit error
+	auth.initialized = true
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.initialized = false
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	trun(t, gt.client, "git", "commit", "--amend", "-m", "dir: WIP new feature\n\nChange-Id: I123456789\n")
+	// Use -n to avoid pushing the same change repeatedly.
+	testMain(t, "mail", "-n")
+	testPrintedStderr(t, `warning: `, `CL subject contains "WIP"`, "git push -q origin HEAD:refs/for/main")
+
+	testMainDied(t, "mail", "-n", "-strict")
+	testPrintedStderr(t, `CL subject contains "WIP"`, "!warning")
+
+	// Markers only count as whole words.
+	trun(t, gt.client, "git", "commit", "--amend", "-m", "dir: WIPE and XXXL\n\nChange-Id: I123456789\n")
+	testMain(t, "mail", "-n", "-strict")
+	testPrintedStderr(t, "!warning", "git push -q origin HEAD:refs/for/main")
+
+	write(t, gt.client+"/codereview.cfg", "mail-markers: WIPE\n", 0644)
+	cachedConfig = nil
+	testMainDied(t, "mail", "-n", "-strict", "-f")
+	testPrintedStderr(t, `CL subject contains "WIPE"`)
+
+	write(t, gt.client+"/codereview.cfg", "mail-markers: off\n", 0644)
+	cachedConfig = nil
+	trun(t, gt.client, "git", "commit", "--amend", "-m", "dir: WIP\n\nChange-Id: I123456789\n")
+	testMain(t, "mail", "-n", "-strict", "-f")
+	testPrintedStderr(t, "!warning", "git push -q origin HEAD:refs/for/main")
 }
 
 func TestDoNotMailTempFiles(t *testing.T) {
@@ -93,6 +167,77 @@ func TestDoNotMailTempFiles(t *testing.T) {
 	testPrintedStderr(t, "cannot mail temporary")
 }
 
+func TestMailBinaryFiles(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+	write(t, gt.client+"/blob.bin", "binary\x00data", 0644)
+	trun(t, gt.client, "git", "add", "blob.bin")
+	trun(t, gt.client, "git", "commit", "-q", "-m", "msg\n\nChange-Id: I987654321\n")
+	gt.work(t)
+	h := CurrentBranch().CommitByRev("mail", "HEAD~1").ShortHash
+
+	testMainDied(t, "mail", "-n", "HEAD")
+	testPrintedStderr(t, "cannot mail: binary files in commits:\n\t"+h+": blob.bin\n",
+		"mail -f")
+
+	testMain(t, "mail", "-n", "-f", "HEAD")
+	testPrintedStderr(t, "warning: mailing binary files:\n\t"+h+": blob.bin\n",
+		"git push -q origin HEAD:refs/for/main")
+}
+
+func TestMailMissingTests(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+	write(t, gt.client+"/x.go", "package x\n", 0644)
+	trun(t, gt.client, "git", "add", "x.go")
+	trun(t, gt.client, "git", "commit", "-q", "-m", "x: add\n\nChange-Id: I987654321\n")
+	h := CurrentBranch().CommitByRev("mail", "HEAD").ShortHash
+
+	testMain(t, "mail", "-n", "HEAD")
+	testPrintedStderr(t, "!no tests")
+
+	write(t, gt.client+"/codereview.cfg", "warn-missing-tests: true\n", 0644)
+	testMain(t, "mail", "-n", "HEAD")
+	testPrintedStderr(t, "warning: "+h+": CL changes Go code but no tests\n", "git push -q origin HEAD:refs/for/main")
+
+	write(t, gt.client+"/x_test.go", "package x\n", 0644)
+	trun(t, gt.client, "git", "add", "x_test.go")
+	trun(t, gt.client, "git", "commit", "-q", "--amend", "--no-edit")
+	testMain(t, "mail", "-n", "HEAD")
+	testPrintedStderr(t, "!no tests")
+}
+
+func TestMailConfirmCount(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+	gt.work(t)
+	gt.work(t)
+	write(t, gt.client+"/codereview.cfg", "mail-confirm-count: 2\n", 0644)
+	defer func(f func() bool) { stdinIsTerminal = f }(stdinIsTerminal)
+
+	testMain(t, "mail", "-n", "HEAD~1")
+	testPrintedStderr(t, "!mailing 2 commits")
+
+	stdinIsTerminal = func() bool { return true }
+	testMainDied(t, "mail", "-n", "HEAD")
+	testPrintedStderr(t, "mailing 3 commits:\n\t", "msg #3\n\t", "mail them all (y/n)?", "mail aborted")
+	testRan(t)
+
+	testMain(t, "mail", "-n", "-f", "HEAD")
+	testPrintedStderr(t, "!mailing 3 commits", "git push -q origin HEAD:refs/for/main")
+
+	stdinIsTerminal = func() bool { return false }
+	testMain(t, "mail", "-n", "HEAD")
+	testPrintedStderr(t, "warning: mailing 3 commits:", "git push -q origin HEAD:refs/for/main")
+
+	write(t, gt.client+"/codereview.cfg", "mail-confirm-count: many\n", 0644)
+	testMainDied(t, "mail", "-n", "HEAD")
+	testPrintedStderr(t, `invalid mail-confirm-count "many"`)
+}
+
 func TestMailNonPrintables(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
@@ -112,6 +257,10 @@ func TestMailNonPrintables(t *testing.T) {
 	testMainDied(t, "mail")
 	testPrintedStderr(t, "message with non-printable")
 
+	// The check can be downgraded to a warning.
+	testMain(t, "mail", "-n", "-allow-nonprintable")
+	testPrintedStderr(t, `warning: mailing message with non-printable rune '\x10'`, "git push -q origin HEAD:refs/for/main")
+
 	// This should be mailed.
 	trun(t, gt.client, "git", "commit", "--amend", "-m", "Printable unicode: \u263A \u0020. Spaces: \v \f \r \t\n\n")
 	testMain(t, "mail", "HEAD")
@@ -128,6 +277,36 @@ func TestMailGitHub(t *testing.T) {
 	testPrintedStderr(t, "git origin must be a Gerrit host, not GitHub: https://github.com/golang/go")
 }
 
+func TestMailReadOnlyMirror(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	// fake auth information to avoid Gerrit error
+	auth.initialized = true
+	auth.host = "gerrit.fake"
+	auth.project = "proj"
+	defer func() {
+		auth.initialized = false
+		auth.host = ""
+		auth.project = ""
+	}()
+
+	trun(t, gt.client, "git", "config", "remote.origin.pushurl", "https://github.com/golang/go")
+	testMainDied(t, "mail")
+	testPrintedStderr(t, "cannot mail: origin push URL https://github.com/golang/go is not a Gerrit server")
+	testRan(t) // nothing
+
+	auth.host = "go.googlesource.com"
+	trun(t, gt.client, "git", "config", "remote.origin.pushurl", "https://mirror.example.com/proj")
+	testMainDied(t, "mail")
+	testPrintedStderr(t, "is not the Gerrit server go.googlesource.com",
+		"git remote set-url --push origin https://go.googlesource.com/proj")
+
+	trun(t, gt.client, "git", "config", "remote.origin.pushurl", "https://go.googlesource.com/proj")
+	testMain(t, "mail", "-n")
+}
+
 func TestMailAmbiguousRevision(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
@@ -160,14 +339,14 @@ func TestMailMultiple(t *testing.T) {
 	testMain(t, "mail", "HEAD^")
 	testRan(t,
 		"git push -q origin "+h+":refs/for/main",
-		"git tag --no-sign -f work.mailed "+h)
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
 
 	// Mail HEAD.
 	h = CurrentBranch().Pending()[0].ShortHash
 	testMain(t, "mail", "HEAD")
 	testRan(t,
 		"git push -q origin HEAD:refs/for/main",
-		"git tag --no-sign -f work.mailed "+h)
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
 }
 
 var reviewerLog = []string{
@@ -216,17 +395,17 @@ func TestMailShort(t *testing.T) {
 	testMain(t, "mail")
 	testRan(t,
 		"git push -q origin HEAD:refs/for/main",
-		"git tag --no-sign -f work.mailed "+h)
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
 
 	testMain(t, "mail", "-r", "r1")
 	testRan(t,
 		"git push -q origin HEAD:refs/for/main%r=r1@golang.org",
-		"git tag --no-sign -f work.mailed "+h)
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
 
 	testMain(t, "mail", "-r", "other,anon", "-cc", "r1,full@email.com")
 	testRan(t,
 		"git push -q origin HEAD:refs/for/main%r=other@golang.org,r=anon@golang.org,cc=r1@golang.org,cc=full@email.com",
-		"git tag --no-sign -f work.mailed "+h)
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
 
 	testMainDied(t, "mail", "-r", "other", "-r", "anon,r1,missing")
 	testPrintedStderr(t, "unknown reviewer: missing")
@@ -238,7 +417,7 @@ func TestMailShort(t *testing.T) {
 	testMain(t, "mail", "-r", "r2")
 	testRan(t,
 		"git push -q origin HEAD:refs/for/main%r=r2@new.example",
-		"git tag --no-sign -f work.mailed "+h)
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
 }
 
 func TestWIP(t *testing.T) {
@@ -251,7 +430,22 @@ func TestWIP(t *testing.T) {
 	testMain(t, "mail", "-wip")
 	testRan(t,
 		"git push -q origin HEAD:refs/for/main%wip",
-		"git tag --no-sign -f work.mailed "+h)
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+}
+
+func TestMailPrivate(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	testMain(t, "mail", "-n", "-private", "-wip")
+	testPrintedStderr(t, "git push -q origin HEAD:refs/for/main%wip,private\n")
+
+	testMain(t, "mail", "-n", "-remove-private")
+	testPrintedStderr(t, "git push -q origin HEAD:refs/for/main%remove-private\n")
+
+	testMainDied(t, "mail", "-private", "-remove-private")
+	testPrintedStderr(t, "Usage:")
 }
 
 func TestMailTopic(t *testing.T) {
@@ -274,10 +468,167 @@ func TestMailTopic(t *testing.T) {
 	testMainDied(t, "mail", "-topic", "contains,comma")
 	testPrintedStderr(t, "topic may not contain a comma")
 
+	for _, tt := range []struct{ topic, bad string }{
+		{"has space", `' '`},
+		{"a:b", `':'`},
+		{"what?", `'?'`},
+		{"ref~1", `'~'`},
+		{"a..b", `".."`},
+		{"caf\u00e9", `'é'`},
+	} {
+		testMainDied(t, "mail", "-topic", tt.topic)
+		testPrintedStderr(t, "topic may not contain "+tt.bad)
+		testRan(t)
+	}
+
+	testMain(t, "mail", "-n", "-topic", "go1.22/fix_bug-2+more@x")
+	testPrintedStderr(t, "git push -q origin HEAD:refs/for/main%topic=go1.22/fix_bug-2+more@x")
+
 	testMain(t, "mail", "-topic", "test-topic")
 	testRan(t,
 		"git push -q origin HEAD:refs/for/main%topic=test-topic",
-		"git tag --no-sign -f work.mailed "+h)
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+}
+
+func TestMailLabel(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	// fake auth information to avoid Gerrit error
+	auth.initialized = true
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.initialized = false
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	for _, bad := range []string{"Hold", "Hold+1", "Hold=x", "=1", "Ho ld=1"} {
+		testMainDied(t, "mail", "-label", bad)
+		testPrintedStderr(t, "invalid label vote")
+		testRan(t)
+	}
+
+	testMain(t, "mail", "-label", "Hold=1,API-Review=+2", "-label", "Code-Review=-1", "-autosubmit")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main%l=Hold+1,l=API-Review+2,l=Code-Review-1,l=Auto-Submit",
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+}
+
+func TestMailTarget(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	// fake auth information to avoid Gerrit error
+	auth.initialized = true
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.initialized = false
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	testMainDied(t, "mail", "-target", "release-branch.go1.99")
+	testPrintedStderr(t, "cannot mail to release-branch.go1.99: branch does not exist on origin")
+
+	testMain(t, "mail", "-target", "dev.branch", "-topic", "test-topic")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/dev.branch%topic=test-topic",
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+
+	testMain(t, "mail", "-target", "origin/dev.branch")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/dev.branch",
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+}
+
+func TestMailTargetChange(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	// The change exists only on the target branch,
+	// so the follow-up requests must use that branch.
+	srv.setReply("/a/changes/proj~dev.branch~I123456789", gerritReply{body: ")]}'\n" + `{"_number": 1234, "status": "NEW"}`})
+	srv.setReply("/a/changes/proj~dev.branch~I123456789/comments", gerritReply{json: map[string][]*GerritComment{
+		"file": {{ID: "c1", PatchSet: 1, Line: 3, Message: "fix this", Unresolved: true}},
+	}})
+	srv.setReply("/a/changes/proj~dev.branch~I123456789/revisions/1/review", gerritReply{body: ")]}'\n{}"})
+
+	testMain(t, "mail", "-target", "dev.branch", "-no-renotify", "-resolve", "-r", "r@golang.org")
+	testPrintedStderr(t, "CL already exists; not adding reviewers: r@golang.org", "marked 1 comment thread resolved")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/dev.branch",
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+	if _, ok := srv.posted["/a/changes/proj~dev.branch~I123456789/revisions/1/review"]; !ok {
+		t.Fatalf("did not resolve comments on the dev.branch change")
+	}
+}
+
+func TestMailSquash(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	// Commits for other changes cannot be squashed in,
+	// whether or not those changes have been mailed.
+	lower := CurrentBranch().Pending()[1].ShortHash
+	testMainDied(t, "mail", "-squash", "HEAD")
+	testPrintedStderr(t, "cannot squash: "+lower+" has a different Change-Id, I123456789")
+	testRan(t)
+
+	// Drop the lower commit's Change-Id.
+	trun(t, gt.client, "git", "reset", "-q", "--hard", "HEAD~1")
+	trun(t, gt.client, "git", "commit", "-q", "--amend", "--no-verify", "-m", "lower\n")
+	write(t, gt.client+"/file", "top", 0644)
+	trun(t, gt.client, "git", "commit", "-q", "-a", "--no-verify", "-m", "top\n\nChange-Id: I223456789\n")
+
+	head := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+	base := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "origin/main"))
+
+	testMain(t, "mail", "-squash", "HEAD")
+	testPrintedStderr(t, "squashed 2 commits")
+	mailed := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "work.mailed"))
+	testRan(t,
+		"git push -q origin "+mailed+":refs/for/main",
+		"git tag --no-sign --create-reflog -f work.mailed "+mailed)
+
+	if got := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD")); got != head {
+		t.Errorf("HEAD = %s after mail -squash, want unchanged %s", got, head)
+	}
+	if got := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", mailed+"^")); got != base {
+		t.Errorf("squashed commit parent = %s, want %s", got, base)
+	}
+	if got, want := trun(t, gt.client, "git", "rev-parse", mailed+"^{tree}"), trun(t, gt.client, "git", "rev-parse", "HEAD^{tree}"); got != want {
+		t.Errorf("squashed commit tree = %s, want %s", got, want)
+	}
+	if msg := trun(t, gt.client, "git", "log", "-n", "1", "--format=%B", mailed); !strings.Contains(msg, "Change-Id: I223456789") {
+		t.Errorf("squashed commit message:\n%s\nwant Change-Id of top commit", msg)
+	}
+
+	// The squashed upload counts as mailing the top commit.
+	testMain(t, "pending", "-l", "-s")
+	testPrintedStdout(t, "top", "!local edits not mailed")
+	trun(t, gt.client, "git", "commit", "-q", "--amend", "--no-verify", "-m", "top edited\n\nChange-Id: I223456789\n")
+	testMain(t, "pending", "-l", "-s")
+	testPrintedStdout(t, "top edited (local edits not mailed)")
 }
 
 func TestMailHashtag(t *testing.T) {
@@ -300,11 +651,11 @@ func TestMailHashtag(t *testing.T) {
 	testMain(t, "mail", "-hashtag", "test1,test2")
 	testRan(t,
 		"git push -q origin HEAD:refs/for/main%hashtag=test1,hashtag=test2",
-		"git tag --no-sign -f work.mailed "+h)
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
 	testMain(t, "mail", "-hashtag", "")
 	testRan(t,
 		"git push -q origin HEAD:refs/for/main",
-		"git tag --no-sign -f work.mailed "+h)
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
 
 	testMainDied(t, "mail", "-hashtag", "test1,,test3")
 	testPrintedStderr(t, "hashtag may not contain empty tags")
@@ -338,3 +689,490 @@ func TestMailEmpty(t *testing.T) {
 	testMainDied(t, "mail")
 	testPrintedStderr(t, "cannot mail: commit "+h+" is empty")
 }
+
+func TestMailValidateReviewers(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	srv.setReply("/a/accounts/?q=email:good%40golang.org", gerritReply{json: []*GerritAccount{{ID: 1, Email: "good@golang.org"}}})
+	srv.setReply("/a/accounts/?q=email:bad%40golang.org", gerritReply{json: []*GerritAccount{}})
+
+	testMainDied(t, "mail", "-validate-reviewers", "-r", "good@golang.org", "-cc", "bad@golang.org")
+	testPrintedStderr(t, "not known to Gerrit server", "bad@golang.org", "!good@golang.org")
+	testRan(t)
+
+	testMain(t, "mail", "-validate-reviewers", "-r", "good@golang.org")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main%r=good@golang.org",
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+}
+
+func TestMailCCFromReviewedBy(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	// Not yet mailed: nobody to CC.
+	testMain(t, "mail", "-cc-from-reviewed-by")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main",
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+
+	// As Gerrit reports it, everyone who voted is a current reviewer,
+	// and only removed reviewers need to be added back, as CCs.
+	srv.setJSON("I123456789", `{
+		"owner": {"email": "me@golang.org"},
+		"labels": {
+			"Code-Review": {"all": [{"email": "r1@golang.org", "value": 2}, {"email": "r2@golang.org", "value": 0}]},
+			"Commit-Queue": {"all": [{"email": "r1@golang.org", "value": 1}]}
+		},
+		"reviewers": {
+			"REVIEWER": [{"email": "r1@golang.org"}, {"email": "r2@golang.org"}],
+			"CC": [{"email": "r3@golang.org"}],
+			"REMOVED": [{"email": "r4@golang.org"}, {"email": "other@golang.org"}, {"email": "r5@golang.org"}]
+		}
+	}`)
+	testMain(t, "mail", "-n", "-cc-from-reviewed-by", "-cc", "other@golang.org")
+	testPrintedStderr(t, "git push -q origin HEAD:refs/for/main%cc=other@golang.org,cc=r4@golang.org,cc=r5@golang.org\n")
+
+	// Short names match their expansions.
+	trun(t, gt.server, "git", "commit", "--allow-empty", "-m", "a\n\nReviewed-by: R4 <r4@golang.org>\n")
+	trun(t, gt.client, "git", "pull", "-q", "-r", "origin", "main")
+	reviewers = nil
+	defer func() { reviewers = nil }()
+	testMain(t, "mail", "-n", "-cc-from-reviewed-by", "-r", "r4")
+	testPrintedStderr(t, "git push -q origin HEAD:refs/for/main%r=r4@golang.org,cc=other@golang.org,cc=r5@golang.org\n")
+}
+
+func TestMailCCWatchers(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	write(t, gt.client+"/codereview.cfg", "cc-watchers: dev@golang.org,bots@golang.org\n", 0644)
+
+	testMain(t, "mail", "-n", "-cc", "other@golang.org")
+	testPrintedStderr(t, "git push -q origin HEAD:refs/for/main%cc=other@golang.org,cc=dev@golang.org,cc=bots@golang.org\n")
+
+	testMain(t, "mail", "-n", "-no-cc-watchers")
+	testPrintedStderr(t, "git push -q origin HEAD:refs/for/main\n")
+}
+
+func TestMailOwners(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "work")
+	mkdir(t, gt.client+"/dir")
+	mkdir(t, gt.client+"/dir/sub")
+	write(t, gt.client+"/OWNERS", "root@golang.org\ngopher@example.com\n", 0644)
+	write(t, gt.client+"/dir/OWNERS", "# comment\ndir@golang.org # lead\nper-file *.go=go@golang.org\nfile://other/OWNERS\n*\n", 0644)
+	write(t, gt.client+"/dir/sub/OWNERS", "set noparent\nsub@golang.org\n", 0644)
+	write(t, gt.client+"/dir/sub/b.go", "package b\n", 0644)
+	write(t, gt.client+"/codereview.cfg", "reviewers-file-default: OWNERS\n", 0644)
+	trun(t, gt.client, "git", "add", ".")
+	trun(t, gt.client, "git", "commit", "-q", "-m", "add owners")
+
+	write(t, gt.client+"/dir/a.go", "package dir\n", 0644)
+	write(t, gt.client+"/dir/sub/b.go", "package b // changed\n", 0644)
+	trun(t, gt.client, "git", "add", ".")
+	trun(t, gt.client, "git", "commit", "-q", "-m", "msg\n\nChange-Id: I123456789\n")
+
+	testMain(t, "mail", "-n", "HEAD")
+	testPrintedStderr(t,
+		"adding reviewers from OWNERS files: dir@golang.org, root@golang.org, sub@golang.org\n",
+		"git push -q origin HEAD:refs/for/main%r=dir@golang.org,r=root@golang.org,r=sub@golang.org\n")
+
+	testMain(t, "mail", "-n", "-r", "other@golang.org", "HEAD")
+	testPrintedStderr(t, "git push -q origin HEAD:refs/for/main%r=other@golang.org\n")
+	if strings.Contains(testStderr.String(), "adding reviewers") {
+		t.Fatalf("added reviewers despite -r:\n%s", testStderr)
+	}
+
+	write(t, gt.client+"/codereview.cfg", "", 0644)
+	testMain(t, "mail", "-n", "HEAD")
+	testPrintedStderr(t, "git push -q origin HEAD:refs/for/main\n")
+}
+
+func TestMailResolve(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	// Thread 1 (c1, c2) ends unresolved on patch set 2;
+	// thread 2 (c3) is resolved and thread 3 (c4) unresolved on patch set 1.
+	srv.setReply("/a/changes/proj~main~I123456789/comments", gerritReply{json: map[string][]*GerritComment{
+		"file": {
+			{ID: "c1", PatchSet: 1, Line: 3, Message: "fix this", Unresolved: true},
+			{ID: "c2", PatchSet: 2, Line: 4, InReplyTo: "c1", Message: "how?", Unresolved: true},
+			{ID: "c3", PatchSet: 1, Line: 7, Message: "nice"},
+		},
+		"file2": {
+			{ID: "c4", PatchSet: 1, Line: 9, Message: "and this", Unresolved: true},
+		},
+	}})
+	for _, rev := range []string{"1", "2"} {
+		srv.setReply("/a/changes/proj~main~I123456789/revisions/"+rev+"/review", gerritReply{body: ")]}'\n{}"})
+	}
+	posted := func(rev string) GerritReviewInput {
+		t.Helper()
+		path := "/a/changes/proj~main~I123456789/revisions/" + rev + "/review"
+		var review GerritReviewInput
+		if err := json.Unmarshal([]byte(srv.posted[path]), &review); err != nil {
+			t.Fatal(err)
+		}
+		delete(srv.posted, path)
+		return review
+	}
+
+	testMainDied(t, "mail", "-resolve", "-unresolved")
+	testPrintedStderr(t, "Usage")
+
+	testMain(t, "mail", "-resolve")
+	testPrintedStderr(t, "marked 2 comment threads resolved")
+	review := posted("2")
+	list := review.Comments["file"]
+	if len(review.Comments) != 1 || len(list) != 1 || list[0].InReplyTo != "c2" || list[0].Line != 4 || list[0].Unresolved == nil || *list[0].Unresolved {
+		t.Fatalf("posted wrong review to patch set 2: %+v", review)
+	}
+	review = posted("1")
+	list = review.Comments["file2"]
+	if len(review.Comments) != 1 || len(list) != 1 || list[0].InReplyTo != "c4" || list[0].Line != 9 || list[0].Unresolved == nil || *list[0].Unresolved {
+		t.Fatalf("posted wrong review to patch set 1: %+v", review)
+	}
+
+	testMain(t, "mail", "-unresolved")
+	testPrintedStderr(t, "marked 1 comment thread unresolved")
+	review = posted("1")
+	list = review.Comments["file"]
+	if len(review.Comments) != 1 || len(list) != 1 || list[0].InReplyTo != "c3" || list[0].Line != 7 || list[0].Unresolved == nil || !*list[0].Unresolved {
+		t.Fatalf("posted wrong review to patch set 1: %+v", review)
+	}
+	if _, ok := srv.posted["/a/changes/proj~main~I123456789/revisions/2/review"]; ok {
+		t.Fatalf("posted review to patch set 2 for thread on patch set 1")
+	}
+}
+
+func TestMailNoRenotify(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	// A new CL gets its reviewers.
+	testMain(t, "mail", "-no-renotify", "-r", "r@golang.org", "-cc", "cc@golang.org")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main%r=r@golang.org,cc=cc@golang.org",
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+
+	// An existing CL does not, but still gets its CCs.
+	srv.setJSON("I123456789", `{"_number": 1234, "status": "NEW"}`)
+	testMain(t, "mail", "-no-renotify", "-r", "r@golang.org", "-cc", "cc@golang.org")
+	testPrintedStderr(t, "CL already exists; not adding reviewers: r@golang.org")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main%cc=cc@golang.org",
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+
+	// Without -no-renotify, the reviewers are added again.
+	testMain(t, "mail", "-r", "r@golang.org")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main%r=r@golang.org",
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+}
+
+func TestMailPickReviewers(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	// fake auth information to avoid Gerrit error
+	auth.initialized = true
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.initialized = false
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	trun(t, gt.server, "git", "commit", "--allow-empty", "-m", "a\n\nReviewed-by: R1 <r1@golang.org>\nReviewed-by: R2 <r2@golang.org>\n")
+	trun(t, gt.server, "git", "commit", "--allow-empty", "-m", "b\n\nReviewed-by: R2 <r2@golang.org>\n")
+	trun(t, gt.client, "git", "pull", "-q", "-r", "origin", "main")
+	reviewers = nil
+	defer func() { reviewers = nil }()
+
+	os.Setenv("GIT_EDITOR", "sed -i.bak -e 's/^# r1@/r1@/'")
+	defer os.Unsetenv("GIT_EDITOR")
+
+	defer func(f func() bool) { stdinIsTerminal = f }(stdinIsTerminal)
+	stdinIsTerminal = func() bool { return false }
+	testMainDied(t, "mail", "-pick-reviewers", "-r", "r3@golang.org")
+	testPrintedStderr(t, "standard input is not a terminal", "mail -r")
+	testRan(t)
+
+	stdinIsTerminal = func() bool { return true }
+	testMain(t, "mail", "-pick-reviewers", "-r", "r3@golang.org")
+	h := CurrentBranch().Pending()[0].ShortHash
+	testRan(t, "git push -q origin HEAD:refs/for/main%r=r3@golang.org,r=r1@golang.org",
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+
+	os.Setenv("GIT_EDITOR", "true")
+	testMain(t, "mail", "-pick-reviewers")
+	testPrintedStderr(t, "no reviewers picked")
+	testRan(t, "git push -q origin HEAD:refs/for/main",
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+}
+
+func TestMailVerifyUpload(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	c := CurrentBranch().Pending()[0]
+	srv.setReply("/a/changes/proj~main~I123456789", gerritReply{body: ")]}'\n" + `{"_number": 1234, "current_revision": "` + c.Hash + `"}`})
+	testMain(t, "mail", "-verify-upload")
+	testPrintedStderr(t, "!warning")
+
+	srv.setReply("/a/changes/proj~main~I123456789", gerritReply{body: ")]}'\n" + `{"_number": 1234, "current_revision": "1111111111111111111111111111111111111111"}`})
+	testMain(t, "mail")
+	testPrintedStderr(t, "!warning")
+
+	write(t, gt.client+"/codereview.cfg", "verify-upload: true\n", 0644)
+	testMain(t, "mail")
+	testPrintedStderr(t, "warning: current revision of CL 1234 is 1111111, not the uploaded "+c.ShortHash,
+		"rewritten or rejected")
+}
+
+func TestMailRemoveReviewer(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	removed := false
+	srv.setReply("/a/changes/proj~main~I123456789/reviewers/old@golang.org", gerritReply{f: func() gerritReply {
+		removed = true
+		return gerritReply{status: 204}
+	}})
+
+	testMain(t, "mail", "-r", "-old@golang.org,new@golang.org")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main%r=new@golang.org",
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+	testPrintedStderr(t, "removed reviewer old@golang.org")
+	if !removed {
+		t.Fatalf("reviewer not removed")
+	}
+
+	removed = false
+	testMain(t, "mail", "-r", "-old@golang.org", "-cc", "cc@golang.org")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main%cc=cc@golang.org",
+		"git tag --no-sign --create-reflog -f work.mailed "+h)
+	if !removed {
+		t.Fatalf("reviewer not removed")
+	}
+
+	testMainDied(t, "mail", "-r", "-missing@golang.org")
+	testPrintedStderr(t, "cannot remove reviewer missing@golang.org")
+}
+
+func TestMailChangeIDFrom(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	// fake auth information to avoid Gerrit error
+	auth.initialized = true
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.initialized = false
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	// Recreate the change without its Change-Id, as a fresh cherry-pick might.
+	old := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+	trun(t, gt.client, "git", "commit", "--amend", "--no-verify", "-m", "dir: recreated change\n")
+
+	testMainDied(t, "mail", "-change-id-from", "origin/main", "HEAD")
+	testPrintedStderr(t, "cannot mail: origin/main has no Change-Id")
+
+	// A failed check leaves the commit alone.
+	trun(t, gt.client, "git", "commit", "--amend", "--no-verify", "-m", "dir: recreated change\n\nDO NOT MAIL\n")
+	recreated := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+	testMainDied(t, "mail", "-change-id-from", old, "HEAD")
+	testPrintedStderr(t, "DO NOT MAIL")
+	testRan(t)
+	if head := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD")); head != recreated {
+		t.Fatalf("mail -change-id-from amended HEAD despite failing")
+	}
+	trun(t, gt.client, "git", "commit", "--amend", "--no-verify", "-m", "dir: recreated change\n")
+
+	testMain(t, "mail", "-change-id-from", old, "HEAD")
+	testPrintedStderr(t, "copied Change-Id I123456789 from "+old)
+	msg := trun(t, gt.client, "git", "log", "-n", "1", "--format=%B", "HEAD")
+	if want := "dir: recreated change\n\nChange-Id: I123456789\n"; strings.TrimSpace(msg) != strings.TrimSpace(want) {
+		t.Errorf("commit message after mail -change-id-from:\n%s\nwant:\n%s", msg, want)
+	}
+	head := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+	testRan(t,
+		"git commit -q --amend --only --no-verify -m dir: recreated change\n\nChange-Id: I123456789",
+		"git push -q origin HEAD:refs/for/main",
+		"git tag --no-sign --create-reflog -f work.mailed "+head[:7])
+
+	// A commit that has a different Change-Id is left alone.
+	trun(t, gt.client, "git", "commit", "--amend", "--no-verify", "-m", "dir: recreated change\n\nChange-Id: I999999999\n")
+	testMainDied(t, "mail", "-n", "-change-id-from", old, "HEAD")
+	testPrintedStderr(t, "already has Change-Id I999999999")
+}
+
+func TestMailCL(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	// A fresh clone's commit-msg hook gave the commit a new Change-Id.
+	trun(t, gt.client, "git", "commit", "--amend", "--no-verify", "-m", "dir: lost change\n\nChange-Id: I999999999\n")
+
+	testMainDied(t, "mail", "-cl", "1234", "-change-id-from", "HEAD")
+	testPrintedStderr(t, "Usage")
+	testMainDied(t, "mail", "-cl", "abc")
+	testPrintedStderr(t, `cannot mail: invalid CL number "abc"`)
+	testMainDied(t, "mail", "-cl", "1234")
+	testPrintedStderr(t, "cannot mail: reading CL 1234")
+
+	srv.setReply("/a/changes/1234", gerritReply{body: ")]}'\n" + `{"_number": 1234, "change_id": "I123456789"}`})
+
+	// A failed check leaves the commit alone,
+	// and staged changes are never folded into it.
+	lost := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+	write(t, gt.client+"/file", "staged", 0644)
+	trun(t, gt.client, "git", "add", "file")
+	testMainDied(t, "mail", "-cl", "1234")
+	testPrintedStderr(t, "there are staged changes")
+	testRan(t)
+	if head := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD")); head != lost {
+		t.Fatalf("mail -cl amended HEAD despite failing")
+	}
+	testMain(t, "mail", "-f", "-cl", "1234")
+	testPrintedStderr(t, "copied Change-Id I123456789 from CL 1234")
+	if out := trun(t, gt.client, "git", "diff", "--cached", "--name-only"); strings.TrimSpace(out) != "file" {
+		t.Fatalf("staged changes after mail -f -cl = %q, want file", out)
+	}
+	trun(t, gt.client, "git", "reset", "-q", "--hard", lost)
+
+	testMain(t, "mail", "-cl", "1234")
+	testPrintedStderr(t, "copied Change-Id I123456789 from CL 1234")
+	msg := trun(t, gt.client, "git", "log", "-n", "1", "--format=%B", "HEAD")
+	if want := "dir: lost change\n\nChange-Id: I123456789\n"; strings.TrimSpace(msg) != strings.TrimSpace(want) {
+		t.Errorf("commit message after mail -cl:\n%s\nwant:\n%s", msg, want)
+	}
+	head := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+	testRan(t,
+		"git commit -q --amend --only --no-verify -m dir: lost change\n\nChange-Id: I123456789",
+		"git push -q origin HEAD:refs/for/main",
+		"git tag --no-sign --create-reflog -f work.mailed "+head[:7])
+
+	// Mailing again leaves the commit alone.
+	testMain(t, "mail", "-cl", "1234")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main",
+		"git tag --no-sign --create-reflog -f work.mailed "+head[:7])
+}
+
+func TestMailDependsOn(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	testMainDied(t, "mail", "-depends-on", "abc")
+	testPrintedStderr(t, `cannot mail: invalid CL number "abc"`)
+
+	// Without a Change-Id, there is nothing to record the dependency under.
+	msg := trun(t, gt.client, "git", "log", "-n", "1", "--format=%B")
+	trun(t, gt.client, "git", "commit", "-q", "--amend", "--no-verify", "-m", "no change id\n")
+	testMainDied(t, "mail", "-depends-on", "1234")
+	testPrintedStderr(t, "cannot mail: -depends-on needs a Change-Id")
+	testRan(t)
+	trun(t, gt.client, "git", "commit", "-q", "--amend", "--no-verify", "-m", msg)
+
+	testMainDied(t, "mail", "-depends-on", "1234")
+	testPrintedStderr(t, "cannot mail: reading CL 1234")
+
+	base := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD~1"))
+	srv.setReply("/a/changes/1234", gerritReply{body: ")]}'\n" + `{"_number": 1234, "current_revision": "` + base + `"}`})
+	testMain(t, "mail", "-depends-on", "1234")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main%base="+base,
+		"git tag --no-sign --create-reflog -f work.mailed "+CurrentBranch().Pending()[0].ShortHash,
+		"git config codereview.I123456789.dependson 1234")
+
+	// The dependency is remembered.
+	testMain(t, "mail", "HEAD")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main%base="+base,
+		"git tag --no-sign --create-reflog -f work.mailed "+CurrentBranch().Pending()[0].ShortHash)
+
+	testMain(t, "mail", "-depends-on", "none", "HEAD")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main",
+		"git tag --no-sign --create-reflog -f work.mailed "+CurrentBranch().Pending()[0].ShortHash,
+		"git config --unset codereview.I123456789.dependson")
+	testMain(t, "mail", "HEAD")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/main",
+		"git tag --no-sign --create-reflog -f work.mailed "+CurrentBranch().Pending()[0].ShortHash)
+}
+
+func TestMailPushWarnings(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	// The server's hook output reaches the client as "remote:" lines.
+	write(t, gt.server+"/.git/hooks/pre-receive", "#!/bin/sh\necho 'Processing changes' >&2\necho 'warning: 1234567: large file added' >&2\n", 0755)
+
+	testMain(t, "mail")
+	testPrintedStderr(t, "remote: Processing changes",
+		"warning: Gerrit reported:\n\twarning: 1234567: large file added")
+	testPrintedStderr(t, "!reported:\n\tProcessing")
+
+	// Errors are still fatal.
+	write(t, gt.server+"/.git/hooks/pre-receive", "#!/bin/sh\necho 'error: rejected' >&2\nexit 1\n", 0755)
+	gt.work(t)
+	testMainDied(t, "mail", "HEAD")
+	testPrintedStderr(t, "remote: error: rejected", "(running: git push -q origin HEAD:refs/for/main)")
+}
@@ -0,0 +1,59 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+)
+
+func cmdOpen(args []string) {
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s open %s [commit]\n", progName, globalFlags)
+		exit(2)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) > 1 {
+		flags.Usage()
+		exit(2)
+	}
+
+	b := CurrentBranch()
+	var c *Commit
+	if len(flags.Args()) == 1 {
+		c = b.CommitByRev("open", flags.Arg(0))
+	} else {
+		c = b.DefaultCommit("open", "must specify commit on command line")
+	}
+
+	g, err := b.GerritChange(c)
+	if err != nil {
+		var gerr *gerritError
+		if errors.As(err, &gerr) && gerr.statusCode == http.StatusNotFound {
+			dief("%s has not been mailed; use '%s mail' to create a CL", c.ShortHash, progName)
+		}
+		dief("%v", err)
+	}
+
+	url := fmt.Sprintf("%s/c/%s/+/%d", auth.url, auth.project, g.Number)
+	command, cmdArgs := browserCommand(url)
+	if err := runErr(command, cmdArgs...); err != nil {
+		dief("cannot open browser: %v\n%s", err, url)
+	}
+}
+
+// browserCommand returns the command line that opens url in the
+// default web browser. It is a variable so that tests can replace it.
+var browserCommand = func(url string) (string, []string) {
+	switch runtime.GOOS {
+	case "darwin":
+		return "open", []string{url}
+	case "windows":
+		return "cmd", []string{"/c", "start", "", url}
+	}
+	return "xdg-open", []string{url}
+}
@@ -0,0 +1,29 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestOpen(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	defer func(f func(string) (string, []string)) { browserCommand = f }(browserCommand)
+	browserCommand = func(url string) (string, []string) {
+		return "echo", []string{url}
+	}
+
+	testMainDied(t, "open")
+	testPrintedStderr(t, "has not been mailed; use 'git-codereview mail' to create a CL")
+	testRan(t)
+
+	srv.setJSON("I123456789", `{"_number": 1234}`)
+	testMain(t, "open")
+	testRan(t, "echo "+auth.url+"/c/proj/+/1234")
+}
@@ -9,17 +9,32 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var (
-	pendingLocal       bool // -l flag, use only local operations (no network)
-	pendingCurrentOnly bool // -c flag, show only current branch
-	pendingShort       bool // -s flag, short display
+	pendingLocal       bool          // -l flag, use only local operations (no network)
+	pendingNoFetch     bool          // -no-fetch flag, query Gerrit but do not fetch from origin or check upstreams
+	pendingCurrentOnly bool          // -c flag, show only current branch
+	pendingShort       bool          // -s flag, short display
+	pendingOneline     bool          // -oneline flag, one line per branch and per change
+	pendingSummary     bool          // -summary flag, print total counts at end
+	pendingMaxBehind   int           // -max-behind flag, mark branches further behind as stale
+	pendingQuiet       bool          // -q flag, report current branch state in exit status only
+	pendingNew         bool          // -new flag, show comments added since the last pending -new
+	pendingColorWhen   string        // -color flag, when to color the output
+	pendingWatch       int           // -watch flag, seconds between refreshes
+	pendingURLOnly     bool          // -url-only flag, print only the URLs of mailed changes
+	pendingSince       time.Duration // -since flag, hide branches inactive for longer
 )
 
+// pendingWatchSleep is time.Sleep, replaced by tests.
+var pendingWatchSleep = time.Sleep
+
 // A pendingBranch collects information about a single pending branch.
 // We overlap the reading of this information for each branch.
 type pendingBranch struct {
@@ -28,6 +43,9 @@ type pendingBranch struct {
 	staged    []string // files in staging area, only if current==true
 	unstaged  []string // files unstaged in local directory, only if current==true
 	untracked []string // files untracked in local directory, only if current==true
+
+	upstreamDeleted bool      // origin branch no longer exists on server
+	lastActive      time.Time // newest pending commit or Gerrit update, only if pendingSince > 0
 }
 
 // load populates b with information about the branch.
@@ -53,7 +71,9 @@ func (b *pendingBranch) load() {
 		}
 	}
 	if !pendingLocal {
-		gs, err := b.GerritChanges(changeIDs, "DETAILED_LABELS", "CURRENT_REVISION", "MESSAGES", "DETAILED_ACCOUNTS")
+		// ALL_REVISIONS, to tell an older patch set from a revision
+		// the server does not have at all.
+		gs, err := b.GerritChanges(changeIDs, "DETAILED_LABELS", "ALL_REVISIONS", "MESSAGES", "DETAILED_ACCOUNTS")
 		if len(gs) != len(commits) && err == nil {
 			err = fmt.Errorf("invalid response from Gerrit server - %d queries but %d results", len(changeIDs), len(gs))
 		}
@@ -76,25 +96,141 @@ func (b *pendingBranch) load() {
 			c.g = new(GerritChange) // easier for formatting code
 		}
 	}
+	b.markUnmailedEdits()
+	if pendingSince > 0 {
+		b.lastActive = b.activity()
+	}
+}
+
+// activity returns the time of the most recent activity on b:
+// the committer date of its newest pending commit,
+// or the last update on Gerrit of any of its changes, if later.
+func (b *pendingBranch) activity() time.Time {
+	var t time.Time
+	if out, err := cmdOutputErr("git", "log", "-n", "1", "--format=%ct", b.FullName(), "--"); err == nil {
+		if sec, err := strconv.ParseInt(trim(out), 10, 64); err == nil {
+			t = time.Unix(sec, 0)
+		}
+	}
+	for _, c := range b.Pending() {
+		// Gerrit timestamps are UTC, with nanoseconds: "2006-01-02 15:04:05.000000000".
+		if c.g == nil || c.g.Updated == "" {
+			continue
+		}
+		u, err := time.Parse("2006-01-02 15:04:05.999999999", c.g.Updated)
+		if err == nil && u.After(t) {
+			t = u
+		}
+	}
+	return t
+}
+
+// markUnmailedEdits sets c.unmailedEdits for each pending commit c
+// whose change has been mailed from this branch, but not as c,
+// as when c has been amended since it was last mailed.
+// A mailed commit with c's tree and message counts as c,
+// since mail -squash uploads such a commit in place of c.
+// The mail command records what it mailed in the <branch>.mailed tag,
+// creating a reflog for the tag so that earlier mailings are recorded too.
+// Each mailing uploads the named commit and the pending commits below it,
+// so all of those count as mailed.
+func (b *pendingBranch) markUnmailedEdits() {
+	tag := "refs/tags/" + b.Name + ".mailed"
+	if _, err := cmdOutputErr("git", "rev-parse", "-q", "--verify", tag); err != nil {
+		return
+	}
+	revs := []string{tag}
+	if out, err := cmdOutputErr("git", "reflog", "show", "--format=%H", tag, "--"); err == nil {
+		revs = append(revs, nonBlankLines(out)...)
+	}
+	// Old mailed commits may have been garbage collected; skip them.
+	args := append([]string{"log", "--ignore-missing", "--format=format:%H%x00%T%x00%B%x00"}, revs...)
+	if b.Branchpoint() != "" {
+		args = append(args, "^"+b.Branchpoint())
+	}
+	args = append(args, "--")
+	out, err := cmdOutputErr("git", args...)
+	if err != nil {
+		verbosef("reading mailed commits: %v", err)
+		return
+	}
+
+	mailedHash := make(map[string]bool)
+	mailedContent := make(map[string]bool) // tree and message
+	mailedID := make(map[string]bool)
+	fields := strings.Split(trim(out), "\x00")
+	for i := 0; i+3 <= len(fields); i += 3 {
+		mailedHash[strings.TrimLeft(fields[i], "\r\n")] = true
+		mailedContent[fields[i+1]+"\x00"+trim(fields[i+2])] = true
+		for _, line := range lines(fields[i+2]) {
+			if strings.HasPrefix(line, "Change-Id: ") {
+				mailedID[line[len("Change-Id: "):]] = true
+			}
+		}
+	}
+	for _, c := range b.Pending() {
+		c.unmailedEdits = c.ChangeID != "" && mailedID[c.ChangeID] &&
+			!mailedHash[c.Hash] && !mailedContent[c.Tree+"\x00"+trim(c.Message)]
+	}
 }
 
 func cmdPending(args []string) {
 	// NOTE: New flags should be added to the usage message below as well as doc.go.
 	flags.BoolVar(&pendingCurrentOnly, "c", false, "show only current branch")
 	flags.BoolVar(&pendingLocal, "l", false, "use only local information - no network operations")
+	flags.BoolVar(&pendingNoFetch, "no-fetch", false, "do not fetch from origin or check for deleted upstream branches, but still query Gerrit")
 	flags.BoolVar(&pendingShort, "s", false, "show short listing")
+	flags.BoolVar(&pendingOneline, "oneline", false, "show one line per branch and per change")
+	flags.BoolVar(&pendingSummary, "summary", false, "print a line of total counts after the listing")
+	flags.IntVar(&pendingMaxBehind, "max-behind", 0, "mark branches more than `n` commits behind as STALE")
+	flags.BoolVar(&pendingQuiet, "q", false, "print nothing; report current branch state in exit status")
+	flags.BoolVar(&pendingNew, "new", false, "show counts of comments added since the last pending -new")
+	flags.StringVar(&pendingColorWhen, "color", "auto", "color the output `when`: always, never, or auto")
+	flags.IntVar(&pendingWatch, "watch", 0, "refresh the listing every `n` seconds until interrupted")
+	flags.BoolVar(&pendingURLOnly, "url-only", false, "print only the URLs of the mailed changes, one per line")
+	flags.DurationVar(&pendingSince, "since", 0, "show only branches active within the last `duration`, like 72h")
 	flags.Parse(args)
-	if len(flags.Args()) > 0 {
-		fmt.Fprintf(stderr(), "Usage: %s pending %s [-c] [-l] [-s]\n", progName, globalFlags)
+	if len(flags.Args()) > 0 || pendingShort && pendingOneline || pendingLocal && pendingNoFetch ||
+		pendingColorWhen != "always" && pendingColorWhen != "never" && pendingColorWhen != "auto" ||
+		pendingWatch < 0 || pendingWatch > 0 && pendingQuiet || pendingSince < 0 ||
+		pendingURLOnly && (pendingQuiet || pendingWatch > 0 || pendingLocal) {
+		fmt.Fprintf(stderr(), "Usage: %s pending %s [-c] [-color when] [-l | -no-fetch] [-max-behind n] [-new] [-q | -url-only | -watch n] [-s | -oneline] [-since duration] [-summary]\n", progName, globalFlags)
 		exit(2)
 	}
+	if pendingQuiet {
+		pendingCurrentOnly = true
+	}
+
+	if pendingWatch == 0 {
+		stdout().Write(pendingListing())
+		return
+	}
+	// Clear the screen before each listing, if it is a terminal,
+	// writing the whole update at once to avoid flicker.
+	clear := ""
+	if stdoutIsTerminal() {
+		clear = "\x1b[H\x1b[2J"
+	}
+	for {
+		out := pendingListing()
+		stdout().Write(append([]byte(clear), out...))
+		pendingWatchSleep(time.Duration(pendingWatch) * time.Second)
+	}
+}
 
+// pendingListing gathers the information about the pending branches
+// and returns the pending command's listing. With -q, it instead
+// exits with the status describing the current branch, or returns nil.
+func pendingListing() []byte {
 	// Fetch info about remote changes, so that we can say which branches need sync.
+	// With -no-fetch, use the origin branches as last fetched.
 	doneFetch := make(chan bool, 1)
-	if pendingLocal {
+	if !pendingLocal {
+		http.DefaultClient.Timeout = 60 * time.Second
+	}
+	if pendingLocal || pendingNoFetch {
 		doneFetch <- true
 	} else {
-		http.DefaultClient.Timeout = 60 * time.Second
 		go func() {
 			run("git", "fetch", "-q")
 			doneFetch <- true
@@ -149,6 +285,55 @@ func cmdPending(args []string) {
 	}
 	<-doneFetch
 
+	// Without a fresh fetch, FETCH_HEAD may list only some of the
+	// origin branches (after git pull, for example), so -no-fetch
+	// cannot tell which upstream branches have been deleted.
+	if !pendingLocal && !pendingNoFetch {
+		markUpstreamDeleted(branches)
+	}
+
+	// Hide branches with no recent activity. The current branch always stays.
+	if pendingSince > 0 {
+		cutoff := time.Now().Add(-pendingSince)
+		active := branches[:1]
+		for _, b := range branches[1:] {
+			if !b.lastActive.Before(cutoff) {
+				active = append(active, b)
+			}
+		}
+		branches = active
+	}
+
+	if pendingQuiet {
+		b := branches[0]
+		switch {
+		case !allMailed(b.Pending()):
+			verbosef("%s has unmailed commits", b.Name)
+			exit(1)
+		case b.CommitsBehind() > 0:
+			verbosef("%s is behind %s", b.Name, b.OriginBranch())
+			exit(2)
+		}
+		verbosef("%s is up to date", b.Name)
+		return nil
+	}
+
+	if pendingURLOnly {
+		var buf bytes.Buffer
+		for _, b := range branches {
+			for _, c := range b.Pending() {
+				if c.g != nil && c.g.Number != 0 {
+					fmt.Fprintf(&buf, "%s/%d\n", auth.url, c.g.Number)
+				}
+			}
+		}
+		return buf.Bytes()
+	}
+
+	if pendingNew && !pendingLocal {
+		markNewComments(branches)
+	}
+
 	// Print output.
 	// If there are multiple changes in the current branch, the output splits them out into separate sections,
 	// in reverse commit order, to match git log output.
@@ -210,7 +395,14 @@ func cmdPending(args []string) {
 	//			src/runtime/proc1.go
 	//	+ a496c1e runtime: add missing write barriers in append's copy of slice data (CL 2064, mailed)
 	//	+ 95390c7 runtime: add GODEBUG wbshadow for finding missing write barriers (CL 2061, mailed)
+	//
+	// The oneline view drops the file lists and blank lines, noting uncommitted
+	// changes and the number of pending commits on the branch line instead:
+	//	wbshadow 7a524a1..a496c1e (current branch, 2 pending, uncommitted changes, all mailed, 23 behind, tracking master)
+	//	+ a496c1e runtime: add missing write barriers in append's copy of slice data (CL 2064, mailed)
+	//	+ 95390c7 runtime: add GODEBUG wbshadow for finding missing write barriers (CL 2061, mailed)
 
+	color := pendingUseColor(pendingColorWhen)
 	var buf bytes.Buffer
 	printFileList := func(name string, list []string) {
 		if len(list) == 0 {
@@ -228,9 +420,11 @@ func cmdPending(args []string) {
 			continue
 		}
 
-		fmt.Fprintf(&buf, "%s", b.Name)
+		fmt.Fprintf(&buf, "%s", colorize(color, ansiBold, b.Name))
 		work := b.Pending()
-		if len(work) > 0 {
+		if len(work) > 0 && b.branchpoint == "" {
+			fmt.Fprintf(&buf, " %s", work[0].ShortHash)
+		} else if len(work) > 0 {
 			fmt.Fprintf(&buf, " %.7s..%s", b.branchpoint, work[0].ShortHash)
 		}
 		var tags []string
@@ -239,26 +433,46 @@ func cmdPending(args []string) {
 		} else if b.current {
 			tags = append(tags, "current branch")
 		}
+		if pendingOneline {
+			tags = append(tags, fmt.Sprintf("%d pending", len(work)))
+			if b.current && len(b.staged)+len(b.unstaged)+len(b.untracked) > 0 {
+				tags = append(tags, "uncommitted changes")
+			}
+		}
 		if allMailed(work) && len(work) > 0 {
-			tags = append(tags, "all mailed")
+			tags = append(tags, colorize(color, ansiYellow, "all mailed"))
 		}
 		if allSubmitted(work) && len(work) > 0 {
-			tags = append(tags, "all submitted")
+			tags = append(tags, colorize(color, ansiGreen, "all submitted"))
 		}
 		if n := b.CommitsBehind(); n > 0 {
-			tags = append(tags, fmt.Sprintf("%d behind", n))
+			tags = append(tags, colorize(color, ansiRed, fmt.Sprintf("%d behind", n)))
+			if pendingMaxBehind > 0 && n > pendingMaxBehind {
+				tags = append(tags, colorize(color, ansiRed, "STALE"))
+			}
 		}
 		if br := b.OriginBranch(); br == "" {
 			tags = append(tags, "remote branch unknown")
 		} else if br != "origin/master" && br != "origin/main" {
 			tags = append(tags, "tracking "+strings.TrimPrefix(b.OriginBranch(), "origin/"))
 		}
+		if b.upstreamDeleted {
+			tags = append(tags, "upstream deleted")
+		}
 		if len(tags) > 0 {
 			fmt.Fprintf(&buf, " (%s)", strings.Join(tags, ", "))
 		}
 		fmt.Fprintf(&buf, "\n")
 		printed := false
 
+		if pendingOneline {
+			for _, c := range work {
+				fmt.Fprintf(&buf, "+ ")
+				formatCommit(&buf, c, true, color)
+			}
+			continue
+		}
+
 		if b.current && len(b.staged)+len(b.unstaged)+len(b.untracked) > 0 {
 			printed = true
 			fmt.Fprintf(&buf, "+ uncommitted changes\n")
@@ -273,7 +487,7 @@ func cmdPending(args []string) {
 		for _, c := range work {
 			printed = true
 			fmt.Fprintf(&buf, "+ ")
-			formatCommit(&buf, c, pendingShort)
+			formatCommit(&buf, c, pendingShort, color)
 			if !pendingShort {
 				printFileList("in this change", c.committed)
 				fmt.Fprintf(&buf, "\n")
@@ -284,7 +498,112 @@ func cmdPending(args []string) {
 		}
 	}
 
-	stdout().Write(buf.Bytes())
+	if pendingSummary {
+		pendingSummaryLine(&buf, branches)
+	}
+
+	return buf.Bytes()
+}
+
+// pendingSummaryLine writes to w a line of total counts
+// for the displayed branches and their pending changes, like
+//
+//	5 branches, 12 pending CLs (8 mailed, 2 submitted), 3 behind upstream
+func pendingSummaryLine(w io.Writer, branches []*pendingBranch) {
+	var nbranch, npending, nmailed, nsubmitted, nbehind int
+	for _, b := range branches {
+		if !b.current && b.commitsAhead == 0 {
+			continue // not displayed
+		}
+		nbranch++
+		if b.CommitsBehind() > 0 {
+			nbehind++
+		}
+		for _, c := range b.Pending() {
+			npending++
+			if c.g.CurrentRevision == c.Hash {
+				nmailed++
+			}
+			if c.g.Status == "MERGED" {
+				nsubmitted++
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d branch%s, %d pending CL%s (%d mailed, %d submitted), %d behind upstream\n",
+		nbranch, suffix(nbranch, "es"), npending, suffix(npending, "s"), nmailed, nsubmitted, nbehind)
+}
+
+// markUpstreamDeleted sets upstreamDeleted for each branch in branches
+// whose origin branch was not found by the fetch that pending just ran,
+// meaning the branch has been deleted on the server.
+func markUpstreamDeleted(branches []*pendingBranch) {
+	fetched := make(map[string]bool)
+	for _, name := range FetchedOriginBranches() {
+		fetched[name] = true
+	}
+	if len(fetched) == 0 {
+		// Nothing known about the server.
+		return
+	}
+	for _, b := range branches {
+		if !b.current && b.commitsAhead == 0 {
+			continue // not displayed
+		}
+		if !b.DetachedHead() && b.OriginBranch() != "" {
+			b.upstreamDeleted = !fetched[b.OriginBranch()]
+		}
+	}
+}
+
+// pendingCommentsFile is the name of the file, relative to the git directory,
+// in which pending -new records the comment count last seen for each change.
+// Each line has the form "Change-Id count".
+const pendingCommentsFile = "codereview-pending-comments"
+
+// markNewComments sets newComments for each pending commit in branches
+// whose Gerrit change has more comments than when pending -new last ran,
+// and then records the current counts for next time.
+// Changes seen for the first time are recorded but not marked.
+func markNewComments(branches []*pendingBranch) {
+	file := gitPath(pendingCommentsFile)
+	seen := make(map[string]int)
+	if data, err := os.ReadFile(file); err == nil {
+		for _, line := range nonBlankLines(string(data)) {
+			f := strings.Fields(line)
+			if len(f) != 2 {
+				continue
+			}
+			if n, err := strconv.Atoi(f[1]); err == nil {
+				seen[f[0]] = n
+			}
+		}
+	}
+
+	for _, b := range branches {
+		for _, c := range b.Pending() {
+			if c.ChangeID == "" || c.g == nil || c.g.Number == 0 {
+				continue
+			}
+			n := c.g.TotalCommentCount
+			if old, ok := seen[c.ChangeID]; ok && n > old {
+				c.newComments = n - old
+			}
+			seen[c.ChangeID] = n
+		}
+	}
+
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var buf bytes.Buffer
+	for _, id := range ids {
+		fmt.Fprintf(&buf, "%s %d\n", id, seen[id])
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0666); err != nil {
+		printf("warning: cannot record comment counts: %v", err)
+	}
 }
 
 // formatCommit writes detailed information about c to w. c.g must
@@ -295,7 +614,9 @@ func cmdPending(args []string) {
 //
 // If short is false, this writes detailed information about the
 // commit and its Gerrit state.
-func formatCommit(w io.Writer, c *Commit, short bool) {
+//
+// If color is true, the mailed and submitted tags are colored.
+func formatCommit(w io.Writer, c *Commit, short, color bool) {
 	g := c.g
 	if g == nil {
 		g = new(GerritChange)
@@ -317,11 +638,11 @@ func formatCommit(w io.Writer, c *Commit, short bool) {
 		}
 	}
 	if g.CurrentRevision == c.Hash {
-		tags = append(tags, "mailed")
+		tags = append(tags, colorize(color, ansiYellow, "mailed"))
 	}
 	switch g.Status {
 	case "MERGED":
-		tags = append(tags, "submitted")
+		tags = append(tags, colorize(color, ansiGreen, "submitted"))
 	case "ABANDONED":
 		tags = append(tags, "abandoned")
 	}
@@ -335,6 +656,20 @@ func formatCommit(w io.Writer, c *Commit, short bool) {
 	if g.UnresolvedCommentCount > 0 {
 		tags = append(tags, fmt.Sprintf("%d unresolved comments", g.UnresolvedCommentCount))
 	}
+	if g.Topic != "" {
+		tags = append(tags, "topic: "+g.Topic)
+	}
+	if c.newComments > 0 {
+		tags = append(tags, fmt.Sprintf("%d new comments", c.newComments))
+	}
+	if c.unmailedEdits && g.CurrentRevision != c.Hash {
+		tags = append(tags, "local edits not mailed")
+	} else if g.Number != 0 && g.CurrentRevision != c.Hash && g.Revisions != nil && g.Revisions[c.Hash] == nil {
+		// The change exists, but not with this commit in any patch set,
+		// as when it was mailed from another clone or its revisions
+		// were removed from the server.
+		tags = append(tags, "revision not on server")
+	}
 	if len(tags) > 0 {
 		fmt.Fprintf(w, " (%s)", strings.Join(tags, ", "))
 	}
@@ -381,6 +716,52 @@ func formatCommit(w io.Writer, c *Commit, short bool) {
 	}
 }
 
+// ANSI SGR codes for colorize.
+const (
+	ansiBold   = "1"
+	ansiRed    = "31"
+	ansiGreen  = "32"
+	ansiYellow = "33"
+)
+
+// colorize returns s wrapped in the ANSI escape sequences that
+// set and reset the given SGR code if color is true, or else s unchanged.
+func colorize(color bool, code, s string) string {
+	if !color {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[m"
+}
+
+// pendingUseColor reports whether pending should color its output.
+// The -color flag value when may force color always or never.
+// Otherwise, for "auto", pending colors its output only when writing
+// to a terminal, when NO_COLOR is unset, and when Git's color.pending
+// or color.ui configuration allows it.
+func pendingUseColor(when string) bool {
+	switch when {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || !stdoutIsTerminal() {
+		return false
+	}
+	out, err := cmdOutputErr("git", "config", "--get-colorbool", "color.pending", "true")
+	return err == nil && trim(out) == "true"
+}
+
+// stdoutIsTerminal reports whether standard output is a terminal.
+func stdoutIsTerminal() bool {
+	f, ok := stdout().(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
 // codeReviewScores reports the code review scores as tags for the short output.
 //
 // g must have the "DETAILED_LABELS" option set.
@@ -11,6 +11,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestPendingNone(t *testing.T) {
@@ -150,6 +151,206 @@ func TestPendingComplex(t *testing.T) {
 	`)
 }
 
+func TestPendingMaxBehind(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	for i := 0; i < 3; i++ {
+		write(t, gt.server+"/file", fmt.Sprintf("v%d", i), 0644)
+		trun(t, gt.server, "git", "commit", "-a", "-m", fmt.Sprintf("v%d", i))
+	}
+	trun(t, gt.client, "git", "fetch")
+
+	testPendingArgs(t, []string{"-l", "-s", "-max-behind", "3"}, `
+		work REVHASH..REVHASH (current branch, 3 behind)
+		+ REVHASH msg
+
+	`)
+
+	testPendingArgs(t, []string{"-l", "-s", "-max-behind", "2"}, `
+		work REVHASH..REVHASH (current branch, 3 behind, STALE)
+		+ REVHASH msg
+
+	`)
+
+	testMain(t, "change", "other")
+	gt.workFile(t, "otherfile")
+	testPendingArgs(t, []string{"-l", "-oneline", "-summary"}, `
+		other REVHASH..REVHASH (current branch, 1 pending, 3 behind)
+		+ REVHASH msg #2
+		work REVHASH..REVHASH (1 pending, 3 behind)
+		+ REVHASH msg
+		2 branches, 2 pending CLs (0 mailed, 0 submitted), 2 behind upstream
+	`)
+}
+
+func TestPendingSince(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	// Make the commit on work long stale.
+	os.Setenv("GIT_COMMITTER_DATE", "2000-01-01T00:00:00Z")
+	trun(t, gt.client, "git", "commit", "--amend", "-q", "--no-edit")
+	os.Unsetenv("GIT_COMMITTER_DATE")
+
+	testMain(t, "change", "recent")
+	gt.workFile(t, "recentfile")
+	testMain(t, "change", "other")
+	gt.workFile(t, "otherfile")
+
+	testMainDied(t, "pending", "-since", "1 week")
+	testPrintedStderr(t, "invalid value")
+
+	testPendingArgs(t, []string{"-l", "-oneline", "-since", "720h"}, `
+		other REVHASH..REVHASH (current branch, 1 pending)
+		+ REVHASH msg #3
+		recent REVHASH..REVHASH (1 pending)
+		+ REVHASH msg #2
+	`)
+
+	// The current branch is shown however old it is.
+	trun(t, gt.client, "git", "checkout", "-q", "work")
+	testPendingArgs(t, []string{"-l", "-oneline", "-since", "720h"}, `
+		work REVHASH..REVHASH (current branch, 1 pending)
+		+ REVHASH msg
+		other REVHASH..REVHASH (1 pending)
+		+ REVHASH msg #3
+		recent REVHASH..REVHASH (1 pending)
+		+ REVHASH msg #2
+	`)
+
+	// A recent update on Gerrit counts as activity.
+	trun(t, gt.client, "git", "checkout", "-q", "other")
+	srv := newGerritServer(t)
+	defer srv.done()
+	c := (&Branch{Name: "work"}).Pending()[0]
+	srv.setJSON(c.ChangeID, `{"current_revision": "`+c.Hash+`", "status": "NEW", "_number": 1234, "updated": "`+time.Now().UTC().Format("2006-01-02 15:04:05.000000000")+`"}`)
+	testMain(t, "pending", "-oneline", "-since", "720h")
+	testPrintedStdout(t, "msg (CL 1234, mailed)")
+}
+
+func TestPendingColor(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	write(t, gt.server+"/file", "v1", 0644)
+	trun(t, gt.server, "git", "commit", "-a", "-m", "v1")
+	trun(t, gt.client, "git", "fetch")
+
+	testMain(t, "pending", "-l", "-s", "-color", "always")
+	testPrintedStdout(t, "\x1b[1mwork\x1b[m ", "(current branch, \x1b[31m1 behind\x1b[m)")
+
+	// Not a terminal, so auto means no color.
+	testPendingArgs(t, []string{"-l", "-s"}, `
+		work REVHASH..REVHASH (current branch, 1 behind)
+		+ REVHASH msg
+
+	`)
+	testMain(t, "pending", "-l", "-s", "-color", "never")
+	testPrintedStdout(t, "!\x1b")
+
+	testMainDied(t, "pending", "-color", "sometimes")
+	testPrintedStderr(t, "Usage:")
+}
+
+func TestPendingWatch(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	var slept []time.Duration
+	defer func(f func(time.Duration)) { pendingWatchSleep = f }(pendingWatchSleep)
+	pendingWatchSleep = func(d time.Duration) {
+		slept = append(slept, d)
+		if len(slept) == 2 {
+			exit(0) // stand-in for the user's interrupt
+		}
+		gt.work(t)
+	}
+
+	testMainDied(t, "pending", "-l", "-oneline", "-watch", "5")
+	testHideRevHashes(t)
+	testPrintedStdout(t, "work REVHASH..REVHASH (current branch, 1 pending)\n+ REVHASH msg\n"+
+		"work REVHASH..REVHASH (current branch, 2 pending)\n+ REVHASH msg #2\n+ REVHASH msg\n", "!\x1b")
+	if len(slept) != 2 || slept[0] != 5*time.Second {
+		t.Fatalf("slept %v, want [5s 5s]", slept)
+	}
+
+	testMainDied(t, "pending", "-q", "-watch", "5")
+	testPrintedStderr(t, "Usage:")
+}
+
+func TestPendingUpstreamDeleted(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	trun(t, gt.server, "git", "branch", "dev.old")
+	trun(t, gt.client, "git", "fetch", "-q")
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "old", "origin/dev.old")
+	write(t, gt.client+"/file", "old work", 0644)
+	trun(t, gt.client, "git", "commit", "-q", "-a", "-m", "dir: old work")
+
+	testPendingArgs(t, []string{"-c", "-s"}, `
+		old REVHASH..REVHASH (current branch, tracking dev.old)
+		+ REVHASH dir: old work
+
+	`)
+
+	trun(t, gt.server, "git", "branch", "-D", "dev.old")
+	testPendingArgs(t, []string{"-c", "-s"}, `
+		old REVHASH..REVHASH (current branch, tracking dev.old, upstream deleted)
+		+ REVHASH dir: old work
+
+	`)
+
+	// Local mode does not know.
+	testPendingArgs(t, []string{"-c", "-l", "-s"}, `
+		old REVHASH..REVHASH (current branch, tracking dev.old)
+		+ REVHASH dir: old work
+
+	`)
+}
+
+func TestPendingQuiet(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// fake auth information to avoid Gerrit error
+	auth.initialized = true
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.initialized = false
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	testMain(t, "pending", "-q", "-l")
+	testNoStdout(t)
+	testNoStderr(t)
+
+	testMain(t, "pending", "-q", "-l", "-v")
+	testNoStdout(t)
+	testPrintedStderr(t, "main is up to date")
+
+	write(t, gt.server+"/file", "v2", 0644)
+	trun(t, gt.server, "git", "commit", "-a", "-m", "v2")
+	trun(t, gt.client, "git", "fetch")
+	testMainDied(t, "pending", "-q", "-l", "-v")
+	testNoStdout(t)
+	testPrintedStderr(t, "main is behind origin/main")
+
+	gt.work(t)
+	testMainDied(t, "pending", "-q", "-l")
+	testNoStdout(t)
+	testNoStderr(t)
+	testMainDied(t, "pending", "-q", "-l", "-v")
+	testPrintedStderr(t, "work has unmailed commits")
+}
+
 func TestPendingMultiChange(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
@@ -203,6 +404,15 @@ func TestPendingMultiChange(t *testing.T) {
 		+ REVHASH msg
 
 	`)
+
+	testPendingArgs(t, []string{"-oneline"}, `
+		work REVHASH..REVHASH (current branch, 2 pending, uncommitted changes)
+		+ REVHASH v2
+		+ REVHASH msg
+	`)
+
+	testMainDied(t, "pending", "-s", "-oneline")
+	testPrintedStderr(t, "Usage:")
 }
 
 func TestPendingGerrit(t *testing.T) {
@@ -372,6 +582,178 @@ func TestPendingGerritMultiChange(t *testing.T) {
 	`)
 }
 
+func TestPendingGerritTopic(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	hash := CurrentBranch().Pending()[0].Hash
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	srv.setJSON("I123456789", `{
+		"current_revision": "`+hash+`",
+		"status": "NEW",
+		"topic": "stack",
+		"_number": 1234
+	}`)
+
+	testPendingArgs(t, []string{"-s"}, `
+		work REVHASH..REVHASH (current branch, all mailed)
+		+ REVHASH msg (CL 1234, mailed, topic: stack)
+
+	`)
+}
+
+func TestPendingURLOnly(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	gt.work(t)
+	srv := newGerritServer(t)
+	defer srv.done()
+	for i, c := range CurrentBranch().Pending() {
+		srv.setJSON(c.ChangeID, fmt.Sprintf(`{"current_revision": "%s", "status": "NEW", "_number": %d}`, c.Hash, 1234+i))
+	}
+
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "other", "origin/main")
+	gt.work(t) // not mailed
+
+	testMainDied(t, "pending", "-url-only", "-l")
+	testPrintedStderr(t, "Usage")
+
+	testMain(t, "pending", "-url-only", "-c")
+	testNoStdout(t)
+
+	testMain(t, "pending", "-url-only")
+	testPrintedStdout(t, auth.url+"/1234\n"+auth.url+"/1235\n")
+	testPrintedStdout(t, "!work", "!msg")
+}
+
+func TestPendingNoFetch(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	srv := newGerritServer(t)
+	defer srv.done()
+	c := CurrentBranch().Pending()[0]
+	srv.setJSON(c.ChangeID, `{"current_revision": "`+c.Hash+`", "status": "NEW", "_number": 1234}`)
+	gt.serverWorkUnrelated(t, "")
+
+	testMainDied(t, "pending", "-l", "-no-fetch")
+	testPrintedStderr(t, "Usage")
+
+	// Gerrit is still consulted, but origin is not fetched.
+	testMain(t, "pending", "-s", "-no-fetch")
+	testPrintedStdout(t, "(current branch, all mailed)", "(CL 1234, mailed)")
+	testRan(t)
+
+	testMain(t, "pending", "-s")
+	testPrintedStdout(t, "(current branch, all mailed, 1 behind)", "(CL 1234, mailed)")
+	testRan(t, "git fetch -q")
+
+	// After a sync, FETCH_HEAD lists only main,
+	// but other upstream branches have not been deleted.
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "devwork", "origin/dev.branch")
+	write(t, gt.client+"/file", "dev work", 0644)
+	trun(t, gt.client, "git", "commit", "-q", "-a", "-m", "dir: dev work")
+	trun(t, gt.client, "git", "checkout", "-q", "work")
+	testMain(t, "sync")
+	testMain(t, "pending", "-s", "-no-fetch")
+	testPrintedStdout(t, "devwork ", "(tracking dev.branch)", "!upstream deleted")
+}
+
+func TestPendingRevisionNotOnServer(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	write(t, gt.client+"/file", "v2", 0644)
+	trun(t, gt.client, "git", "commit", "-a", "-m", "v2\n\nChange-Id: I223456789\n")
+	work := CurrentBranch().Pending()
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	// The top commit is an older patch set of its change;
+	// the other commit is not any patch set of its change.
+	srv.setJSON("I223456789", `{
+		"current_revision": "1111111111111111111111111111111111111111",
+		"revisions": {"1111111111111111111111111111111111111111": {}, "`+work[0].Hash+`": {}},
+		"status": "NEW",
+		"_number": 1235
+	}`)
+	srv.setJSON("I123456789", `{
+		"current_revision": "2222222222222222222222222222222222222222",
+		"revisions": {"2222222222222222222222222222222222222222": {}},
+		"status": "NEW",
+		"_number": 1234
+	}`)
+
+	testPendingArgs(t, []string{"-s"}, `
+		work REVHASH..REVHASH (current branch)
+		+ REVHASH v2 (CL 1235)
+		+ REVHASH msg (CL 1234, revision not on server)
+
+	`)
+}
+
+func TestPendingNewComments(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	hash := CurrentBranch().Pending()[0].Hash
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	setComments := func(n int) {
+		srv.setJSON("I123456789", `{
+			"current_revision": "`+hash+`",
+			"status": "NEW",
+			"total_comment_count": `+fmt.Sprint(n)+`,
+			"_number": 1234
+		}`)
+	}
+
+	// The first run only records the counts.
+	setComments(3)
+	testPendingArgs(t, []string{"-s", "-new"}, `
+		work REVHASH..REVHASH (current branch, all mailed)
+		+ REVHASH msg (CL 1234, mailed)
+
+	`)
+
+	setComments(5)
+	testPendingArgs(t, []string{"-s", "-new"}, `
+		work REVHASH..REVHASH (current branch, all mailed)
+		+ REVHASH msg (CL 1234, mailed, 2 new comments)
+
+	`)
+
+	// Without -new, nothing is shown or recorded.
+	setComments(7)
+	testPendingArgs(t, []string{"-s"}, `
+		work REVHASH..REVHASH (current branch, all mailed)
+		+ REVHASH msg (CL 1234, mailed)
+
+	`)
+	testPendingArgs(t, []string{"-s", "-new"}, `
+		work REVHASH..REVHASH (current branch, all mailed)
+		+ REVHASH msg (CL 1234, mailed, 2 new comments)
+
+	`)
+	testPendingArgs(t, []string{"-s", "-new"}, `
+		work REVHASH..REVHASH (current branch, all mailed)
+		+ REVHASH msg (CL 1234, mailed)
+
+	`)
+}
+
 func TestPendingGerritMultiChange15(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
@@ -530,3 +912,55 @@ func diff(b1, b2 []byte) (data []byte, err error) {
 	return
 
 }
+
+func TestPendingUnmailedEdits(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	write(t, gt.client+"/file", "v2", 0644)
+	trun(t, gt.client, "git", "commit", "-a", "-m", "v2\n\nChange-Id: I223456789\n")
+
+	// Simulate mailing both commits.
+	trun(t, gt.client, "git", "tag", "work.mailed", "HEAD")
+	testPendingArgs(t, []string{"-l", "-s"}, `
+		work REVHASH..REVHASH (current branch)
+		+ REVHASH v2
+		+ REVHASH msg
+
+	`)
+
+	// Amending the top commit leaves it differing from what was mailed.
+	write(t, gt.client+"/file", "v3", 0644)
+	trun(t, gt.client, "git", "commit", "-a", "--amend", "--no-edit")
+	testPendingArgs(t, []string{"-l", "-s"}, `
+		work REVHASH..REVHASH (current branch)
+		+ REVHASH v2 (local edits not mailed)
+		+ REVHASH msg
+
+	`)
+
+	// A new commit that was never mailed is not marked.
+	write(t, gt.client+"/file", "v4", 0644)
+	trun(t, gt.client, "git", "commit", "-a", "-m", "v4\n\nChange-Id: I323456789\n")
+	testPendingArgs(t, []string{"-l", "-s"}, `
+		work REVHASH..REVHASH (current branch)
+		+ REVHASH v4
+		+ REVHASH v2 (local edits not mailed)
+		+ REVHASH msg
+
+	`)
+
+	// Earlier mailings recorded in the tag's reflog count too,
+	// as when the tag has moved on to a later mailing.
+	old := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "work.mailed"))
+	trun(t, gt.client, "git", "tag", "--create-reflog", "-f", "work.mailed", "HEAD~1")
+	trun(t, gt.client, "git", "tag", "--create-reflog", "-f", "work.mailed", old)
+	testPendingArgs(t, []string{"-l", "-s"}, `
+		work REVHASH..REVHASH (current branch)
+		+ REVHASH v4
+		+ REVHASH v2
+		+ REVHASH msg
+
+	`)
+}
@@ -57,19 +57,28 @@ The -v flag prints those commands as they run.
 
 Available commands:
 
-	branchpoint
-	change [name]
-	change NNNN[/PP]
-	gofmt [-l]
+	amend-fixup <commit>
+	branchpoint [-all | -verify rev]
+	change [name | -]
+	change [-detach] NNNN[/PP]
+	config get key
+	config set key value
+	gofmt [-check | -l] [-simplify] [-since rev] [path...]
 	help
-	hooks
+	hooks [-uninstall]
+	log-cl <number>
 	mail [-r reviewer,...] [-cc mail,...] [options] [commit]
-	pending [-c] [-l] [-s]
+	open [commit]
+	pending [-c] [-color when] [-l | -no-fetch] [-max-behind n] [-new] [-q | -url-only | -watch n] [-s | -oneline] [-since duration] [-summary]
 	rebase-work
-	reword [commit...]
-	submit [-i | commit...]
-	sync
-	sync-branch [-continue]
+	reviewers [prefix]
+	reword [-s] [-trailer key=value...] [commit...]
+	reword -undo
+	submit [-check-ci] [-f] [-json] [-no-sync | -queue] [-vote label+n,...] [-i | commit...]
+	sync [-continue | [-no-fetch | -prune] [-rebase-merges]]
+	sync-branch [-continue | -dry-run | -status]
+	trybot [commit]
+	verify [commit]
 
 See https://pkg.go.dev/golang.org/x/review/git-codereview
 for the full details of each command.
@@ -97,20 +106,30 @@ func main() {
 		installHook(args, false)
 		return // avoid invoking installHook twice.
 
+	case "amend-fixup":
+		cmd = cmdAmendFixup
 	case "branchpoint":
 		cmd = cmdBranchpoint
 	case "change":
 		cmd = cmdChange
+	case "config":
+		cmd = cmdConfig
 	case "gofmt":
 		cmd = cmdGofmt
 	case "hook-invoke":
 		cmd = cmdHookInvoke
+	case "log-cl":
+		cmd = cmdLogCL
 	case "mail", "m":
 		cmd = cmdMail
+	case "open":
+		cmd = cmdOpen
 	case "pending":
 		cmd = cmdPending
 	case "rebase-work":
 		cmd = cmdRebaseWork
+	case "reviewers":
+		cmd = cmdReviewers
 	case "reword":
 		cmd = cmdReword
 	case "submit":
@@ -119,6 +138,10 @@ func main() {
 		cmd = cmdSync
 	case "sync-branch":
 		cmd = cmdSyncBranch
+	case "trybot":
+		cmd = cmdTrybot
+	case "verify":
+		cmd = cmdVerify
 	case "test-loadAuth": // for testing only.
 		cmd = func([]string) { loadAuth() }
 	}
@@ -138,7 +161,18 @@ func main() {
 		installHook(hookArgs, true)
 	}
 
-	cmd(args)
+	cmd(append(configFlags(command), args...))
+}
+
+// configFlags returns the default flags for command set by the
+// "<command>-flags" key in codereview.cfg, as in "mail-flags: -trybot".
+// They are placed before the command-line arguments,
+// so that flags given on the command line take precedence.
+func configFlags(command string) []string {
+	if command == "m" {
+		command = "mail"
+	}
+	return strings.Fields(config()[command+"-flags"])
 }
 
 func expectZeroArgs(args []string, command string) {
@@ -176,6 +210,40 @@ func runErr(command string, args ...string) error {
 var runLogTrap []string
 
 func runDirErr(dir, command string, args ...string) error {
+	return runDirTeeErr(dir, nil, command, args...)
+}
+
+// runEnvErr is like runErr but runs the command with the environment env,
+// in the form used by exec.Cmd's Env field, or the current environment if env is nil.
+func runEnvErr(env []string, command string, args ...string) error {
+	return runDirTeeEnvErr(".", nil, env, command, args...)
+}
+
+// environWithout returns the current environment,
+// minus any settings of the named variables.
+func environWithout(names ...string) []string {
+	var env []string
+Env:
+	for _, kv := range os.Environ() {
+		for _, name := range names {
+			if strings.HasPrefix(kv, name+"=") {
+				continue Env
+			}
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// runDirTeeErr is like runDirErr but also copies the command's
+// standard error to tee, if tee is not nil.
+func runDirTeeErr(dir string, tee io.Writer, command string, args ...string) error {
+	return runDirTeeEnvErr(dir, tee, nil, command, args...)
+}
+
+// runDirTeeEnvErr is like runDirTeeErr but runs the command
+// with the environment env, if env is not nil.
+func runDirTeeEnvErr(dir string, tee io.Writer, env []string, command string, args ...string) error {
 	if *noRun || *verbose == 1 {
 		fmt.Fprintln(stderr(), commandString(command, args))
 	} else if *verbose > 1 {
@@ -194,9 +262,13 @@ func runDirErr(dir, command string, args ...string) error {
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = stdout()
 	cmd.Stderr = stderr()
+	if tee != nil {
+		cmd.Stderr = io.MultiWriter(stderr(), tee)
+	}
 	if dir != "." {
 		cmd.Dir = dir
 	}
+	cmd.Env = env
 	setEnglishLocale(cmd)
 	return cmd.Run()
 }
@@ -0,0 +1,39 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func cmdReviewers(args []string) {
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s reviewers %s [prefix]\n", progName, globalFlags)
+		exit(2)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) > 1 {
+		flags.Usage()
+		exit(2)
+	}
+	prefix := flags.Arg(0)
+
+	// Print the same list mailLookup consults, in the same order,
+	// so that the first address printed for a short name is the one
+	// that "mail -r name" would use.
+	loadReviewers()
+	w := stdout()
+	for _, r := range reviewers {
+		if !strings.HasPrefix(r.addr, prefix) {
+			continue
+		}
+		note := ""
+		if shortOptOut[r.addr] {
+			note = " (not used for short names)"
+		}
+		fmt.Fprintf(w, "%5d %s%s\n", r.count, r.addr, note)
+	}
+}
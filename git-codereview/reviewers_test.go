@@ -0,0 +1,42 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReviewers(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	reviewers = nil
+	defer func() { reviewers = nil }()
+	orig := shortOptOut
+	defer func() { shortOptOut = orig }()
+	shortOptOut = map[string]bool{"r2@old.example": true}
+
+	for i, addr := range reviewerLog {
+		write(t, gt.server+"/file", fmt.Sprintf("v%d", i), 0644)
+		trun(t, gt.server, "git", "commit", "-a", "-m", "msg\n\nReviewed-by: "+addr+"\n")
+	}
+	trun(t, gt.client, "git", "pull")
+
+	testMain(t, "reviewers")
+	testPrintedStdout(t,
+		"    5 r1@golang.org\n"+
+			"    3 r1@fake.com\n"+
+			"    3 r2@old.example (not used for short names)\n"+
+			"    1 anon@golang.org\n"+
+			"    1 other@golang.org\n"+
+			"    1 r2@new.example\n")
+
+	testMain(t, "reviewers", "r2")
+	testPrintedStdout(t, "r2@old.example", "r2@new.example", "!r1@")
+
+	testMainDied(t, "reviewers", "a", "b")
+	testPrintedStderr(t, "Usage")
+}
@@ -9,18 +9,73 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
 func cmdReword(args []string) {
+	// NOTE: New flags should be added to the usage message below as well as doc.go.
+	signoff := flags.Bool("s", false, "add a Signed-off-by trailer to each reworded message")
+	var trailers trailerList
+	flags.Var(&trailers, "trailer", "add or update trailer `key=value` (repeatable; empty value removes)")
+	undo := flags.Bool("undo", false, "restore the commits as they were before the last reword")
 	flags.Usage = func() {
-		fmt.Fprintf(stderr(), "Usage: %s reword %s [commit...]\n",
+		fmt.Fprintf(stderr(), "Usage: %s reword %s [-s] [-trailer key=value...] [commit...]\n",
 			progName, globalFlags)
+		fmt.Fprintf(stderr(), "       %s reword %s -undo\n", progName, globalFlags)
 		exit(2)
 	}
 	flags.Parse(args)
-	args = flags.Args()
+	if *undo {
+		if *signoff || len(trailers) > 0 || flags.NArg() > 0 {
+			flags.Usage()
+		}
+		undoReword()
+		return
+	}
+	reword(flags.Args(), *signoff, trailers)
+}
+
+// A trailer is a single -trailer setting.
+// An empty value means to remove the trailer.
+type trailer struct {
+	key, value string
+}
+
+// trailerList is a flag.Value collecting repeated -trailer key=value flags.
+type trailerList []trailer
+
+var trailerKeyRE = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
 
+func (x *trailerList) String() string {
+	var list []string
+	for _, t := range *x {
+		list = append(list, t.key+"="+t.value)
+	}
+	return strings.Join(list, " ")
+}
+
+func (x *trailerList) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if !ok || !trailerKeyRE.MatchString(key) {
+		return fmt.Errorf("invalid trailer %q: want key=value", s)
+	}
+	if strings.Contains(value, "\n") {
+		return fmt.Errorf("invalid trailer %q: value contains newline", s)
+	}
+	*x = append(*x, trailer{key, value})
+	return nil
+}
+
+// reword edits the messages of the pending commits named by args,
+// or all pending commits if args is empty, rewriting the commits
+// without touching the index or working tree.
+// If signoff is set, reword adds a Signed-off-by trailer
+// for the current user to each edited message that lacks one.
+// If trailers is non-empty, reword applies them to each message
+// instead of opening an editor.
+func reword(args []string, signoff bool, trailers trailerList) {
 	// Check that we understand the structure
 	// before we let the user spend time editing messages.
 	b := CurrentBranch()
@@ -40,18 +95,6 @@ func cmdReword(args []string) {
 		last = c
 	}
 
-	headState := func() (head, branch string) {
-		head = trim(cmdOutput("git", "rev-parse", "HEAD"))
-		for _, line := range nonBlankLines(cmdOutput("git", "branch", "-l")) {
-			if strings.HasPrefix(line, "* ") {
-				branch = trim(line[1:])
-				return head, branch
-			}
-		}
-		dief("internal error: cannot find current branch")
-		panic("unreachable")
-	}
-
 	head, branch := headState()
 	if head != last.Hash {
 		dief("internal error: confused about pending commit graph: HEAD vs parent: %.7s vs %.7s", head, last.Hash)
@@ -77,6 +120,23 @@ func cmdReword(args []string) {
 	for _, c := range cs {
 		newMsg[c] = ""
 	}
+	var signoffBy string
+	if signoff {
+		signoffBy = signoffLine()
+	}
+
+	if len(trailers) > 0 {
+		// No editing: just apply the trailers to each message.
+		for _, c := range cs {
+			msg := []byte(c.Message)
+			for _, t := range trailers {
+				msg = setTrailer(msg, t.key, t.value)
+			}
+			newMsg[c] = string(fixCommitMessage(addSignoff(msg, signoffBy)))
+		}
+		rewriteMessages(b, pending, newMsg, "")
+		return
+	}
 
 	// Invoke editor to reword all the messages message.
 	// Save the edits to REWORD_MSGS immediately after editor exit
@@ -103,7 +163,7 @@ func cmdReword(args []string) {
 		if edited == "" {
 			dief("edited message is empty")
 		}
-		newMsg[c] = string(fixCommitMessage([]byte(edited)))
+		newMsg[c] = string(fixCommitMessage(addSignoff([]byte(edited), signoffBy)))
 		fmt.Fprintf(&buf, "# %s\n\n%s\n\n", c.Subject, edited)
 		saveBuf()
 	} else {
@@ -165,15 +225,23 @@ func cmdReword(args []string) {
 			if c == nil {
 				dief("cannot find commit for header: %s\n%s", strings.TrimSpace(hdr), note)
 			}
-			newMsg[c] = string(fixCommitMessage([]byte(body)))
+			newMsg[c] = string(fixCommitMessage(addSignoff([]byte(body), signoffBy)))
 		}
 	}
 
+	rewriteMessages(b, pending, newMsg, "\n"+note)
+}
+
+// rewriteMessages rebuilds the pending commits on b, replacing the
+// message of each commit c that has a non-empty newMsg[c],
+// and then moves b to the rebuilt commits.
+// The suffix is appended to error messages, to point at saved edits.
+func rewriteMessages(b *Branch, pending []*Commit, newMsg map[*Commit]string, suffix string) {
 	// Rebuild the commits the way git would,
 	// but without doing any git checkout that
 	// would affect the files in the working directory.
 	var newHash string
-	last = nil
+	var last *Commit
 	for i := len(pending) - 1; i >= 0; i-- {
 		c := pending[i]
 		if (newMsg[c] == "" || newMsg[c] == c.Message) && newHash == "" {
@@ -210,16 +278,135 @@ func cmdReword(args []string) {
 
 	// Attempt swap of HEAD but leave index and working copy alone.
 	// No obvious way to make it atomic, but check for races.
-	head, branch = headState()
+	head, branch := headState()
 	if head != pending[0].Hash {
-		dief("cannot reword: commits changed underfoot\n%s", note)
+		dief("cannot reword: commits changed underfoot%s", suffix)
 	}
 	if branch != b.Name {
-		dief("cannot reword: branch changed underfoot\n%s", note)
+		dief("cannot reword: branch changed underfoot%s", suffix)
 	}
 	run("git", "reset", "--soft", newHash)
 }
 
+// undoReword undoes the most recent reword on the current branch,
+// resetting the branch to the commits it had before.
+// Reword moves the branch with git reset --soft, so the earlier commits
+// are the previous entry in the branch's reflog. To be sure that last
+// move was a reword, undoReword requires that it kept the same tree
+// and that nothing has moved the branch since. Like reword,
+// it leaves the index and working tree alone.
+func undoReword() {
+	b := CurrentBranch()
+	if b.DetachedHead() {
+		dief("reword -undo: no current branch")
+	}
+	out, err := cmdOutputErr("git", "reflog", "show", "-n", "2", "--format=%H %gs", b.FullName(), "--")
+	if err != nil {
+		dief("reword -undo: cannot read reflog for %s:\n%s", b.Name, out)
+	}
+	entries := nonBlankLines(out)
+	if len(entries) < 2 {
+		dief("reword -undo: no reword to undo on %s", b.Name)
+	}
+	now, _, _ := strings.Cut(entries[0], " ")
+	before, _, _ := strings.Cut(entries[1], " ")
+	head, branch := headState()
+	if head != now || branch != b.Name {
+		dief("reword -undo: %s has changed since its last update", b.Name)
+	}
+	if !strings.HasPrefix(entries[0], now+" reset: moving to ") ||
+		trim(cmdOutput("git", "rev-parse", now+"^{tree}")) != trim(cmdOutput("git", "rev-parse", before+"^{tree}")) {
+		dief("reword -undo: last change to %s was not a reword", b.Name)
+	}
+	run("git", "reset", "--soft", before)
+	printf("restored %s to %.7s, as before the reword.", b.Name, before)
+	if _, err := os.Stat(filepath.Join(gitPathDir(), "REWORD_MSGS")); err == nil {
+		printf("the reworded messages are still saved in %s", filepath.Join(gitPathDir(), "REWORD_MSGS"))
+	}
+}
+
+// headState returns the HEAD commit hash and the current branch name.
+func headState() (head, branch string) {
+	head = trim(cmdOutput("git", "rev-parse", "HEAD"))
+	for _, line := range nonBlankLines(cmdOutput("git", "branch", "-l")) {
+		if strings.HasPrefix(line, "* ") {
+			branch = trim(line[1:])
+			return head, branch
+		}
+	}
+	dief("internal error: cannot find current branch")
+	panic("unreachable")
+}
+
+// setTrailer returns msg with its trailer "key: value" set,
+// as git interpret-trailers --if-exists replace would:
+// the first existing trailer with that key (compared case-insensitively)
+// is updated in place, keeping its spelling of the key, any others are removed, and if there is none
+// the trailer is added at the end of the message.
+// If value is empty, setTrailer removes all trailers with that key.
+func setTrailer(msg []byte, key, value string) []byte {
+	data := bytes.TrimRight(stripComments(msg), "\n")
+	body, block := string(data), ""
+	if endsWithMetadataLine(data) {
+		// The trailer block is the final paragraph.
+		if i := strings.LastIndex(body, "\n\n"); i >= 0 {
+			body, block = body[:i], body[i+2:]
+		}
+	}
+
+	var out []string
+	found := false
+	for _, line := range lines(block) {
+		k, _, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(k), key) {
+			if found || value == "" {
+				continue
+			}
+			found = true
+			line = strings.TrimSpace(k) + ": " + value
+		}
+		out = append(out, line)
+	}
+	if !found && value != "" {
+		out = append(out, key+": "+value)
+	}
+	if len(out) == 0 {
+		return []byte(body + "\n")
+	}
+	return []byte(body + "\n\n" + strings.Join(out, "\n") + "\n")
+}
+
+// signoffLine returns the Signed-off-by trailer for the current user,
+// who is the committer of the reworded commits, as git commit -s does.
+func signoffLine() string {
+	ident := trim(cmdOutput("git", "var", "GIT_COMMITTER_IDENT"))
+	// The ident is "Name <email> timestamp zone".
+	if i := strings.LastIndex(ident, ">"); i >= 0 {
+		ident = ident[:i+1]
+	}
+	return "Signed-off-by: " + ident
+}
+
+// addSignoff returns msg with the trailer line signoff added at the end,
+// unless signoff is empty or msg already contains it.
+func addSignoff(msg []byte, signoff string) []byte {
+	if signoff == "" {
+		return msg
+	}
+	data := stripComments(msg)
+	for _, line := range lines(string(data)) {
+		if line == signoff {
+			return msg
+		}
+	}
+	data = bytes.TrimRight(data, "\n")
+	sep := "\n\n"
+	if endsWithMetadataLine(data) {
+		sep = "\n"
+	}
+	return append(data, sep+signoff+"\n"...)
+}
+
 var rewordProlog = `Rewording multiple commit messages.
 The # lines separate the different commits and must be left unchanged.
 `
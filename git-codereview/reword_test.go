@@ -83,3 +83,113 @@ func TestReword(t *testing.T) {
 		t.Fatalf("reword multiple commits did not run commit message hook:\n%s", out)
 	}
 }
+
+func TestRewordSignoff(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	gt.work(t)
+
+	os.Setenv("GIT_EDITOR", "true")
+	defer os.Unsetenv("GIT_EDITOR")
+
+	signedOff := func(rev string) int {
+		msg := trun(t, gt.client, "git", "log", "-n1", "--format=%B", rev)
+		return strings.Count(msg, "\nSigned-off-by: ")
+	}
+
+	testMain(t, "reword", "-s", "HEAD")
+	if n := signedOff("HEAD"); n != 1 {
+		t.Fatalf("HEAD has %d Signed-off-by lines after reword -s HEAD, want 1", n)
+	}
+	if n := signedOff("HEAD^"); n != 0 {
+		t.Fatalf("HEAD^ has %d Signed-off-by lines after reword -s HEAD, want 0", n)
+	}
+	msg := trun(t, gt.client, "git", "log", "-n1", "--format=%B", "HEAD")
+	if !strings.Contains(msg, "Change-Id: I223456789\nSigned-off-by: ") {
+		t.Fatalf("Signed-off-by not added after Change-Id trailer:\n%s", msg)
+	}
+
+	// Rewording all adds the line only where it is missing.
+	testMain(t, "reword", "-s")
+	if n := signedOff("HEAD"); n != 1 {
+		t.Fatalf("HEAD has %d Signed-off-by lines after reword -s, want 1", n)
+	}
+	if n := signedOff("HEAD^"); n != 1 {
+		t.Fatalf("HEAD^ has %d Signed-off-by lines after reword -s, want 1", n)
+	}
+}
+
+func TestRewordTrailer(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	gt.work(t)
+	trun(t, gt.client, "git", "commit", "--amend", "-q", "-m", "msg\n\nbody\n\nBug: 1\nFixes: 2\nChange-Id: I223456789\n")
+
+	msg := func(rev string) string {
+		return strings.TrimSpace(trun(t, gt.client, "git", "log", "-n1", "--format=%B", rev)) + "\n"
+	}
+
+	testMain(t, "reword", "-trailer", "bug=42", "-trailer", "Cq-Include-Trybots=a,b", "HEAD")
+	if got, want := msg("HEAD"), "msg\n\nbody\n\nBug: 42\nFixes: 2\nChange-Id: I223456789\nCq-Include-Trybots: a,b\n"; got != want {
+		t.Fatalf("after reword -trailer, message is:\n%s\nwant:\n%s", got, want)
+	}
+	if strings.Contains(msg("HEAD^"), "Bug:") {
+		t.Fatalf("reword -trailer HEAD changed HEAD^:\n%s", msg("HEAD^"))
+	}
+
+	testMain(t, "reword", "-trailer", "Fixes=", "-trailer", "Bug=", "-trailer", "Cq-Include-Trybots=", "HEAD")
+	if got, want := msg("HEAD"), "msg\n\nbody\n\nChange-Id: I223456789\n"; got != want {
+		t.Fatalf("after removing trailers, message is:\n%s\nwant:\n%s", got, want)
+	}
+
+	// With no commits named, all pending commits are updated.
+	testMain(t, "reword", "-trailer", "Bug=7")
+	for _, rev := range []string{"HEAD", "HEAD^"} {
+		if m := msg(rev); !strings.Contains(m, "\nBug: 7\n") {
+			t.Fatalf("%s missing Bug trailer after reword -trailer:\n%s", rev, m)
+		}
+	}
+
+	testMainDied(t, "reword", "-trailer", "bad key=x")
+	testPrintedStderr(t, "invalid trailer")
+}
+
+func TestRewordUndo(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	gt.work(t)
+	before := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+
+	testMainDied(t, "reword", "-undo", "HEAD")
+	testPrintedStderr(t, "Usage")
+
+	// The last change to work was a commit, not a reword.
+	testMainDied(t, "reword", "-undo")
+	testPrintedStderr(t, "last change to work was not a reword")
+
+	os.Setenv("GIT_EDITOR", "sed -i.bak -e s/msg/MESSAGE/")
+	defer os.Unsetenv("GIT_EDITOR")
+	testMain(t, "reword")
+	write(t, gt.client+"/file", "local edit", 0644)
+
+	testMain(t, "reword", "-undo")
+	testPrintedStderr(t, "restored work to "+before[:7], "REWORD_MSGS")
+	if head := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD")); head != before {
+		t.Fatalf("after reword -undo, HEAD = %s, want %s", head, before)
+	}
+	if data := read(t, gt.client+"/file"); string(data) != "local edit" {
+		t.Fatalf("reword -undo changed working tree: file = %q", data)
+	}
+
+	// A commit after the reword blocks the undo.
+	testMain(t, "reword")
+	trun(t, gt.client, "git", "commit", "-q", "-a", "-m", "more")
+	testMainDied(t, "reword", "-undo")
+	testPrintedStderr(t, "last change to work was not a reword")
+}
@@ -6,24 +6,37 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
 func cmdSubmit(args []string) {
 	// NOTE: New flags should be added to the usage message below as well as doc.go.
-	var interactive bool
+	var interactive, force, noSync, jsonOut, queue, checkCI bool
+	voteList := new(stringList)
+	flags.BoolVar(&checkCI, "check-ci", false, "refuse to submit unless the change's CI labels report success")
 	flags.BoolVar(&interactive, "i", false, "interactively select commits to submit")
+	flags.BoolVar(&force, "f", false, "submit even if Gerrit will also submit unsubmitted changes below the commits")
+	flags.BoolVar(&jsonOut, "json", false, "print the result for each commit as a JSON object")
+	flags.BoolVar(&noSync, "no-sync", false, "leave the local branch as is after submitting")
+	flags.BoolVar(&queue, "queue", false, "vote for the commit queue to submit the commits instead of submitting them directly")
+	flags.Var(voteList, "vote", "comma-separated list of label votes (like Code-Review+2) to apply before submitting")
 	flags.Usage = func() {
-		fmt.Fprintf(stderr(), "Usage: %s submit %s [-i | commit...]\n", progName, globalFlags)
+		fmt.Fprintf(stderr(), "Usage: %s submit %s [-check-ci] [-f] [-json] [-no-sync | -queue] [-vote label+n,...] [-i | commit...]\n", progName, globalFlags)
 		exit(2)
 	}
 	flags.Parse(args)
-	if interactive && flags.NArg() > 0 {
+	if interactive && flags.NArg() > 0 || noSync && queue || checkCI && queue {
 		flags.Usage()
 		exit(2)
 	}
+	votes := parseSubmitVotes(string(*voteList))
 
 	b := CurrentBranch()
 	var cs []*Commit
@@ -51,71 +64,236 @@ func cmdSubmit(args []string) {
 	checkStaged("submit")
 	checkUnstaged("submit")
 
+	// Gerrit submits a change together with the changes below it.
+	// Make sure the user knows about any that are not being submitted.
+	// There is nobody to ask when standard input is not a terminal,
+	// and with -json the question would interrupt the output.
+	if below := submitAncestors(b, cs); len(below) > 0 && !force {
+		var buf bytes.Buffer
+		for _, c := range below {
+			fmt.Fprintf(&buf, "\n\t%s %s", c.ShortHash, c.Subject)
+		}
+		msg := "submitting out of stack order; Gerrit will also submit these unsubmitted changes:" + buf.String()
+		if jsonOut || !stdinIsTerminal() {
+			dief("%s\nuse 'git codereview submit -f' to submit anyway", msg)
+		}
+		printf("warning: %s", msg)
+		fmt.Fprintf(stderr(), "submit anyway (y/n)? ")
+		if !scanYes() {
+			dief("submit aborted; use 'git codereview submit -f' to skip this check")
+		}
+	}
+
 	// Submit the changes.
+	// With -json, print a result for each commit, stopping at the first failure.
 	var g *GerritChange
 	for _, c := range cs {
-		printf("submitting %s %s", c.ShortHash, c.Subject)
-		g = submit(b, c)
+		var err error
+		if queue {
+			if !jsonOut {
+				printf("queueing %s %s", c.ShortHash, c.Subject)
+			}
+			g, err = queueSubmit(b, c, votes)
+		} else {
+			if !jsonOut {
+				printf("submitting %s %s", c.ShortHash, c.Subject)
+			}
+			g, err = submit(b, c, votes, checkCI)
+		}
+		if jsonOut {
+			printSubmitResult(c, g, err)
+			if err != nil {
+				exit(1)
+			}
+		} else if err != nil {
+			dief("%v", err)
+		}
+	}
+
+	// The commit queue submits the changes later, so there is nothing to sync yet.
+	if queue {
+		if !jsonOut {
+			printf("queued for the commit queue to submit; run 'git sync' after it does")
+		}
+		return
 	}
 
 	// Sync client to revision that Gerrit committed, but only if we can do it cleanly.
 	// Otherwise require user to run 'git sync' themselves (if they care).
+	// With -no-sync, leave the local branch alone, for continued work on it.
 	run("git", "fetch", "-q")
-	if len(cs) == 1 && len(b.Pending()) == 1 {
+	if len(cs) == 1 && len(b.Pending()) == 1 && !noSync {
 		if err := runErr("git", "checkout", "-q", "-B", b.Name, g.CurrentRevision, "--"); err != nil {
 			dief("submit succeeded, but cannot sync local branch\n"+
 				"\trun 'git sync' to sync, or\n"+
 				"\trun 'git branch -D %s; git change master; git sync' to discard local branch", b.Name)
 		}
-	} else {
+	} else if !jsonOut {
 		printf("submit succeeded; run 'git sync' to sync")
 	}
 
 	// Done! Change is submitted, branch is up to date, ready for new work.
 }
 
+// A submitResult is the result of submitting one commit,
+// as printed by submit -json.
+type submitResult struct {
+	Commit   string `json:"commit"`              // local commit hash
+	Number   int    `json:"number,omitempty"`    // CL number
+	ChangeID string `json:"change_id,omitempty"` // Change-Id
+	Status   string `json:"status,omitempty"`    // final Gerrit status, such as MERGED
+	Merged   string `json:"merged,omitempty"`    // hash of the commit Gerrit merged
+	Error    string `json:"error,omitempty"`     // error, if the submit failed
+}
+
+// printSubmitResult prints the result of submitting c as a single line
+// of JSON on standard output. g is the change as last seen on Gerrit
+// and may be nil; err is the error from submitting, if any.
+func printSubmitResult(c *Commit, g *GerritChange, err error) {
+	r := &submitResult{Commit: c.Hash, ChangeID: c.ChangeID}
+	if g != nil {
+		r.Number = g.Number
+		r.Status = g.Status
+		if g.Status == "MERGED" {
+			r.Merged = g.CurrentRevision
+		}
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	js, err := json.Marshal(r)
+	if err != nil {
+		dief("%v", err)
+	}
+	fmt.Fprintf(stdout(), "%s\n", js)
+}
+
+// submitAncestors returns the pending commits on b that are below
+// some commit in cs but are themselves neither in cs nor already submitted.
+// These are the commits that Gerrit will submit along with cs,
+// and submitting cs before them would submit the stack out of order.
+// A commit counts as submitted if it has reached the origin branch
+// or if Gerrit reports its change as merged, in case the local
+// copy of the origin branch is not yet up to date.
+func submitAncestors(b *Branch, cs []*Commit) []*Commit {
+	chosen := make(map[*Commit]bool)
+	for _, c := range cs {
+		chosen[c] = true
+	}
+	// Pending is newest first, so everything after
+	// the first chosen commit is below some chosen commit.
+	var below []*Commit
+	found := false
+	for _, c := range b.Pending() {
+		if chosen[c] {
+			found = true
+			continue
+		}
+		if found && !b.Submitted(c.ChangeID) && !gerritMerged(b, c) {
+			below = append(below, c)
+		}
+	}
+	return below
+}
+
+// gerritMerged reports whether Gerrit says that c's change has been merged.
+func gerritMerged(b *Branch, c *Commit) bool {
+	if c.ChangeID == "" {
+		return false
+	}
+	g, err := b.GerritChange(c)
+	return err == nil && g.Status == "MERGED"
+}
+
+// submitVoteRE matches a single label vote, like Code-Review+2.
+var submitVoteRE = regexp.MustCompile(`^([A-Za-z][-A-Za-z0-9]*)([+-][0-9]+)$`)
+
+// parseSubmitVotes parses the comma-separated list of label votes
+// given to submit -vote. It dies if any vote is malformed.
+func parseSubmitVotes(list string) map[string]int {
+	if list == "" {
+		return nil
+	}
+	votes := make(map[string]int)
+	for _, vote := range strings.Split(list, ",") {
+		vote = strings.TrimSpace(vote)
+		m := submitVoteRE.FindStringSubmatch(vote)
+		if m == nil {
+			dief("invalid vote %q: expected label+n or label-n, as in Code-Review+2", vote)
+		}
+		n, _ := strconv.Atoi(m[2])
+		votes[m[1]] = n
+	}
+	return votes
+}
+
 // submit submits a single commit c on branch b and returns the
-// GerritChange for the submitted change. It dies if the submit fails.
-func submit(b *Branch, c *Commit) *GerritChange {
+// GerritChange for the submitted change, along with any error.
+// If votes is non-empty, submit first applies those label votes
+// to the current revision of the change.
+// If checkCI is set, submit refuses to submit unless the change's
+// CI labels report success (see submitCheckCI).
+// The returned GerritChange is nil if the error happened before
+// the change could be looked up on Gerrit.
+func submit(b *Branch, c *Commit, votes map[string]int, checkCI bool) (*GerritChange, error) {
 	if strings.Contains(strings.ToLower(c.Message), "do not submit") {
-		dief("%s: CL says DO NOT SUBMIT", c.ShortHash)
+		return nil, fmt.Errorf("%s: CL says DO NOT SUBMIT", c.ShortHash)
 	}
 
 	// Fetch Gerrit information about this change.
-	g, err := b.GerritChange(c, "LABELS", "CURRENT_REVISION")
+	g, err := submitChange(b, c, "LABELS", "CURRENT_REVISION")
 	if err != nil {
-		dief("%v", err)
+		return nil, err
+	}
+
+	if checkCI {
+		if err := submitCheckCI(b, c); err != nil {
+			return g, fmt.Errorf("cannot submit: %v", err)
+		}
 	}
 
 	// Pre-check that this change appears submittable.
 	// The final submit will check this too, but it is better to fail now.
-	if err = submitCheck(g); err != nil {
-		dief("cannot submit: %v", err)
+	// If we are about to vote, the votes may supply missing approvals,
+	// so the check waits until after voting.
+	if len(votes) == 0 {
+		if err = submitCheck(g); err != nil {
+			return g, fmt.Errorf("cannot submit: %v", err)
+		}
 	}
 
-	// Upload most recent revision if not already on server.
-
-	if c.Hash != g.CurrentRevision {
-		run("git", "push", "-q", "origin", b.PushSpec(c))
-
-		// Refetch change information.
-		g, err = b.GerritChange(c, "LABELS", "CURRENT_REVISION")
-		if err != nil {
-			dief("%v", err)
-		}
+	if g, err = submitUpload(b, c, g); err != nil {
+		return g, err
 	}
 
 	if *noRun {
 		printf("stopped before submit")
-		return g
+		return g, nil
+	}
+
+	if len(votes) > 0 {
+		js, err := json.Marshal(&GerritReviewInput{Labels: votes})
+		if err != nil {
+			return g, err
+		}
+		if err := gerritAPI("/a/changes/"+fullChangeID(b, c)+"/revisions/current/review", js, nil); err != nil {
+			return g, fmt.Errorf("cannot vote: %v", err)
+		}
+		g, err = submitChange(b, c, "LABELS", "CURRENT_REVISION")
+		if err != nil {
+			return nil, err
+		}
+		if err = submitCheck(g); err != nil {
+			return g, fmt.Errorf("cannot submit: %v", err)
+		}
 	}
 
 	// Otherwise, try the submit. Sends back updated GerritChange,
 	// but we need extended information and the reply is in the
 	// "SUBMITTED" state anyway, so ignore the GerritChange
 	// in the response and fetch a new one below.
-	if err := gerritAPI("/a/changes/"+fullChangeID(b, c)+"/submit", []byte(`{"wait_for_merge": true}`), nil); err != nil {
-		dief("cannot submit: %v", err)
+	if err := submitRequest(b, c); err != nil {
+		return g, fmt.Errorf("cannot submit: %v", err)
 	}
 
 	// It is common to get back "SUBMITTED" for a split second after the
@@ -128,10 +306,11 @@ func submit(b *Branch, c *Commit) *GerritChange {
 	const max = 2 * time.Second
 	for i := 0; i < steps; i++ {
 		time.Sleep(max * (1 << uint(i+1)) / (1 << steps))
-		g, err = b.GerritChange(c, "LABELS", "CURRENT_REVISION")
+		g1, err := submitChange(b, c, "LABELS", "CURRENT_REVISION")
 		if err != nil {
-			dief("waiting for merge: %v", err)
+			return g, fmt.Errorf("waiting for merge: %v", err)
 		}
+		g = g1
 		if g.Status != "SUBMITTED" {
 			break
 		}
@@ -139,23 +318,195 @@ func submit(b *Branch, c *Commit) *GerritChange {
 
 	switch g.Status {
 	default:
-		dief("submit error: unexpected post-submit Gerrit change status %q", g.Status)
+		return g, fmt.Errorf("submit error: unexpected post-submit Gerrit change status %q", g.Status)
 
 	case "MERGED":
 		// good
 
 	case "SUBMITTED":
 		// see above
-		dief("cannot submit: timed out waiting for change to be submitted by Gerrit")
+		return g, fmt.Errorf("cannot submit: timed out waiting for change to be submitted by Gerrit")
+	}
+
+	return g, nil
+}
+
+// submitRetries is the number of times submitRequest retries
+// a submit request that failed with a transient conflict.
+const submitRetries = 3
+
+// submitRetryDelay is the delay before the first retry of a submit request,
+// doubled for each later one. It is a variable so that tests can shorten it.
+var submitRetryDelay = 500 * time.Millisecond
+
+// submitRequest asks Gerrit to submit the change for c.
+// Gerrit answers 409 Conflict when the change cannot be submitted,
+// but a busy server also answers 409 when the change is only locked
+// by another request. So after a conflict submitRequest rereads the change
+// and, if it is still open and mergeable, retries the request up to
+// submitRetries times. A change found submitted or merged counts as success.
+func submitRequest(b *Branch, c *Commit) error {
+	delay := submitRetryDelay
+	for i := 0; ; i++ {
+		err := gerritAPI("/a/changes/"+fullChangeID(b, c)+"/submit", []byte(`{"wait_for_merge": true}`), nil)
+		var gerr *gerritError
+		if err == nil || !errors.As(err, &gerr) || gerr.statusCode != http.StatusConflict || i == submitRetries {
+			return err
+		}
+		g, err1 := b.GerritChange(c)
+		if err1 != nil {
+			return err
+		}
+		switch {
+		case g.Status == "MERGED" || g.Status == "SUBMITTED":
+			return nil
+		case g.Status != "NEW" || g.Mergeable != nil && !*g.Mergeable:
+			return err
+		}
+		verbosef("submit conflict on open change; retrying in %v", delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// submitUpload uploads c, the commit for change g, if it is not
+// already the change's current revision on the server.
+// It returns the refetched change information after an upload, or else g.
+func submitUpload(b *Branch, c *Commit, g *GerritChange) (*GerritChange, error) {
+	if c.Hash == g.CurrentRevision {
+		return g, nil
+	}
+	if err := runErr("git", "push", "-q", "origin", b.PushSpec(c)); err != nil {
+		return g, err
+	}
+	return submitChange(b, c, "LABELS", "CURRENT_REVISION")
+}
+
+// queueSubmit asks the commit queue to submit c, instead of submitting it
+// directly, by applying the vote named by the "commit-queue-vote"
+// configuration key (Commit-Queue+2 by default), along with any votes,
+// to the current revision of its change. It uploads c first if needed.
+func queueSubmit(b *Branch, c *Commit, votes map[string]int) (*GerritChange, error) {
+	if strings.Contains(strings.ToLower(c.Message), "do not submit") {
+		return nil, fmt.Errorf("%s: CL says DO NOT SUBMIT", c.ShortHash)
+	}
+	g, err := submitChange(b, c, "LABELS", "CURRENT_REVISION")
+	if err != nil {
+		return nil, err
+	}
+	switch g.Status {
+	case "MERGED":
+		return g, fmt.Errorf("cannot queue: change already submitted, run 'git sync'")
+	case "ABANDONED":
+		return g, fmt.Errorf("cannot queue: change abandoned")
+	}
+	if g, err = submitUpload(b, c, g); err != nil {
+		return g, err
+	}
+	if *noRun {
+		printf("stopped before queueing")
+		return g, nil
+	}
+
+	vote := config()["commit-queue-vote"]
+	if vote == "" {
+		vote = "Commit-Queue+2"
 	}
+	labels := make(map[string]int)
+	for name, n := range votes {
+		labels[name] = n
+	}
+	for name, n := range parseSubmitVotes(vote) {
+		labels[name] = n
+	}
+	js, err := json.Marshal(&GerritReviewInput{Labels: labels})
+	if err != nil {
+		return g, err
+	}
+	if err := gerritAPI("/a/changes/"+fullChangeID(b, c)+"/revisions/current/review", js, nil); err != nil {
+		return g, fmt.Errorf("cannot queue: %v", err)
+	}
+	return g, nil
+}
+
+// defaultCILabels lists the labels that report CI results
+// when the "ci-labels" configuration key is not set.
+const defaultCILabels = "TryBot-Result, LUCI-TryBot-Result"
+
+// submitCheckCI checks that the CI labels on c's change, named by the
+// "ci-labels" configuration key, report success for the revision being
+// submitted. A label reports failure if anyone has voted it negative,
+// and success if it is approved or has a positive vote.
+// Listed labels that the change does not have are ignored,
+// but at least one must be present.
+func submitCheckCI(b *Branch, c *Commit) error {
+	g, err := b.GerritChange(c, "CURRENT_REVISION", "LABELS", "DETAILED_LABELS")
+	if err != nil {
+		return err
+	}
+	if c.Hash != g.CurrentRevision {
+		return fmt.Errorf("%s is not the revision tested by CI; mail it and wait for CI to finish", c.ShortHash)
+	}
+	list := config()["ci-labels"]
+	if list == "" {
+		list = defaultCILabels
+	}
+	names := strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' })
+	found := false
+	for _, name := range names {
+		label := g.Labels[name]
+		if label == nil {
+			continue
+		}
+		found = true
+		failed, passed := label.Rejected != nil, label.Approved != nil
+		for _, x := range label.All {
+			failed = failed || x.Value < 0
+			passed = passed || x.Value > 0
+		}
+		switch {
+		case failed:
+			return fmt.Errorf("CI label %s is failing", name)
+		case !passed:
+			return fmt.Errorf("CI label %s is pending", name)
+		}
+	}
+	if !found {
+		return fmt.Errorf("change has no CI label (%s)", strings.Join(names, ", "))
+	}
+	return nil
+}
 
-	return g
+// submitOptionsUnsupported records that the Gerrit server rejected
+// the SUBMITTABLE and SUBMIT_REQUIREMENTS options, so that
+// submitChange does not keep asking for them.
+var submitOptionsUnsupported bool
+
+// submitChange returns the Gerrit information about c's change,
+// requesting the extra options along with SUBMITTABLE and SUBMIT_REQUIREMENTS.
+// Older Gerrit servers reject those two options with 400 Bad Request;
+// for them, submitChange asks again without them,
+// and submitCheck falls back to checking the labels.
+func submitChange(b *Branch, c *Commit, extra ...string) (*GerritChange, error) {
+	if !submitOptionsUnsupported {
+		opts := append(append([]string{}, extra...), "SUBMITTABLE", "SUBMIT_REQUIREMENTS")
+		g, err := b.GerritChange(c, opts...)
+		var gerr *gerritError
+		if !errors.As(err, &gerr) || gerr.statusCode != http.StatusBadRequest {
+			return g, err
+		}
+		verbosef("Gerrit server does not support SUBMITTABLE and SUBMIT_REQUIREMENTS; checking labels instead")
+		submitOptionsUnsupported = true
+	}
+	return b.GerritChange(c, extra...)
 }
 
 // submitCheck checks that g should be submittable. This is
 // necessarily a best-effort check.
 //
-// g must have the "LABELS" option.
+// If Gerrit reported the change's submit requirements or whether
+// it is submittable, submitCheck relies on those. Otherwise it falls
+// back to checking for label approvals, which requires the "LABELS" option.
 func submitCheck(g *GerritChange) error {
 	// Check Gerrit change status.
 	switch g.Status {
@@ -177,6 +528,26 @@ func submitCheck(g *GerritChange) error {
 		return fmt.Errorf("change abandoned")
 	}
 
+	// Check submit requirements, which newer Gerrit servers
+	// use to decide whether a change can be submitted.
+	if len(g.SubmitRequirements) > 0 {
+		for _, req := range g.SubmitRequirements {
+			switch req.Status {
+			case "UNSATISFIED":
+				return fmt.Errorf("change does not satisfy %s submit requirement", req.Name)
+			case "ERROR":
+				return fmt.Errorf("change has error evaluating %s submit requirement", req.Name)
+			}
+		}
+		return nil
+	}
+	if g.Submittable != nil {
+		if !*g.Submittable {
+			return fmt.Errorf("change is not submittable")
+		}
+		return nil
+	}
+
 	// Check for label approvals (like CodeReview+2).
 	for _, name := range g.LabelNames() {
 		label := g.Labels[name]
@@ -200,7 +571,7 @@ func submitHashes(b *Branch) []string {
 	pending := b.Pending()
 	for _, c := range pending {
 		// Note that DETAILED_LABELS does not imply LABELS.
-		c.g, c.gerr = b.GerritChange(c, "CURRENT_REVISION", "LABELS", "DETAILED_LABELS")
+		c.g, c.gerr = submitChange(b, c, "CURRENT_REVISION", "LABELS", "DETAILED_LABELS")
 		if c.g == nil {
 			c.g = new(GerritChange)
 		}
@@ -217,7 +588,7 @@ func submitHashes(b *Branch) []string {
 			fmt.Fprintf(&script, "# %v:\n#", err)
 		}
 
-		formatCommit(&script, c, true)
+		formatCommit(&script, c, true, false)
 	}
 
 	fmt.Fprintf(&script, `
@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestSubmitErrors(t *testing.T) {
@@ -91,6 +92,24 @@ func TestSubmitErrors(t *testing.T) {
 	testRan(t) // nothing
 	testPrintedStderr(t, "cannot submit: change has Code-Review rejection")
 
+	t.Logf("> unsatisfied submit requirement")
+	srv.setJSON(id, `{"status": "NEW", "labels": {"Code-Review": {"approved": {}}}, "submittable": false, "submit_requirements": [{"name": "Code-Review", "status": "SATISFIED"}, {"name": "No-Unresolved-Comments", "status": "UNSATISFIED"}]}`)
+	testMainDied(t, "submit")
+	testRan(t) // nothing
+	testPrintedStderr(t, "cannot submit: change does not satisfy No-Unresolved-Comments submit requirement")
+
+	t.Logf("> not submittable")
+	srv.setJSON(id, `{"status": "NEW", "labels": {"Code-Review": {"approved": {}}}, "submittable": false}`)
+	testMainDied(t, "submit")
+	testRan(t) // nothing
+	testPrintedStderr(t, "cannot submit: change is not submittable")
+
+	t.Logf("> submit requirements override labels")
+	srv.setJSON(id, `{"status": "NEW", "labels": {"Code-Review": {}}, "submit_requirements": [{"name": "Code-Review", "status": "OVERRIDDEN"}]}`)
+	srv.setReply("/a/changes/proj~main~I123456789/submit", gerritReply{body: ")]}'\n" + `{"status": "NEW"}`})
+	testMainDied(t, "submit")
+	testPrintedStderr(t, "submit error: unexpected post-submit Gerrit change status \"NEW\"")
+
 	t.Logf("> submit with unexpected status")
 	const newJSON = `{"status": "NEW", "labels": {"Code-Review": {"approved": {}}}}`
 	srv.setJSON(id, newJSON)
@@ -175,6 +194,128 @@ func TestSubmit(t *testing.T) {
 		"git checkout -q -B work "+serverHead+" --")
 }
 
+func TestSubmitNoSync(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	gt.work(t)
+	trun(t, gt.client, "git", "tag", "-f", "work.mailed")
+	clientHead := strings.TrimSpace(trun(t, gt.client, "git", "log", "-n", "1", "--format=format:%H"))
+
+	var (
+		newJSON    = `{"status": "NEW", "mergeable": true, "current_revision": "` + clientHead + `", "labels": {"Code-Review": {"approved": {}}}}`
+		mergedJSON = `{"status": "MERGED", "mergeable": true, "current_revision": "` + clientHead + `", "labels": {"Code-Review": {"approved": {}}}}`
+	)
+	submitted := false
+	srv.setReply("/a/changes/proj~main~I123456789", gerritReply{f: func() gerritReply {
+		if submitted {
+			return gerritReply{body: ")]}'\n" + mergedJSON}
+		}
+		return gerritReply{body: ")]}'\n" + newJSON}
+	}})
+	srv.setReply("/a/changes/proj~main~I123456789/submit", gerritReply{f: func() gerritReply {
+		submitted = true
+		return gerritReply{body: ")]}'\n" + mergedJSON}
+	}})
+
+	testMain(t, "submit", "-no-sync")
+	testPrintedStderr(t, "submit succeeded; run 'git sync' to sync")
+	testRan(t, "git fetch -q")
+	if head := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD")); head != clientHead {
+		t.Fatalf("HEAD = %s after submit -no-sync, want %s", head, clientHead)
+	}
+}
+
+func TestSubmitJSON(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	gt.work(t)
+	trun(t, gt.client, "git", "tag", "-f", "work.mailed")
+	clientHead := strings.TrimSpace(trun(t, gt.client, "git", "log", "-n", "1", "--format=format:%H"))
+
+	t.Log("> submit -json, abandoned")
+	srv.setJSON("I123456789", `{"_number": 123, "status": "ABANDONED"}`)
+	testMainDied(t, "submit", "-json")
+	testPrintedStdout(t, `{"commit":"`+clientHead+`","number":123,"change_id":"I123456789","status":"ABANDONED","error":"cannot submit: change abandoned"}`)
+	testNoStderr(t)
+
+	t.Log("> submit -json")
+	var (
+		newJSON    = `{"_number": 123, "status": "NEW", "mergeable": true, "current_revision": "` + clientHead + `", "labels": {"Code-Review": {"approved": {}}}}`
+		mergedJSON = `{"_number": 123, "status": "MERGED", "mergeable": true, "current_revision": "` + clientHead + `", "labels": {"Code-Review": {"approved": {}}}}`
+	)
+	submitted := false
+	srv.setReply("/a/changes/proj~main~I123456789", gerritReply{f: func() gerritReply {
+		if submitted {
+			return gerritReply{body: ")]}'\n" + mergedJSON}
+		}
+		return gerritReply{body: ")]}'\n" + newJSON}
+	}})
+	srv.setReply("/a/changes/proj~main~I123456789/submit", gerritReply{f: func() gerritReply {
+		submitted = true
+		return gerritReply{body: ")]}'\n" + mergedJSON}
+	}})
+	testMain(t, "submit", "-json")
+	testPrintedStdout(t, `{"commit":"`+clientHead+`","number":123,"change_id":"I123456789","status":"MERGED","merged":"`+clientHead+`"}`)
+	testNoStderr(t)
+}
+
+func TestSubmitVote(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	gt.work(t)
+	trun(t, gt.client, "git", "tag", "-f", "work.mailed")
+	clientHead := strings.TrimSpace(trun(t, gt.client, "git", "log", "-n", "1", "--format=format:%H"))
+
+	testMainDied(t, "submit", "-vote", "Code-Review=2")
+	testPrintedStderr(t, `invalid vote "Code-Review=2"`)
+	testRan(t) // nothing
+
+	var (
+		newJSON    = `{"status": "NEW", "mergeable": true, "current_revision": "` + clientHead + `", "labels": {"Code-Review": {}}}`
+		votedJSON  = `{"status": "NEW", "mergeable": true, "current_revision": "` + clientHead + `", "labels": {"Code-Review": {"approved": {}}}}`
+		mergedJSON = `{"status": "MERGED", "mergeable": true, "current_revision": "` + clientHead + `", "labels": {"Code-Review": {"approved": {}}}}`
+	)
+	voted, submitted := false, false
+	srv.setReply("/a/changes/proj~main~I123456789", gerritReply{f: func() gerritReply {
+		switch {
+		case submitted:
+			return gerritReply{body: ")]}'\n" + mergedJSON}
+		case voted:
+			return gerritReply{body: ")]}'\n" + votedJSON}
+		}
+		return gerritReply{body: ")]}'\n" + newJSON}
+	}})
+	srv.setReply("/a/changes/proj~main~I123456789/revisions/current/review", gerritReply{f: func() gerritReply {
+		voted = true
+		return gerritReply{body: ")]}'\n{}"}
+	}})
+	srv.setReply("/a/changes/proj~main~I123456789/submit", gerritReply{f: func() gerritReply {
+		submitted = true
+		return gerritReply{body: ")]}'\n" + mergedJSON}
+	}})
+
+	testMainDied(t, "submit")
+	testPrintedStderr(t, "cannot submit: change missing Code-Review approval")
+
+	testMain(t, "submit", "-vote", "Code-Review+2,Hold-1")
+	if !submitted {
+		t.Fatalf("change not submitted")
+	}
+	want := `{"labels":{"Code-Review":2,"Hold":-1}}`
+	if got := srv.posted["/a/changes/proj~main~I123456789/revisions/current/review"]; got != want {
+		t.Errorf("posted review %s, want %s", got, want)
+	}
+}
+
 func TestSubmitMultiple(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
@@ -280,3 +421,228 @@ func testSubmitMultiple(t *testing.T, gt *gitTest, srv *gerritServer) (*GerritCh
 	}})
 	return &cl1, &cl2
 }
+
+func TestSubmitAncestors(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	cl1, cl2 := testSubmitMultiple(t, gt, srv)
+
+	defer func(f func() bool) { stdinIsTerminal = f }(stdinIsTerminal)
+	stdinIsTerminal = func() bool { return true }
+	testMainDied(t, "submit", "HEAD")
+	testPrintedStderr(t, "submitting out of stack order", "Gerrit will also submit these unsubmitted changes",
+		cl1.CurrentRevision[:7], "submit anyway (y/n)?", "submit aborted")
+	testRan(t)
+	if cl2.Status != "NEW" {
+		t.Fatalf("want cl2.Status == NEW; got %v", cl2.Status)
+	}
+
+	// Without a terminal, or with -json, there is no question to answer.
+	for _, tt := range []struct {
+		terminal bool
+		args     []string
+	}{
+		{true, []string{"submit", "-json", "HEAD"}},
+		{false, []string{"submit", "HEAD"}},
+	} {
+		terminal := tt.terminal
+		stdinIsTerminal = func() bool { return terminal }
+		testMainDied(t, tt.args...)
+		testPrintedStderr(t, "Gerrit will also submit", cl1.CurrentRevision[:7],
+			"use 'git codereview submit -f' to submit anyway", "!(y/n)")
+		testNoStdout(t)
+		if cl2.Status != "NEW" {
+			t.Fatalf("want cl2.Status == NEW; got %v", cl2.Status)
+		}
+	}
+
+	testMain(t, "submit", "-f", "HEAD")
+	testPrintedStderr(t, "!will also submit")
+	if cl1.Status != "NEW" {
+		t.Fatalf("want cl1.Status == NEW; got %v", cl1.Status)
+	}
+	if cl2.Status != "MERGED" {
+		t.Fatalf("want cl2.Status == MERGED; got %v", cl2.Status)
+	}
+}
+
+func TestSubmitAncestorsMergedOnGerrit(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	cl1, cl2 := testSubmitMultiple(t, gt, srv)
+
+	// Gerrit has merged cl1, but the local origin branch does not know yet.
+	cl1.Status = "MERGED"
+	testMain(t, "submit", "HEAD")
+	testPrintedStderr(t, "!out of stack order", "!will also submit")
+	if cl2.Status != "MERGED" {
+		t.Fatalf("want cl2.Status == MERGED; got %v", cl2.Status)
+	}
+}
+
+func TestSubmitRetryConflict(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	srv := newGerritServer(t)
+	defer srv.done()
+	defer func(d time.Duration) { submitRetryDelay = d }(submitRetryDelay)
+	submitRetryDelay = time.Millisecond
+
+	gt.work(t)
+	trun(t, gt.client, "git", "tag", "-f", "work.mailed")
+	clientHead := strings.TrimSpace(trun(t, gt.client, "git", "log", "-n", "1", "--format=format:%H"))
+
+	var (
+		newJSON         = `{"status": "NEW", "mergeable": true, "current_revision": "` + clientHead + `", "labels": {"Code-Review": {"approved": {}}}}`
+		unmergeableJSON = `{"status": "NEW", "mergeable": false, "current_revision": "` + clientHead + `", "labels": {"Code-Review": {"approved": {}}}}`
+		mergedJSON      = `{"status": "MERGED", "mergeable": true, "current_revision": "` + clientHead + `", "labels": {"Code-Review": {"approved": {}}}}`
+	)
+	mergeable, submitted := false, false
+	conflicts := 0
+	srv.setReply("/a/changes/proj~main~I123456789", gerritReply{f: func() gerritReply {
+		switch {
+		case submitted:
+			return gerritReply{body: ")]}'\n" + mergedJSON}
+		case !mergeable:
+			return gerritReply{body: ")]}'\n" + unmergeableJSON}
+		}
+		return gerritReply{body: ")]}'\n" + newJSON}
+	}})
+	srv.setReply("/a/changes/proj~main~I123456789/submit", gerritReply{f: func() gerritReply {
+		if conflicts++; conflicts <= 2 {
+			return gerritReply{status: 409}
+		}
+		submitted = true
+		return gerritReply{body: ")]}'\n" + mergedJSON}
+	}})
+
+	// A conflict on an unmergeable change is final.
+	testMainDied(t, "submit")
+	testPrintedStderr(t, "cannot submit:", "409")
+	if conflicts != 1 {
+		t.Fatalf("submit requested %d times, want 1", conflicts)
+	}
+
+	// A conflict on a mergeable change is retried.
+	conflicts = 0
+	mergeable = true
+	testMain(t, "submit")
+	if !submitted || conflicts != 3 {
+		t.Fatalf("submitted=%v after %d requests, want true after 3", submitted, conflicts)
+	}
+}
+
+func TestSubmitQueue(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	gt.work(t)
+	trun(t, gt.client, "git", "tag", "-f", "work.mailed")
+	clientHead := strings.TrimSpace(trun(t, gt.client, "git", "log", "-n", "1", "--format=format:%H"))
+
+	testMainDied(t, "submit", "-queue", "-no-sync")
+	testPrintedStderr(t, "Usage")
+
+	submitted := false
+	srv.setJSON("I123456789", `{"status": "NEW", "mergeable": true, "current_revision": "`+clientHead+`", "labels": {"Code-Review": {}}}`)
+	srv.setReply("/a/changes/proj~main~I123456789/revisions/current/review", gerritReply{body: ")]}'\n{}"})
+	srv.setReply("/a/changes/proj~main~I123456789/submit", gerritReply{f: func() gerritReply {
+		submitted = true
+		return gerritReply{status: 500}
+	}})
+
+	testMain(t, "submit", "-queue", "-vote", "Code-Review+2")
+	testPrintedStderr(t, "queueing", "queued for the commit queue")
+	if submitted {
+		t.Fatalf("change submitted directly")
+	}
+	want := `{"labels":{"Code-Review":2,"Commit-Queue":2}}`
+	if got := srv.posted["/a/changes/proj~main~I123456789/revisions/current/review"]; got != want {
+		t.Errorf("posted review %s, want %s", got, want)
+	}
+
+	write(t, gt.client+"/codereview.cfg", "commit-queue-vote: Commit-Queue+1\n", 0644)
+	testMain(t, "submit", "-queue")
+	want = `{"labels":{"Commit-Queue":1}}`
+	if got := srv.posted["/a/changes/proj~main~I123456789/revisions/current/review"]; got != want {
+		t.Errorf("posted review %s, want %s", got, want)
+	}
+}
+
+func TestSubmitCheckCI(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	gt.work(t)
+	trun(t, gt.client, "git", "tag", "-f", "work.mailed")
+	clientHead := strings.TrimSpace(trun(t, gt.client, "git", "log", "-n", "1", "--format=format:%H"))
+
+	testMainDied(t, "submit", "-check-ci", "-queue")
+	testPrintedStderr(t, "Usage")
+
+	change := func(rev, labels string) {
+		srv.setJSON("I123456789", `{"status": "NEW", "mergeable": true, "current_revision": "`+rev+`", "labels": {"Code-Review": {"approved": {}}`+labels+`}}`)
+	}
+
+	change(clientHead, "")
+	testMainDied(t, "submit", "-check-ci")
+	testPrintedStderr(t, "cannot submit: change has no CI label (TryBot-Result, LUCI-TryBot-Result)")
+
+	change(clientHead, `, "TryBot-Result": {"all": [{"value": 0}]}`)
+	testMainDied(t, "submit", "-check-ci")
+	testPrintedStderr(t, "cannot submit: CI label TryBot-Result is pending")
+
+	change(clientHead, `, "TryBot-Result": {"all": [{"value": 1}, {"value": -1}]}`)
+	testMainDied(t, "submit", "-check-ci")
+	testPrintedStderr(t, "cannot submit: CI label TryBot-Result is failing")
+
+	change("1111111111111111111111111111111111111111", `, "TryBot-Result": {"approved": {}}`)
+	testMainDied(t, "submit", "-check-ci")
+	testPrintedStderr(t, "is not the revision tested by CI")
+
+	// Labels named by config replace the defaults.
+	write(t, gt.client+"/codereview.cfg", "ci-labels: Verified\n", 0644)
+	change(clientHead, `, "TryBot-Result": {"all": [{"value": -1}]}, "Verified": {"all": [{"value": 1}]}`)
+	srv.setReply("/a/changes/proj~main~I123456789/submit", gerritReply{status: 500})
+	testMainDied(t, "submit", "-check-ci")
+	testPrintedStderr(t, "!CI label")
+}
+
+func TestSubmitOldGerrit(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	srv := newGerritServer(t)
+	defer srv.done()
+	defer func() { submitOptionsUnsupported = false }()
+
+	gt.work(t)
+	trun(t, gt.client, "git", "tag", "-f", "work.mailed")
+	clientHead := strings.TrimSpace(trun(t, gt.client, "git", "log", "-n", "1", "--format=format:%H"))
+
+	// An older server rejects the SUBMITTABLE and SUBMIT_REQUIREMENTS options.
+	srv.setReply("/a/changes/proj~main~I123456789?o=LABELS&o=CURRENT_REVISION&o=SUBMITTABLE&o=SUBMIT_REQUIREMENTS",
+		gerritReply{status: 400, body: "bad option SUBMITTABLE\n"})
+	srv.setJSON("I123456789", `{"status": "NEW", "mergeable": true, "current_revision": "`+clientHead+`", "labels": {"Code-Review": {}}}`)
+	testMainDied(t, "submit")
+	testPrintedStderr(t, "cannot submit: change missing Code-Review approval")
+	if !submitOptionsUnsupported {
+		t.Fatalf("submit did not notice the rejected options")
+	}
+
+	srv.setJSON("I123456789", `{"status": "NEW", "mergeable": true, "current_revision": "`+clientHead+`", "labels": {"Code-Review": {"approved": {}}}}`)
+	srv.setReply("/a/changes/proj~main~I123456789/submit", gerritReply{status: 500})
+	testMainDied(t, "submit")
+	testPrintedStderr(t, "cannot submit: ", "500", "!approval", "!bad option")
+}
@@ -5,19 +5,51 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 func cmdSync(args []string) {
-	expectZeroArgs(args, "sync")
+	// NOTE: New flags should be added to the usage message below as well as doc.go.
+	var cont, noFetch, prune, rebaseMerges bool
+	flags.BoolVar(&cont, "continue", false, "continue after rebase conflicts")
+	flags.BoolVar(&noFetch, "no-fetch", false, "rebase onto the already-fetched upstream branch without fetching")
+	flags.BoolVar(&prune, "prune", false, "remove remote-tracking branches deleted on the server")
+	flags.BoolVar(&rebaseMerges, "rebase-merges", false, "preserve merge commits in pending changes when rebasing")
+	flags.Parse(args)
+	if len(flags.Args()) > 0 || cont && (noFetch || prune || rebaseMerges) || noFetch && prune {
+		fmt.Fprintf(stderr(), "Usage: %s sync %s [-continue | [-no-fetch | -prune] [-rebase-merges]]\n", progName, globalFlags)
+		exit(2)
+	}
+
+	if cont {
+		syncContinue()
+		return
+	}
+	if prune {
+		// Pull fetches only the upstream branch, so it cannot prune the others.
+		run("git", "remote", "prune", "origin")
+	}
+	syncPull(noFetch, rebaseMerges)
+}
 
+// syncPull pulls remote changes into the current branch,
+// rebasing any pending changes on top of them.
+// If noFetch is set, it rebases onto the already-fetched upstream branch.
+// If rebaseMerges is set, the rebase keeps any merge commits
+// among the pending changes instead of flattening them.
+func syncPull(noFetch, rebaseMerges bool) {
 	// Get current branch and commit ID for fixup after pull.
 	b := CurrentBranch()
 	b.NeedOriginBranch("sync")
+	if b.HasPendingCommit() && b.Branchpoint() == "" {
+		dief("cannot sync: %s shares no history with %s", b.Name, b.OriginBranch())
+	}
 	var id string
 	if work := b.Pending(); len(work) > 0 {
 		id = work[0].ChangeID
@@ -60,10 +92,37 @@ func cmdSync(args []string) {
 	//	hint: use --reapply-cherry-picks to include skipped commits
 	//	hint: Disable this message with "git config advice.skippedCherryPicks false"
 	//
-	if *verbose > 1 {
-		run("git", "-c", "advice.skippedCherryPicks=false", "pull", "-q", "-r", "-v", "origin", strings.TrimPrefix(b.OriginBranch(), "origin/"))
+	// With -no-fetch, rebase onto the local copy of the upstream branch
+	// as of the last fetch instead.
+	// With -rebase-merges, ask git to recreate merge commits.
+	var pull []string
+	if noFetch {
+		pull = []string{"-c", "advice.skippedCherryPicks=false", "rebase", "-q"}
+		if rebaseMerges {
+			pull = append(pull, "--rebase-merges")
+		}
+		pull = append(pull, b.OriginBranch())
 	} else {
-		run("git", "-c", "advice.skippedCherryPicks=false", "pull", "-q", "-r", "origin", strings.TrimPrefix(b.OriginBranch(), "origin/"))
+		rebase := "-r"
+		if rebaseMerges {
+			rebase = "--rebase=merges"
+		}
+		pull = []string{"-c", "advice.skippedCherryPicks=false", "pull", "-q", rebase}
+		if *verbose > 1 {
+			pull = append(pull, "-v")
+		}
+		pull = append(pull, "origin", strings.TrimPrefix(b.OriginBranch(), "origin/"))
+	}
+	if err := runErr("git", pull...); err != nil {
+		if _, err := os.Stat(filepath.Join(gitPathDir(), "rebase-merge")); err == nil {
+			dief("sync stopped with conflicts.\n" +
+				"Please fix them, use 'git add' to mark them resolved,\n" +
+				"and then run 'git codereview sync -continue' to continue.")
+		}
+		if *verbose == 0 {
+			fmt.Fprintf(stderr(), "(running: %s)\n", commandString("git", pull))
+		}
+		dief("%v", err)
 	}
 
 	b = CurrentBranch() // discard any cached information
@@ -71,7 +130,69 @@ func cmdSync(args []string) {
 		// If the change commit has been submitted,
 		// roll back change leaving any changes unstaged.
 		// Pull should have done this for us, but check just in case.
-		run("git", "reset", b.Branchpoint())
+		syncRollback(b)
+	}
+}
+
+// syncContinue finishes a sync whose rebase stopped with conflicts,
+// after the user has resolved them.
+func syncContinue() {
+	dir := filepath.Join(gitPathDir(), "rebase-merge")
+	if _, err := os.Stat(dir); err != nil {
+		dief("cannot sync -continue: no rebase in progress")
+	}
+
+	// Find the Change-Id of the original pending commit
+	// for the rollback check that cmdSync does after the pull.
+	var id string
+	if data, err := os.ReadFile(filepath.Join(dir, "orig-head")); err == nil {
+		msg := cmdOutput("git", "log", "-n", "1", "--format=format:%B", strings.TrimSpace(string(data)))
+		for _, line := range lines(msg) {
+			if strings.HasPrefix(line, "Change-Id: ") {
+				id = line[len("Change-Id: "):]
+			}
+		}
+	}
+
+	// Keep git from opening an editor for the commit messages
+	// of the commits whose conflicts were resolved.
+	// Set GIT_EDITOR, not core.editor: it takes priority over
+	// core.editor as well as VISUAL and EDITOR.
+	args := []string{"rebase", "--continue"}
+	if err := runEnvErr(append(os.Environ(), "GIT_EDITOR=true"), "git", args...); err != nil {
+		if *verbose == 0 {
+			fmt.Fprintf(stderr(), "(running: %s)\n", commandString("git", args))
+		}
+		dief("%v", err)
+	}
+
+	b := CurrentBranch()
+	if len(b.Pending()) == 1 && b.Submitted(id) {
+		syncRollback(b)
+	}
+}
+
+// syncRollback rolls back the single pending commit on b,
+// which has already been submitted, leaving any changes unstaged.
+// If the local commit contains edits beyond the submitted version,
+// or if the working directory is not clean, syncRollback says so,
+// rather than surprise the user with unexpected unstaged changes.
+func syncRollback(b *Branch) {
+	if HasStagedChanges() || HasUnstagedChanges() {
+		printf("warning: change was submitted, but not rolling back local commit: uncommitted changes exist\n" +
+			"\trun 'git status' to see changes")
+		return
+	}
+	branchpoint := b.Branchpoint()
+	if branchpoint == "" {
+		printf("warning: change was submitted, but not rolling back local root commit")
+		return
+	}
+	extra := nonBlankLines(cmdOutput("git", "diff", "--name-only", branchpoint, b.Pending()[0].Hash, "--"))
+	run("git", "reset", branchpoint)
+	if len(extra) > 0 {
+		printf("warning: local commit differs from submitted change; differences left as unstaged changes in:\n\t%s",
+			strings.Join(extra, "\n\t"))
 	}
 }
 
@@ -132,15 +253,22 @@ func cmdSyncBranch(args []string) {
 	os.Setenv("GIT_EDITOR", ":")       // do not bring up editor during merge, commit
 	os.Setenv("GIT_GOFMT_HOOK", "off") // do not require gofmt during merge
 
-	var cont, mergeBackToParent bool
+	var cont, mergeBackToParent, showStatus, dryRun bool
 	flags.BoolVar(&cont, "continue", false, "continue after merge conflicts")
+	flags.BoolVar(&dryRun, "dry-run", false, "report the commits and conflicts a merge would bring in, without merging")
 	flags.BoolVar(&mergeBackToParent, "merge-back-to-parent", false, "for shutting down the dev branch")
+	flags.BoolVar(&showStatus, "status", false, "report the state of an in-progress sync-branch")
 	flags.Parse(args)
-	if len(flag.Args()) > 0 {
-		fmt.Fprintf(stderr(), "Usage: %s sync-branch %s [-continue]\n", progName, globalFlags)
+	if len(flag.Args()) > 0 || cont && showStatus || dryRun && (cont || showStatus || mergeBackToParent) {
+		fmt.Fprintf(stderr(), "Usage: %s sync-branch %s [-continue | -dry-run | -status]\n", progName, globalFlags)
 		exit(2)
 	}
 
+	if showStatus {
+		syncBranchShowStatus()
+		return
+	}
+
 	parent := config()["parent-branch"]
 	if parent == "" {
 		dief("cannot sync-branch: codereview.cfg does not list parent-branch")
@@ -160,6 +288,11 @@ func cmdSyncBranch(args []string) {
 			"\trun 'git codereview pending' to see them")
 	}
 
+	if dryRun {
+		syncBranchDryRun(parent, branch)
+		return
+	}
+
 	if cont {
 		// Note: There is no -merge-back-to-parent -continue
 		// because -merge-back-to-parent never has merge conflicts.
@@ -186,7 +319,7 @@ func cmdSyncBranch(args []string) {
 
 	// Make sure client is up-to-date on current branch.
 	// Note that this does a remote fetch of b.OriginBranch() (aka branch).
-	cmdSync(nil)
+	syncPull(false, false)
 
 	// Pull down parent commits too.
 	quiet := "-q"
@@ -262,12 +395,7 @@ func cmdSyncBranch(args []string) {
 	if err != nil {
 		// Check whether the only listed file is codereview.cfg and try again if so.
 		// Build list of unmerged files.
-		for _, s := range nonBlankLines(cmdOutputDir(repoRoot(), "git", "status", "-b", "--porcelain")) {
-			// Unmerged status is anything with a U and also AA and DD.
-			if len(s) >= 4 && s[2] == ' ' && (s[0] == 'U' || s[1] == 'U' || s[0:2] == "AA" || s[0:2] == "DD") {
-				status.Conflicts = append(status.Conflicts, s[3:])
-			}
-		}
+		status.Conflicts = unmergedFiles()
 		if len(status.Conflicts) == 0 {
 			// Must have been codereview.cfg that was the problem.
 			// Try continuing the merge.
@@ -301,6 +429,102 @@ func cmdSyncBranch(args []string) {
 	syncBranchContinue("", b, status)
 }
 
+// syncBranchDryRun fetches the parent and current branches from origin
+// and reports the commits that merging parent into branch would bring in,
+// along with the files that would conflict, without changing anything
+// but the remote-tracking branches.
+func syncBranchDryRun(parent, branch string) {
+	quiet := "-q"
+	if *verbose > 0 {
+		quiet = "-v"
+	}
+	run("git", "fetch", quiet, "origin",
+		"refs/heads/"+branch+":refs/remotes/origin/"+branch,
+		"refs/heads/"+parent+":refs/remotes/origin/"+parent)
+
+	var buf bytes.Buffer
+	list := cmdOutput("git", "log", "--format=format:+ %cd %h %s", "--date=short", "origin/"+branch+"..origin/"+parent)
+	if list == "" {
+		fmt.Fprintf(&buf, "origin/%s has no new commits to merge into origin/%s\n", parent, branch)
+		stdout().Write(buf.Bytes())
+		return
+	}
+	fmt.Fprintf(&buf, "sync-branch would merge origin/%s (%.7s) into origin/%s (%.7s)\n\nMerge List:\n\n%s\n\n",
+		parent, gitHash("origin/"+parent), branch, gitHash("origin/"+branch), list)
+
+	// git merge-tree --write-tree (Git 2.38 and later) merges without
+	// touching the index or working tree. It prints the resulting tree
+	// followed by any conflicting files, and it exits 1 if there are conflicts.
+	out, err := cmdOutputErr("git", "merge-tree", "--write-tree", "--name-only", "--no-messages", "origin/"+branch, "origin/"+parent)
+	lines := nonBlankLines(out)
+	if len(lines) == 0 || strings.Trim(lines[0], "0123456789abcdef") != "" {
+		fmt.Fprintf(&buf, "Cannot predict conflicts: git merge-tree failed: %v\n", err)
+		stdout().Write(buf.Bytes())
+		return
+	}
+	var conflicts []string
+	for _, file := range lines[1:] {
+		// sync-branch always keeps the branch's codereview.cfg.
+		if file != "codereview.cfg" {
+			conflicts = append(conflicts, file)
+		}
+	}
+	if len(conflicts) == 0 {
+		fmt.Fprintf(&buf, "No merge conflicts expected.\n")
+	} else {
+		fmt.Fprintf(&buf, "Expected merge conflicts in:\n\t- %s\n", strings.Join(conflicts, "\n\t- "))
+	}
+	stdout().Write(buf.Bytes())
+}
+
+// syncBranchShowStatus prints the state of an in-progress sync-branch,
+// as recorded in the status file, and what to do next.
+func syncBranchShowStatus() {
+	if _, err := os.Stat(syncBranchStatusFile()); err != nil {
+		fmt.Fprintf(stdout(), "no sync-branch in progress\n")
+		return
+	}
+	status := readSyncBranchStatus()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "sync-branch in progress on %s:\n", status.Local)
+	fmt.Fprintf(&buf, "\tmerging origin/%s (%.7s) into origin/%s (%.7s)\n",
+		status.Parent, status.ParentHash, status.Branch, status.BranchHash)
+	if len(status.Conflicts) > 0 {
+		fmt.Fprintf(&buf, "\tconflicts:\n\t\t- %s\n", strings.Join(status.Conflicts, "\n\t\t- "))
+	}
+
+	_, err := cmdOutputErr("git", "rev-parse", "--abbrev-ref", "MERGE_HEAD")
+	merging := err == nil
+	switch {
+	case !merging:
+		fmt.Fprintf(&buf, "No merge is in progress, so the sync-branch status is stale.\n"+
+			"Run 'git codereview sync-branch' to start over.\n")
+	case len(unmergedFiles()) > 0:
+		fmt.Fprintf(&buf, "Some conflicts are not yet resolved (use 'git status' to see them).\n"+
+			"Fix them, then 'git add' or 'git rm' to resolve them,\n"+
+			"and then 'git codereview sync-branch -continue' to continue.\n"+
+			"Or run 'git merge --abort' to give up on this sync-branch.\n")
+	default:
+		fmt.Fprintf(&buf, "All conflicts are resolved.\n"+
+			"Run 'git codereview sync-branch -continue' to continue.\n"+
+			"Or run 'git merge --abort' to give up on this sync-branch.\n")
+	}
+	stdout().Write(buf.Bytes())
+}
+
+// unmergedFiles returns the files with unresolved merge conflicts.
+func unmergedFiles() []string {
+	var files []string
+	for _, s := range nonBlankLines(cmdOutputDir(repoRoot(), "git", "status", "-b", "--porcelain")) {
+		// Unmerged status is anything with a U and also AA and DD.
+		if len(s) >= 4 && s[2] == ' ' && (s[0] == 'U' || s[1] == 'U' || s[0:2] == "AA" || s[0:2] == "DD") {
+			files = append(files, s[3:])
+		}
+	}
+	return files
+}
+
 func diePendingMerge(cmd string) {
 	dief("cannot %s: found pending merge\n"+
 		"Run 'git codereview sync-branch -continue' if you fixed\n"+
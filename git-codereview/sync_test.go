@@ -51,6 +51,70 @@ func TestSync(t *testing.T) {
 	testNoStderr(t)
 }
 
+func TestSyncNoFetch(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// Suppress --reapply-cherry-picks hint.
+	trun(t, gt.client, "git", "config", "advice.skippedCherryPicks", "false")
+
+	gt.work(t)
+	gt.serverWorkUnrelated(t, "")
+	remote := strings.TrimSpace(trun(t, gt.server, "git", "rev-parse", "HEAD"))
+
+	// Without a fetch, sync -no-fetch does not see the server's new work.
+	testMain(t, "sync", "-no-fetch")
+	testNoStdout(t)
+	testNoStderr(t)
+	if b := CurrentBranch(); b.Branchpoint() == remote {
+		t.Fatalf("sync -no-fetch fetched from server")
+	}
+
+	// After a fetch, it rebases onto the fetched upstream.
+	trun(t, gt.client, "git", "fetch", "-q")
+	testMain(t, "sync", "-no-fetch")
+	testNoStdout(t)
+	testNoStderr(t)
+	b := CurrentBranch()
+	if b.Branchpoint() != remote {
+		t.Fatalf("branchpoint = %s after sync -no-fetch, want %s", b.Branchpoint(), remote)
+	}
+	if len(b.Pending()) != 1 {
+		t.Fatalf("have %d pending CLs after sync -no-fetch, want 1", len(b.Pending()))
+	}
+
+	// A submitted change is rolled back as usual.
+	gt.serverWork(t)
+	trun(t, gt.client, "git", "fetch", "-q")
+	testMain(t, "sync", "-no-fetch")
+	if b := CurrentBranch(); len(b.Pending()) != 0 {
+		t.Fatalf("have %d pending CLs after submitted sync -no-fetch, want 0", len(b.Pending()))
+	}
+}
+
+func TestSyncPrune(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	trun(t, gt.server, "git", "branch", "dev.gone")
+	trun(t, gt.client, "git", "fetch", "-q")
+	trun(t, gt.server, "git", "branch", "-D", "dev.gone")
+
+	testMainDied(t, "sync", "-prune", "-no-fetch")
+	testPrintedStderr(t, "Usage")
+
+	testMain(t, "sync")
+	if out := trun(t, gt.client, "git", "branch", "-r"); !strings.Contains(out, "origin/dev.gone") {
+		t.Fatalf("sync removed origin/dev.gone without -prune:\n%s", out)
+	}
+
+	testMain(t, "sync", "-prune")
+	testRan(t, "git remote prune origin", "git -c advice.skippedCherryPicks=false pull -q -r origin main")
+	if out := trun(t, gt.client, "git", "branch", "-r"); strings.Contains(out, "origin/dev.gone") {
+		t.Fatalf("sync -prune left origin/dev.gone:\n%s", out)
+	}
+}
+
 func TestSyncRebase(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
@@ -341,8 +405,45 @@ func TestSyncBranchMergeBack(t *testing.T) {
 	testNoStdout(t)
 	testPrintedStderr(t,
 		"git push -q origin HEAD:refs/for/main",
-		"git tag --no-sign -f dev.branch.mailed",
+		"git tag --no-sign --create-reflog -f dev.branch.mailed",
+	)
+}
+
+func TestSyncBranchDryRun(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMain(t, "change", "dev.branch")
+	testMain(t, "sync-branch", "-dry-run")
+	testPrintedStdout(t, "origin/main has no new commits to merge into origin/dev.branch")
+
+	gt.serverWork(t)
+	gt.serverWork(t)
+	trun(t, gt.server, "git", "checkout", "dev.branch")
+	gt.serverWork(t)
+	trun(t, gt.server, "git", "checkout", "main")
+	head := gitHash("HEAD")
+
+	testMain(t, "sync-branch", "-dry-run")
+	testHideRevHashes(t)
+	testPrintedStdout(t,
+		"sync-branch would merge origin/main (REVHASH) into origin/dev.branch (REVHASH)",
+		"Merge List:",
+		"+ DATE REVHASH msg #2",
+		"Expected merge conflicts in:\n\t- file\n",
 	)
+	if h := gitHash("HEAD"); h != head {
+		t.Fatalf("sync-branch -dry-run moved HEAD from %s to %s", head, h)
+	}
+	if _, err := os.Stat(syncBranchStatusFile()); err == nil {
+		t.Fatalf("sync-branch -dry-run wrote status file")
+	}
+	if _, err := cmdOutputErr("git", "rev-parse", "--verify", "--quiet", "MERGE_HEAD"); err == nil {
+		t.Fatalf("sync-branch -dry-run started a merge")
+	}
+
+	testMainDied(t, "sync-branch", "-dry-run", "-continue")
+	testPrintedStderr(t, "Usage:")
 }
 
 func TestSyncBranchConflict(t *testing.T) {
@@ -383,6 +484,17 @@ func TestSyncBranchConflict(t *testing.T) {
 	testDisallowed("change", "main")
 	testDisallowed("sync-branch")
 
+	testMain(t, "sync-branch", "-status")
+	testHideRevHashes(t)
+	testPrintedStdout(t,
+		"sync-branch in progress on dev.branch:",
+		"	merging origin/main (REVHASH) into origin/dev.branch (REVHASH)",
+		"	conflicts:",
+		"		- file",
+		"Some conflicts are not yet resolved",
+		"sync-branch -continue' to continue.",
+	)
+
 	// throw away server changes to resolve merge
 	trun(t, gt.client, "git", "checkout", "HEAD", "file")
 
@@ -390,6 +502,12 @@ func TestSyncBranchConflict(t *testing.T) {
 	testDisallowed("change", "main")
 	testDisallowed("sync-branch")
 
+	testMain(t, "sync-branch", "-status")
+	testPrintedStdout(t,
+		"All conflicts are resolved.",
+		"Run 'git codereview sync-branch -continue' to continue.",
+	)
+
 	testMain(t, "sync-branch", "-continue")
 	testHideRevHashes(t)
 	testPrintedStdout(t,
@@ -406,6 +524,9 @@ func TestSyncBranchConflict(t *testing.T) {
 		"Run 'git codereview mail' to send for review.",
 	)
 
+	testMain(t, "sync-branch", "-status")
+	testPrintedStdout(t, "no sync-branch in progress")
+
 	// Check that pending only shows the merge, not more commits.
 	testMain(t, "pending", "-c", "-l", "-s")
 	n := strings.Count(testStdout.String(), "+")
@@ -419,6 +540,111 @@ func TestSyncBranchConflict(t *testing.T) {
 	testNoStdout(t)
 	testPrintedStderr(t,
 		"git push -q origin HEAD:refs/for/dev.branch",
-		"git tag --no-sign -f dev.branch.mailed",
+		"git tag --no-sign --create-reflog -f dev.branch.mailed",
 	)
 }
+
+func TestSyncRollbackExtraEdits(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// Suppress --reapply-cherry-picks hint.
+	trun(t, gt.client, "git", "config", "advice.skippedCherryPicks", "false")
+
+	// Local commit has an extra edit that was not part of the submitted change.
+	gt.work(t)
+	write(t, gt.client+"/extrafile", "extra edit", 0644)
+	trun(t, gt.client, "git", "add", "extrafile")
+	trun(t, gt.client, "git", "commit", "-q", "--amend", "--no-edit")
+
+	gt.serverWork(t)
+
+	testMain(t, "sync")
+	testPrintedStderr(t, "local commit differs from submitted change", "extrafile")
+
+	b := CurrentBranch()
+	if len(b.Pending()) != 0 {
+		t.Fatalf("have %d pending CLs after sync, want 0", len(b.Pending()))
+	}
+	_, _, untracked := LocalChanges()
+	if len(untracked) != 1 || untracked[0] != "extrafile" {
+		t.Fatalf("untracked files = %v, want [extrafile]", untracked)
+	}
+	if data := read(t, gt.client+"/extrafile"); string(data) != "extra edit" {
+		t.Fatalf("extrafile = %q, want %q", data, "extra edit")
+	}
+}
+
+func TestSyncContinue(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMainDied(t, "sync", "-continue")
+	testPrintedStderr(t, "cannot sync -continue: no rebase in progress")
+
+	// Make conflicting edits to file on client and server.
+	gt.work(t)
+	write(t, gt.server+"/file", "server content", 0644)
+	trun(t, gt.server, "git", "commit", "-q", "-a", "-m", "server edit")
+
+	testMainDied(t, "sync")
+	testPrintedStderr(t, "sync stopped with conflicts", "git codereview sync -continue")
+
+	write(t, gt.client+"/file", "resolved content", 0644)
+	trun(t, gt.client, "git", "add", "file")
+
+	// An exported GIT_EDITOR must not be run for the resolved commit.
+	os.Setenv("GIT_EDITOR", "false")
+	defer os.Unsetenv("GIT_EDITOR")
+	testMain(t, "sync", "-continue")
+
+	if _, err := os.Stat(filepath.Join(gt.client, ".git/rebase-merge")); err == nil {
+		t.Fatalf("rebase still in progress after sync -continue")
+	}
+	b := CurrentBranch()
+	if b.Name != "work" {
+		t.Fatalf("on branch %q after sync -continue, want work", b.Name)
+	}
+	if len(b.Pending()) != 1 {
+		t.Fatalf("have %d pending CLs after sync -continue, want 1", len(b.Pending()))
+	}
+	if data := read(t, gt.client+"/file"); string(data) != "resolved content" {
+		t.Fatalf("file = %q, want %q", data, "resolved content")
+	}
+}
+
+func TestSyncRebaseMerges(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// Suppress --reapply-cherry-picks hint.
+	trun(t, gt.client, "git", "config", "advice.skippedCherryPicks", "false")
+
+	// Make a work branch containing a merge of a side branch.
+	gt.work(t)
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "side", "origin/main")
+	write(t, gt.client+"/sidefile", "side", 0644)
+	trun(t, gt.client, "git", "add", "sidefile")
+	trun(t, gt.client, "git", "commit", "-q", "-m", "dir: side work")
+	trun(t, gt.client, "git", "checkout", "-q", "work")
+	trun(t, gt.client, "git", "merge", "-q", "--no-ff", "-m", "dir: merge side", "side")
+
+	merges := func() int {
+		return len(nonBlankLines(trun(t, gt.client, "git", "rev-list", "--merges", "origin/main..refs/heads/work")))
+	}
+
+	gt.serverWorkUnrelated(t, "")
+	testMain(t, "sync", "-rebase-merges")
+	if n := merges(); n != 1 {
+		t.Fatalf("have %d merges after sync -rebase-merges, want 1", n)
+	}
+
+	gt.serverWorkUnrelated(t, "")
+	testMain(t, "sync")
+	if n := merges(); n != 0 {
+		t.Fatalf("have %d merges after sync, want 0", n)
+	}
+
+	testMainDied(t, "sync", "-continue", "-rebase-merges")
+	testPrintedStderr(t, "Usage")
+}
@@ -0,0 +1,70 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func cmdTrybot(args []string) {
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s trybot %s [commit]\n", progName, globalFlags)
+		exit(2)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) > 1 {
+		flags.Usage()
+		exit(2)
+	}
+
+	votes := trybotVotes()
+
+	b := CurrentBranch()
+	var c *Commit
+	if len(flags.Args()) == 1 {
+		c = b.CommitByRev("run trybots", flags.Arg(0))
+	} else {
+		c = b.DefaultCommit("run trybots", "must specify commit on command line")
+	}
+
+	g, err := b.GerritChange(c, "CURRENT_REVISION")
+	if err != nil {
+		dief("cannot run trybots: %v", err)
+	}
+	if g.CurrentRevision != c.Hash {
+		printf("warning: %s has not been mailed; trybots will run on the last mailed revision", c.ShortHash)
+	}
+
+	review := &GerritReviewInput{Labels: make(map[string]int)}
+	for _, v := range votes {
+		name, value := parseVote(v)
+		review.Labels[name] = value
+	}
+	js, err := json.Marshal(review)
+	if err != nil {
+		dief("%v", err)
+	}
+	if *noRun {
+		return
+	}
+	if err := gerritAPI("/a/changes/"+fullChangeID(b, c)+"/revisions/current/review", js, nil); err != nil {
+		dief("cannot run trybots: %v", err)
+	}
+	printf("started trybots for %s %s (%s).", c.ShortHash, c.Subject, strings.Join(votes, ", "))
+}
+
+// parseVote splits a vote like "Commit-Queue+1" into its label
+// name and value. A vote with no value, like "Run-TryBot", means +1.
+func parseVote(vote string) (name string, value int) {
+	if i := strings.LastIndexAny(vote, "+-"); i > 0 {
+		if n, err := strconv.Atoi(vote[i:]); err == nil {
+			return vote[:i], n
+		}
+	}
+	return vote, 1
+}
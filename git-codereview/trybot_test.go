@@ -0,0 +1,65 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTrybot(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	gt.work(t)
+	hash := strings.TrimSpace(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+	srv.setJSON("I123456789", `{"current_revision": "`+hash+`"}`)
+	srv.setReply("/a/changes/proj~main~I123456789/revisions/current/review", gerritReply{body: ")]}'\n{}"})
+
+	testMain(t, "trybot")
+	testRan(t)
+	testPrintedStderr(t, "started trybots", "Commit-Queue+1", "!warning")
+	if got, want := srv.posted["/a/changes/proj~main~I123456789/revisions/current/review"], `{"labels":{"Commit-Queue":1}}`; got != want {
+		t.Fatalf("posted %s, want %s", got, want)
+	}
+
+	defer os.Unsetenv("GIT_CODEREVIEW_TRYBOT")
+	os.Setenv("GIT_CODEREVIEW_TRYBOT", "both")
+	gt.work(t)
+	srv.setJSON("I223456789", `{"current_revision": "`+hash+`"}`)
+	srv.setReply("/a/changes/proj~main~I223456789/revisions/current/review", gerritReply{body: ")]}'\n{}"})
+	testMainDied(t, "trybot")
+	testPrintedStderr(t, "cannot run trybots: multiple changes pending")
+	testMain(t, "trybot", "HEAD")
+	testPrintedStderr(t, "warning: ", "has not been mailed")
+	if got, want := srv.posted["/a/changes/proj~main~I223456789/revisions/current/review"], `{"labels":{"Commit-Queue":1,"Run-TryBot":1}}`; got != want {
+		t.Fatalf("posted %s, want %s", got, want)
+	}
+
+	os.Setenv("GIT_CODEREVIEW_TRYBOT", "bogus")
+	testMainDied(t, "trybot")
+	testPrintedStderr(t, "GIT_CODEREVIEW_TRYBOT must be")
+}
+
+func TestParseVote(t *testing.T) {
+	for _, tt := range []struct {
+		vote  string
+		name  string
+		value int
+	}{
+		{"Commit-Queue+1", "Commit-Queue", 1},
+		{"Code-Review-2", "Code-Review", -2},
+		{"Run-TryBot", "Run-TryBot", 1},
+	} {
+		name, value := parseVote(tt.vote)
+		if name != tt.name || value != tt.value {
+			t.Errorf("parseVote(%q) = %q, %d, want %q, %d", tt.vote, name, value, tt.name, tt.value)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
@@ -433,9 +434,10 @@ func testNoStderr(t *testing.T) {
 }
 
 type gerritServer struct {
-	l     net.Listener
-	mu    sync.Mutex
-	reply map[string]gerritReply
+	l      net.Listener
+	mu     sync.Mutex
+	reply  map[string]gerritReply
+	posted map[string]string // request bodies of POSTs, by path
 }
 
 func newGerritServer(t *testing.T) *gerritServer {
@@ -452,7 +454,7 @@ func newGerritServer(t *testing.T) *gerritServer {
 	auth.user = "gopher"
 	auth.password = "PASSWORD"
 
-	s := &gerritServer{l: l, reply: make(map[string]gerritReply)}
+	s := &gerritServer{l: l, reply: make(map[string]gerritReply), posted: make(map[string]string)}
 	go http.Serve(l, s)
 	return s
 }
@@ -491,7 +493,14 @@ func (s *gerritServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	reply, ok := s.reply[req.URL.Path]
+	if req.Method == "POST" {
+		body, _ := io.ReadAll(req.Body)
+		s.posted[req.URL.Path] = string(body)
+	}
+	reply, ok := s.reply[req.URL.Path+"?"+req.URL.RawQuery]
+	if !ok {
+		reply, ok = s.reply[req.URL.Path]
+	}
 	if !ok {
 		http.NotFound(w, req)
 		return
@@ -0,0 +1,87 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func cmdVerify(args []string) {
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s verify %s [commit]\n", progName, globalFlags)
+		exit(2)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) > 1 {
+		flags.Usage()
+		exit(2)
+	}
+
+	b := CurrentBranch()
+	if len(b.Pending()) == 0 {
+		dief("cannot verify: no changes pending")
+	}
+	c := b.Pending()[0]
+	if len(flags.Args()) == 1 {
+		c = b.CommitByRev("verify", flags.Arg(0))
+	}
+
+	var errs []error
+	found := false
+	for _, c1 := range b.Pending() {
+		if c1 == c {
+			found = true
+		}
+		if found {
+			errs = append(errs, verifyCommit(c1)...)
+		}
+	}
+	if err := gofmtCheck(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		return
+	}
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, strings.Replace(err.Error(), "\n", "\n\t", -1))
+	}
+	dief("verify found problems:\n\t%s", strings.Join(msgs, "\n\t"))
+}
+
+// verifyCommit returns the problems found in the pending commit c
+// by the checks that the mail command and the commit hooks apply.
+func verifyCommit(c *Commit) []error {
+	errs := mailCommitErrors(c)
+	if err := mailMessageError(c.Message); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %v", c.ShortHash, err))
+	}
+	if len(ListFiles(c)) == 0 && len(c.Parents) == 1 {
+		errs = append(errs, fmt.Errorf("%s: commit is empty", c.ShortHash))
+	}
+	if !messageRE.MatchString(c.Message) {
+		errs = append(errs, fmt.Errorf("%s: CL description does not start with 'pkg/path: summary'", c.ShortHash))
+	}
+	if haveGerrit() {
+		switch n := strings.Count(c.Message, "\nChange-Id: "); {
+		case n == 0:
+			errs = append(errs, fmt.Errorf("%s: missing Change-Id", c.ShortHash))
+		case n > 1:
+			errs = append(errs, fmt.Errorf("%s: multiple Change-Id lines", c.ShortHash))
+		}
+	}
+	if len(c.Parents) == 1 {
+		// git diff --check reports whitespace errors too, but those are not our concern.
+		out, _ := cmdOutputErr("git", "diff", "--check", c.Parent, c.Hash, "--")
+		for _, line := range nonBlankLines(out) {
+			if strings.HasSuffix(line, ": leftover conflict marker") {
+				errs = append(errs, fmt.Errorf("%s: %s", c.ShortHash, line))
+			}
+		}
+	}
+	return errs
+}
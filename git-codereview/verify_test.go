@@ -0,0 +1,45 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestVerify(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMainDied(t, "verify")
+	testPrintedStderr(t, "cannot verify: no changes pending")
+
+	gt.work(t)
+	trun(t, gt.client, "git", "commit", "--amend", "-m", "dir: first change\n\nChange-Id: I123456789\n")
+	testMain(t, "verify")
+	testNoStdout(t)
+	testNoStderr(t)
+
+	// Pile up problems in a second commit.
+	write(t, gt.client+"/bad.go", badGo, 0644)
+	write(t, gt.client+"/file~", "temp", 0644)
+	write(t, gt.client+"/file", "<<<<<<< HEAD\nmine\n=======\ntheirs\n>>>>>>> other\n", 0644)
+	trun(t, gt.client, "git", "add", "bad.go", "file~", "file")
+	trun(t, gt.client, "git", "commit", "-m", "no package prefix\n\nDO NOT MAIL\n")
+
+	testMainDied(t, "verify")
+	testPrintedStderr(t, "verify found problems:",
+		"CL says DO NOT MAIL",
+		"cannot mail temporary files: file~",
+		"CL description does not start with 'pkg/path: summary'",
+		"file:1: leftover conflict marker",
+		"gofmt needs to format these files", "bad.go")
+
+	// Naming the first commit skips the second's commit checks,
+	// but gofmt still applies to the whole branch.
+	testMainDied(t, "verify", "HEAD^")
+	testPrintedStderr(t, "!DO NOT MAIL", "!file~", "gofmt needs to format these files")
+
+	gt.enableGerrit(t)
+	testMainDied(t, "verify")
+	testPrintedStderr(t, "verify found problems:", "missing Change-Id")
+}